@@ -21,6 +21,7 @@ package bunny
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -28,6 +29,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"sync"
 	"time"
 )
 
@@ -35,6 +37,7 @@ type Client struct {
 	BaseURL    *url.URL
 	AccessKey  string
 	httpClient *http.Client
+	limiter    *rateLimiter
 }
 
 type ErrorResponse struct {
@@ -82,6 +85,48 @@ func NewClient(key string) (*Client, error) {
 	return c, nil
 }
 
+// SetRateLimit limits the number of requests per second the client sends,
+// shared between all goroutines using it. A limit of zero or less removes
+// the limit. It should be set before the client is used.
+func (c *Client) SetRateLimit(requestsPerSecond float64) {
+	if requestsPerSecond <= 0 {
+		c.limiter = nil
+		return
+	}
+	c.limiter = &rateLimiter{interval: time.Duration(float64(time.Second) / requestsPerSecond)}
+}
+
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// wait blocks until the next request may be sent, or the context is done.
+func (l *rateLimiter) wait(ctx context.Context) error {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	at := l.next
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+
+	d := time.Until(at)
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func (c *Client) newRequest(method, path string, rawquery string, body interface{}) (*http.Request, error) {
 	rel := &url.URL{Path: path}
 	u := c.BaseURL.ResolveReference(rel)
@@ -114,6 +159,12 @@ func (c *Client) newRequest(method, path string, rawquery string, body interface
 }
 
 func (c *Client) do(req *http.Request, v interface{}) (*http.Response, error) {
+	if c.limiter != nil {
+		if err := c.limiter.wait(req.Context()); err != nil {
+			return nil, err
+		}
+	}
+
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return nil, err
@@ -132,6 +183,8 @@ func (c *Client) do(req *http.Request, v interface{}) (*http.Response, error) {
 				return resp, errors.New("unauthorized")
 			case 404:
 				return resp, errors.New("not found")
+			case 429:
+				return resp, errors.New("too many requests")
 			case 500:
 				return resp, errors.New("server error")
 			}
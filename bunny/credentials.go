@@ -0,0 +1,200 @@
+// Copyright (c) 2021 Jan Koppe
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package bunny
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+type CredentialKind int32
+
+const (
+	CKStoragePassword         CredentialKind = 0
+	CKStorageReadOnlyPassword CredentialKind = 1
+	CKPullZoneSecurityKey     CredentialKind = 2
+)
+
+func (k CredentialKind) String() string {
+	switch k {
+	case CKStoragePassword:
+		return "storage password"
+	case CKStorageReadOnlyPassword:
+		return "storage read-only password"
+	case CKPullZoneSecurityKey:
+		return "pull zone security key"
+	}
+	return fmt.Sprintf("CredentialKind(%d)", int32(k))
+}
+
+// RotatedCredential is a credential that was just reset. Region is only set
+// for StorageZones.
+type RotatedCredential struct {
+	Kind     CredentialKind
+	ZoneID   int64
+	ZoneName string
+	Region   StorageRegion
+	Value    string
+}
+
+// RotateOptions configures the Rotate functions. The zero value only resets
+// the credential and returns it.
+type RotateOptions struct {
+	// check a new StorageZone password with a directory listing before
+	// returning. Not supported for PullZones, RotatePullZoneToken fails if
+	// it's set.
+	Verify bool
+	// retries of a failed verification, as a reset password can take a
+	// moment to reach all storage servers. Defaults to 3.
+	VerifyRetries int
+	// delay between verification attempts, defaults to 2 seconds
+	VerifyDelay time.Duration
+	// called with the new credential after it was fetched and verified,
+	// e.g. to update a secret store. Its error is returned as is. The old
+	// credential is already invalid at that point.
+	OnRotate func(RotatedCredential) error
+}
+
+// RotateStorageZonePassword resets the password of a StorageZone and returns
+// the zone with the new password.
+func (c *Client) RotateStorageZonePassword(ctx context.Context, zoneID int64, opts *RotateOptions) (*StorageZone, error) {
+	return c.rotateStorageZone(ctx, zoneID, CKStoragePassword, opts)
+}
+
+// RotateStorageZoneReadOnlyPassword resets the read-only password of a
+// StorageZone and returns the zone with the new password.
+func (c *Client) RotateStorageZoneReadOnlyPassword(ctx context.Context, zoneID int64, opts *RotateOptions) (*StorageZone, error) {
+	return c.rotateStorageZone(ctx, zoneID, CKStorageReadOnlyPassword, opts)
+}
+
+func (c *Client) rotateStorageZone(ctx context.Context, zoneID int64, kind CredentialKind, opts *RotateOptions) (*StorageZone, error) {
+	o := RotateOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.VerifyRetries == 0 {
+		o.VerifyRetries = 3
+	} else if o.VerifyRetries < 0 {
+		o.VerifyRetries = 0
+	}
+	if o.VerifyDelay <= 0 {
+		o.VerifyDelay = 2 * time.Second
+	}
+
+	password := func(sz *StorageZone) string {
+		if kind == CKStorageReadOnlyPassword {
+			return sz.ReadOnlyPassword
+		}
+		return sz.Password
+	}
+
+	old, err := c.GetStorageZone(zoneID)
+	if err != nil {
+		return nil, err
+	}
+	if kind == CKStorageReadOnlyPassword {
+		err = c.ResetStorageZoneReadOnlyPassword(zoneID)
+	} else {
+		err = c.ResetStorageZonePassword(zoneID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sz, err := c.GetStorageZone(zoneID)
+	if err != nil {
+		return nil, fmt.Errorf("%v was reset, but fetching it failed: %w", kind, err)
+	}
+	if password(sz) == "" || password(sz) == password(old) {
+		return nil, fmt.Errorf("%v of storage zone %v did not change", kind, sz.Name)
+	}
+
+	if o.Verify {
+		if err := verifyStoragePassword(ctx, sz, password(sz), o); err != nil {
+			return sz, fmt.Errorf("verifying the new %v: %w", kind, err)
+		}
+	}
+	if o.OnRotate != nil {
+		if err := o.OnRotate(RotatedCredential{kind, sz.ID, sz.Name, sz.Region, password(sz)}); err != nil {
+			return sz, err
+		}
+	}
+	return sz, nil
+}
+
+func verifyStoragePassword(ctx context.Context, sz *StorageZone, password string, o RotateOptions) error {
+	s, err := NewStorageClient(sz.Name, password, string(sz.Region))
+	if err != nil {
+		return err
+	}
+	for attempt := 0; ; attempt++ {
+		_, err = s.ListDirectory(ctx, "/")
+		if err == nil || attempt >= o.VerifyRetries || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return err
+		}
+
+		t := time.NewTimer(o.VerifyDelay)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// RotatePullZoneToken resets the security key used for token authentication
+// of a PullZone, and returns the zone with the new key. There is no way to
+// verify a security key, so Verify must not be set in opts.
+func (c *Client) RotatePullZoneToken(ctx context.Context, zoneID int64, opts *RotateOptions) (*PullZone, error) {
+	o := RotateOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.Verify {
+		return nil, fmt.Errorf("verifying a %v is not supported", CKPullZoneSecurityKey)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	old, err := c.GetPullZone(zoneID)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.ResetPullZoneToken(zoneID); err != nil {
+		return nil, err
+	}
+	pz, err := c.GetPullZone(zoneID)
+	if err != nil {
+		return nil, fmt.Errorf("%v was reset, but fetching it failed: %w", CKPullZoneSecurityKey, err)
+	}
+	if pz.ZoneSecurityKey == "" || pz.ZoneSecurityKey == old.ZoneSecurityKey {
+		return nil, fmt.Errorf("%v of pull zone %v did not change", CKPullZoneSecurityKey, pz.Name)
+	}
+
+	if o.OnRotate != nil {
+		if err := o.OnRotate(RotatedCredential{CKPullZoneSecurityKey, pz.ID, pz.Name, "", pz.ZoneSecurityKey}); err != nil {
+			return pz, err
+		}
+	}
+	return pz, nil
+}
@@ -0,0 +1,109 @@
+// Copyright (c) 2021 Jan Koppe
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package bunny
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRotateCredentials(t *testing.T) {
+	storage := &fakeStorage{zone: "assets", files: map[string]fakeStorageFile{}}
+	storageSrv := httptest.NewServer(storage)
+	defer storageSrv.Close()
+	os.Setenv("BUNNYCDN_STORAGE_URL", storageSrv.URL)
+	defer os.Unsetenv("BUNNYCDN_STORAGE_URL")
+
+	var mu sync.Mutex
+	sz := StorageZone{ID: 3, Name: "assets", Password: "old", ReadOnlyPassword: "old-ro"}
+	pz := PullZone{ID: 7, Name: "site", ZoneSecurityKey: "old-key"}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method + " " + r.URL.Path {
+		case "GET /storagezone/3":
+			_ = json.NewEncoder(w).Encode(sz)
+		case "POST /storagezone/resetPassword":
+			// the fake storage only accepts "password"
+			sz.Password = "password"
+		case "POST /storagezone/resetReadOnlyPassword":
+			sz.ReadOnlyPassword = "rejected"
+		case "GET /pullzone/7":
+			_ = json.NewEncoder(w).Encode(pz)
+		case "POST /pullzone/7/resetSecurityKey":
+			pz.ZoneSecurityKey = "new-key"
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	c, err := NewClient("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.BaseURL, _ = url.Parse(srv.URL)
+	ctx := context.Background()
+
+	rotated := []RotatedCredential{}
+	opts := &RotateOptions{Verify: true, VerifyRetries: 1, VerifyDelay: time.Millisecond, OnRotate: func(rc RotatedCredential) error {
+		rotated = append(rotated, rc)
+		return nil
+	}}
+
+	zone, err := c.RotateStorageZonePassword(ctx, 3, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if zone.Password != "password" || len(rotated) != 1 || rotated[0] != (RotatedCredential{CKStoragePassword, 3, "assets", "", "password"}) {
+		t.Errorf("unexpected rotation %+v %+v", zone, rotated)
+	}
+
+	// the fake storage rejects the new read-only password
+	_, err = c.RotateStorageZoneReadOnlyPassword(ctx, 3, opts)
+	if err == nil || len(rotated) != 1 {
+		t.Errorf("failed verification should be reported and skip the callback, got %v", err)
+	}
+
+	// a second reset of the same value is detected
+	_, err = c.RotateStorageZonePassword(ctx, 3, nil)
+	if err == nil {
+		t.Error("unchanged passwords should be reported")
+	}
+
+	if _, err := c.RotatePullZoneToken(ctx, 7, opts); err == nil {
+		t.Error("verifying a pull zone token should be refused")
+	}
+	opts.Verify = false
+	zonePZ, err := c.RotatePullZoneToken(ctx, 7, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if zonePZ.ZoneSecurityKey != "new-key" || len(rotated) != 2 || rotated[1].Kind != CKPullZoneSecurityKey {
+		t.Errorf("unexpected pull zone rotation %+v %+v", zonePZ, rotated)
+	}
+}
@@ -0,0 +1,475 @@
+// Copyright (c) 2021 Jan Koppe
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package bunny
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The Edge Storage API is separate from the main API. It lives on regional
+// endpoints, and is authenticated with the password of the StorageZone
+// instead of the account's access key.
+
+// StorageEndpoint returns the Edge Storage API hostname for the primary
+// region of a StorageZone.
+func StorageEndpoint(region string) string {
+	r := strings.ToLower(region)
+	if r == "" || r == "de" || r == "falkenstein" {
+		return "storage.bunnycdn.com"
+	}
+	return r + ".storage.bunnycdn.com"
+}
+
+type StorageClient struct {
+	BaseURL    *url.URL
+	ZoneName   string
+	AccessKey  string
+	httpClient *http.Client
+}
+
+// StorageErrorResponse is returned by the Edge Storage API for failed
+// requests. It matches os.ErrNotExist for missing objects.
+type StorageErrorResponse struct {
+	HTTPCode int `json:"HttpCode"`
+	Message  string
+}
+
+func (r *StorageErrorResponse) Error() string {
+	return fmt.Sprintf("%v, HttpCode: %v", r.Message, r.HTTPCode)
+}
+
+func (r *StorageErrorResponse) Is(target error) bool {
+	return target == os.ErrNotExist && r.HTTPCode == http.StatusNotFound
+}
+
+// StorageObject is a file or directory in a StorageZone. Path is the
+// directory containing the object, starting with the name of the zone.
+type StorageObject struct {
+	Guid            string
+	StorageZoneName string
+	Path            string
+	ObjectName      string
+	Length          int64
+	LastChanged     BunnyTime
+	DateCreated     BunnyTime
+	IsDirectory     bool
+	ServerID        int64  `json:"ServerId"`
+	UserID          string `json:"UserId"`
+	StorageZoneID   int64  `json:"StorageZoneId"`
+	ArrayNumber     int
+	ReplicatedZones string
+	Checksum        string
+	ContentType     string
+}
+
+// RelativePath returns the path of the object within its StorageZone,
+// without a leading slash.
+func (o StorageObject) RelativePath() string {
+	dir := strings.TrimPrefix(o.Path, "/")
+	dir = strings.TrimPrefix(dir, o.StorageZoneName)
+	return strings.TrimPrefix(path.Join(dir, o.ObjectName), "/")
+}
+
+// NewStorageClient creates a client for the Edge Storage API of a single
+// StorageZone. The endpoint is derived from the region of the zone, and can
+// be overridden with the BUNNYCDN_STORAGE_URL environment variable.
+func NewStorageClient(zoneName string, password string, region string) (*StorageClient, error) {
+	if zoneName == "" {
+		return nil, errors.New("required storage zone name not provided")
+	}
+	if password == "" {
+		return nil, errors.New("required storage zone password not provided")
+	}
+
+	baseurl := "https://" + StorageEndpoint(region) + "/"
+
+	if envurl := os.Getenv("BUNNYCDN_STORAGE_URL"); envurl != "" {
+		baseurl = envurl
+	}
+
+	u, err := url.Parse(baseurl)
+	if err != nil {
+		return nil, err
+	}
+
+	// no overall timeout, transfers of large files take as long as they take
+	h := &http.Client{}
+
+	s := &StorageClient{
+		BaseURL:    u,
+		ZoneName:   zoneName,
+		AccessKey:  password,
+		httpClient: h,
+	}
+
+	return s, nil
+}
+
+// NewStorageClientForZone creates a client for the Edge Storage API of a
+// StorageZone as returned by GetStorageZone.
+func NewStorageClientForZone(sz *StorageZone) (*StorageClient, error) {
+	return NewStorageClient(sz.Name, sz.Password, string(sz.Region))
+}
+
+// cleanStoragePath turns a path into the form used in URLs, without leading
+// or trailing slashes. Paths can't leave the StorageZone.
+func cleanStoragePath(p string) (string, error) {
+	for _, seg := range strings.Split(p, "/") {
+		if seg == ".." {
+			return "", fmt.Errorf("invalid storage path %q", p)
+		}
+	}
+	return strings.Trim(path.Clean("/"+p), "/"), nil
+}
+
+func (s *StorageClient) newRequest(ctx context.Context, method string, p string, dir bool, body io.Reader) (*http.Request, error) {
+	clean, err := cleanStoragePath(p)
+	if err != nil {
+		return nil, err
+	}
+
+	segs := []string{url.PathEscape(s.ZoneName)}
+	if clean != "" {
+		for _, seg := range strings.Split(clean, "/") {
+			segs = append(segs, url.PathEscape(seg))
+		}
+	}
+	rawpath := "/" + strings.Join(segs, "/")
+	if dir {
+		rawpath += "/"
+	}
+
+	rel, err := url.Parse(strings.TrimPrefix(rawpath, "/"))
+	if err != nil {
+		return nil, err
+	}
+	u := s.BaseURL.ResolveReference(rel)
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+
+	req.Header.Set("User-Agent", "go-bunnynet/dev")
+	req.Header.Set("AccessKey", s.AccessKey)
+
+	return req, nil
+}
+
+// do sends the request and returns the response for 2xx status codes. The
+// caller has to close the body.
+func (s *StorageClient) do(req *http.Request) (*http.Response, error) {
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+
+	defer resp.Body.Close()
+	msg := StorageErrorResponse{}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err := json.Unmarshal(body, &msg); err != nil || msg.Message == "" {
+		msg.Message = strings.TrimSpace(string(body))
+		if msg.Message == "" {
+			msg.Message = http.StatusText(resp.StatusCode)
+		}
+	}
+	msg.HTTPCode = resp.StatusCode
+	return resp, &msg
+}
+
+// PutFileOptions holds the optional parameters of PutFile.
+type PutFileOptions struct {
+	// hex encoded SHA256 of the content. The upload is rejected by bunny
+	// if the content doesn't match.
+	Checksum string
+	// length of the content, if known. Readers from the bytes and strings
+	// packages are detected automatically.
+	ContentLength int64
+	// defaults to application/octet-stream
+	ContentType string
+}
+
+// PutFile uploads a file, streaming its content from r. Missing directories
+// are created automatically, existing files are replaced.
+func (s *StorageClient) PutFile(ctx context.Context, p string, r io.Reader, opts *PutFileOptions) error {
+	o := PutFileOptions{}
+	if opts != nil {
+		o = *opts
+	}
+
+	req, err := s.newRequest(ctx, "PUT", p, false, r)
+	if err != nil {
+		return err
+	}
+	if o.ContentLength > 0 {
+		req.ContentLength = o.ContentLength
+	}
+	if o.ContentType == "" {
+		o.ContentType = "application/octet-stream"
+	}
+	req.Header.Set("Content-Type", o.ContentType)
+	if o.Checksum != "" {
+		req.Header.Set("Checksum", strings.ToUpper(o.Checksum))
+	}
+
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// StorageFile is the content of a file downloaded with GetFile. It has to be
+// closed after reading.
+type StorageFile struct {
+	io.ReadCloser
+	// length of the returned content
+	Size int64
+	// length of the whole file, differs from Size for ranged requests
+	TotalSize    int64
+	ContentType  string
+	LastModified time.Time
+}
+
+// GetFile downloads a file. The content is streamed from the returned
+// StorageFile.
+func (s *StorageClient) GetFile(ctx context.Context, p string) (*StorageFile, error) {
+	return s.GetFileRange(ctx, p, 0, -1)
+}
+
+// GetFileRange downloads length bytes of a file starting at offset. A negative
+// length reads until the end of the file.
+func (s *StorageClient) GetFileRange(ctx context.Context, p string, offset int64, length int64) (*StorageFile, error) {
+	if offset < 0 {
+		return nil, fmt.Errorf("invalid offset %d", offset)
+	}
+	if length == 0 {
+		return nil, errors.New("invalid length 0")
+	}
+
+	req, err := s.newRequest(ctx, "GET", p, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	ranged := offset > 0 || length > 0
+	if ranged {
+		end := ""
+		if length > 0 {
+			end = strconv.FormatInt(offset+length-1, 10)
+		}
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%v", offset, end))
+	}
+
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &StorageFile{
+		ReadCloser:  resp.Body,
+		Size:        resp.ContentLength,
+		TotalSize:   resp.ContentLength,
+		ContentType: resp.Header.Get("Content-Type"),
+	}
+	if lm, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		f.LastModified = lm
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent:
+		// Content-Range: bytes 0-99/1234
+		cr := resp.Header.Get("Content-Range")
+		if i := strings.LastIndex(cr, "/"); i >= 0 {
+			if total, err := strconv.ParseInt(cr[i+1:], 10, 64); err == nil {
+				f.TotalSize = total
+			}
+		}
+	case ranged:
+		// the range was ignored, so skip to the requested part ourselves
+		if _, err := io.CopyN(ioutil.Discard, resp.Body, offset); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		size := resp.ContentLength - offset
+		if length > 0 && (size < 0 || length < size) {
+			size = length
+		}
+		f.Size = size
+		f.ReadCloser = struct {
+			io.Reader
+			io.Closer
+		}{io.LimitReader(resp.Body, size), resp.Body}
+	}
+
+	return f, nil
+}
+
+// CreateDirectory creates an empty directory. Directories are also created
+// implicitly when uploading files into them.
+func (s *StorageClient) CreateDirectory(ctx context.Context, p string) error {
+	clean, err := cleanStoragePath(p)
+	if err != nil {
+		return err
+	}
+	if clean == "" {
+		return errors.New("the root directory of the storage zone always exists")
+	}
+	req, err := s.newRequest(ctx, "PUT", clean, true, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// DeleteFile deletes a single file.
+func (s *StorageClient) DeleteFile(ctx context.Context, p string) error {
+	return s.delete(ctx, p, false)
+}
+
+// DeleteDirectory deletes a directory including everything in it.
+func (s *StorageClient) DeleteDirectory(ctx context.Context, p string) error {
+	clean, err := cleanStoragePath(p)
+	if err != nil {
+		return err
+	}
+	if clean == "" {
+		return errors.New("refusing to delete the root directory of the storage zone")
+	}
+	return s.delete(ctx, p, true)
+}
+
+func (s *StorageClient) delete(ctx context.Context, p string, dir bool) error {
+	req, err := s.newRequest(ctx, "DELETE", p, dir, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// ListDirectory lists the files and directories directly inside a directory.
+// Use "" or "/" for the root of the StorageZone.
+func (s *StorageClient) ListDirectory(ctx context.Context, p string) ([]StorageObject, error) {
+	req, err := s.newRequest(ctx, "GET", p, true, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	objects := []StorageObject{}
+	if err := json.NewDecoder(resp.Body).Decode(&objects); err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+// Stat returns the details of a single file or directory, looked up in the
+// listing of its parent directory. The root directory can't be looked up.
+func (s *StorageClient) Stat(ctx context.Context, p string) (*StorageObject, error) {
+	clean, err := cleanStoragePath(p)
+	if err != nil {
+		return nil, err
+	}
+	if clean == "" {
+		return nil, errors.New("the root directory of the storage zone has no details")
+	}
+	objects, err := s.ListDirectory(ctx, path.Dir(clean))
+	if err != nil {
+		return nil, err
+	}
+	base := path.Base(clean)
+	for _, o := range objects {
+		if o.ObjectName == base {
+			return &o, nil
+		}
+	}
+	return nil, &StorageErrorResponse{http.StatusNotFound, "Object Not Found"}
+}
+
+// WalkDirectory calls fn for every file and directory below dir, recursing
+// into subdirectories. Directories are visited before their contents. If fn
+// returns filepath.SkipDir for a directory, its contents are skipped.
+func (s *StorageClient) WalkDirectory(ctx context.Context, dir string, fn func(StorageObject) error) error {
+	objects, err := s.ListDirectory(ctx, dir)
+	if err != nil {
+		return err
+	}
+	for _, o := range objects {
+		err := fn(o)
+		if err == filepath.SkipDir && o.IsDirectory {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if o.IsDirectory {
+			if err := s.WalkDirectory(ctx, o.RelativePath(), fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// SHA256Checksum computes the checksum of r in the format used by the Edge
+// Storage API, as upper case hex.
+func SHA256Checksum(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return strings.ToUpper(hex.EncodeToString(h.Sum(nil))), nil
+}
@@ -0,0 +1,438 @@
+// Copyright (c) 2021 Jan Koppe
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package bunny
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+type ArchiveFormat int32
+
+const (
+	AFTar     ArchiveFormat = 0
+	AFTarGzip ArchiveFormat = 1
+	AFZip     ArchiveFormat = 2
+)
+
+func (f ArchiveFormat) String() string {
+	switch f {
+	case AFTar:
+		return "tar"
+	case AFTarGzip:
+		return "tar.gz"
+	case AFZip:
+		return "zip"
+	}
+	return fmt.Sprintf("ArchiveFormat(%d)", int32(f))
+}
+
+// ArchiveFormatFromPath guesses the format of an archive from its file
+// extension.
+func ArchiveFormatFromPath(p string) (ArchiveFormat, error) {
+	lower := strings.ToLower(p)
+	switch {
+	case strings.HasSuffix(lower, ".tar"):
+		return AFTar, nil
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return AFTarGzip, nil
+	case strings.HasSuffix(lower, ".zip"):
+		return AFZip, nil
+	}
+	return 0, fmt.Errorf("unknown archive format of %v", p)
+}
+
+// BackupManifest is stored as manifest.json in every backup archive, after
+// the files. The files themselves are stored below files/, with paths
+// relative to Prefix.
+type BackupManifest struct {
+	StorageZone string
+	Prefix      string
+	Created     time.Time
+	Files       []BackupFile
+}
+
+// BackupFile describes a single file in a backup. Path is relative to the
+// prefix of the backup. Checksum is the upper case hex SHA256 of the
+// content.
+type BackupFile struct {
+	Path        string
+	Size        int64
+	Checksum    string
+	ContentType string
+	LastChanged time.Time
+}
+
+const (
+	backupManifestName = "manifest.json"
+	backupFilesDir     = "files/"
+)
+
+// BackupOptions configures Backup. The zero value is usable.
+type BackupOptions struct {
+	// called after each file was added to the archive
+	OnFile func(BackupFile)
+}
+
+// BackupToFile creates a backup archive at archivePath, with the format
+// derived from its extension. The archive is removed again if the backup
+// fails.
+func (s *StorageClient) BackupToFile(ctx context.Context, archivePath string, prefix string, opts *BackupOptions) (*BackupManifest, error) {
+	format, err := ArchiveFormatFromPath(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Create(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	m, err := s.Backup(ctx, f, format, prefix, opts)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(archivePath)
+		return nil, err
+	}
+	return m, nil
+}
+
+// Backup writes all files below prefix to w as an archive. Every file is
+// verified against the checksum reported by the Edge Storage API while it is
+// downloaded. For files without a reported checksum, it's computed while the
+// file is archived. Files are streamed one at a time, nothing is buffered.
+func (s *StorageClient) Backup(ctx context.Context, w io.Writer, format ArchiveFormat, prefix string, opts *BackupOptions) (*BackupManifest, error) {
+	o := BackupOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	prefix, err := cleanStoragePath(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &BackupManifest{StorageZone: s.ZoneName, Prefix: prefix, Created: time.Now().UTC(), Files: []BackupFile{}}
+	err = s.WalkDirectory(ctx, prefix, func(obj StorageObject) error {
+		if !obj.IsDirectory {
+			rel := strings.TrimPrefix(strings.TrimPrefix(obj.RelativePath(), prefix), "/")
+			m.Files = append(m.Files, BackupFile{rel, obj.Length, strings.ToUpper(obj.Checksum), obj.ContentType, obj.LastChanged.Time})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	aw, err := newArchiveWriter(w, format)
+	if err != nil {
+		return nil, err
+	}
+	for i := range m.Files {
+		bf := &m.Files[i]
+		sf, err := s.GetFile(ctx, path.Join(prefix, bf.Path))
+		if err != nil {
+			return nil, err
+		}
+		hr := &hashingReader{r: sf, h: sha256.New()}
+		err = aw.add(backupFilesDir+bf.Path, bf.Size, bf.LastChanged, hr)
+		sf.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", bf.Path, err)
+		}
+		if sum := hr.sum(); bf.Checksum == "" {
+			bf.Checksum = sum
+		} else if sum != bf.Checksum {
+			return nil, fmt.Errorf("%v: %w", bf.Path, errChecksumMismatch)
+		}
+		if o.OnFile != nil {
+			o.OnFile(*bf)
+		}
+	}
+
+	// the manifest goes last, as the missing checksums are only known now
+	manifest, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := aw.add(backupManifestName, int64(len(manifest)), m.Created, bytes.NewReader(manifest)); err != nil {
+		return nil, err
+	}
+	if err := aw.Close(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// RestoreOptions configures RestoreFromFile. The zero value restores into
+// the prefix the backup was made from.
+type RestoreOptions struct {
+	// restore into this directory instead of the original prefix
+	Prefix string
+	// called after each file was uploaded
+	OnFile func(BackupFile)
+}
+
+// RestoreFromFile uploads all files of a backup archive created with Backup,
+// with their original content types. The files are verified against the
+// manifest before uploading, and the upload is verified by the Edge Storage
+// API with the checksum. Existing files are replaced, other files in the
+// StorageZone are left alone. Archives with the manifest before the files
+// are restored as well.
+func (s *StorageClient) RestoreFromFile(ctx context.Context, archivePath string, opts *RestoreOptions) (*BackupManifest, error) {
+	format, err := ArchiveFormatFromPath(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	o := RestoreOptions{}
+	if opts != nil {
+		o = *opts
+	}
+
+	// the manifest is needed to verify the files, so it's read first,
+	// wherever it is stored
+	var m *BackupManifest
+	err = readArchive(archivePath, format, func(name string, r io.Reader) error {
+		if name != backupManifestName {
+			return nil
+		}
+		m = &BackupManifest{}
+		if err := json.NewDecoder(r).Decode(m); err != nil {
+			return fmt.Errorf("invalid backup manifest: %w", err)
+		}
+		return errManifestFound
+	})
+	if err != nil && err != errManifestFound {
+		return nil, err
+	}
+	if m == nil {
+		return nil, errors.New("archive doesn't contain a backup manifest")
+	}
+	files := map[string]BackupFile{}
+	for _, bf := range m.Files {
+		files[bf.Path] = bf
+	}
+	if o.Prefix == "" {
+		o.Prefix = m.Prefix
+	}
+
+	restored := map[string]bool{}
+	err = readArchive(archivePath, format, func(name string, r io.Reader) error {
+		if name == backupManifestName {
+			return nil
+		}
+		rel := strings.TrimPrefix(name, backupFilesDir)
+		bf, ok := files[rel]
+		if !ok || rel == name {
+			return fmt.Errorf("unexpected archive entry %v", name)
+		}
+		if err := s.restoreFile(ctx, path.Join(o.Prefix, rel), bf, r); err != nil {
+			return fmt.Errorf("%v: %w", rel, err)
+		}
+		restored[rel] = true
+		if o.OnFile != nil {
+			o.OnFile(bf)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, bf := range m.Files {
+		if !restored[bf.Path] {
+			return nil, fmt.Errorf("%v is missing from the archive", bf.Path)
+		}
+	}
+	return m, nil
+}
+
+// errManifestFound stops reading an archive once the manifest was found.
+var errManifestFound = errors.New("manifest found")
+
+// restoreFile buffers a file from the archive in a temporary file, so it can
+// be verified before it's uploaded.
+func (s *StorageClient) restoreFile(ctx context.Context, p string, bf BackupFile, r io.Reader) error {
+	tmp, err := ioutil.TempFile("", "bunny-restore")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hr := &hashingReader{r: r, h: sha256.New()}
+	n, err := io.Copy(tmp, hr)
+	if err != nil {
+		return err
+	}
+	if n != bf.Size || hr.sum() != strings.ToUpper(bf.Checksum) {
+		return errChecksumMismatch
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return s.PutFile(ctx, p, tmp, &PutFileOptions{Checksum: bf.Checksum, ContentLength: n, ContentType: bf.ContentType})
+}
+
+type hashingReader struct {
+	r io.Reader
+	h hash.Hash
+}
+
+func (hr *hashingReader) Read(p []byte) (int, error) {
+	n, err := hr.r.Read(p)
+	hr.h.Write(p[:n])
+	return n, err
+}
+
+func (hr *hashingReader) sum() string {
+	return strings.ToUpper(hex.EncodeToString(hr.h.Sum(nil)))
+}
+
+// archiveWriter hides the differences between tar and zip archives.
+type archiveWriter struct {
+	tw *tar.Writer
+	zw *zip.Writer
+	gz *gzip.Writer
+}
+
+func newArchiveWriter(w io.Writer, format ArchiveFormat) (*archiveWriter, error) {
+	switch format {
+	case AFTar:
+		return &archiveWriter{tw: tar.NewWriter(w)}, nil
+	case AFTarGzip:
+		gz := gzip.NewWriter(w)
+		return &archiveWriter{tw: tar.NewWriter(gz), gz: gz}, nil
+	case AFZip:
+		return &archiveWriter{zw: zip.NewWriter(w)}, nil
+	}
+	return nil, fmt.Errorf("unsupported archive format %v", format)
+}
+
+// add writes an entry of exactly size bytes from r.
+func (aw *archiveWriter) add(name string, size int64, modified time.Time, r io.Reader) error {
+	var w io.Writer
+	if aw.tw != nil {
+		hdr := &tar.Header{Name: name, Size: size, Mode: 0644, ModTime: modified, Typeflag: tar.TypeReg}
+		if err := aw.tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		w = aw.tw
+	} else {
+		hdr := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified}
+		zf, err := aw.zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		w = zf
+	}
+	n, err := io.Copy(w, io.LimitReader(r, size+1))
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return fmt.Errorf("expected %d bytes, got %d", size, n)
+	}
+	return nil
+}
+
+func (aw *archiveWriter) Close() error {
+	if aw.zw != nil {
+		return aw.zw.Close()
+	}
+	if err := aw.tw.Close(); err != nil {
+		return err
+	}
+	if aw.gz != nil {
+		return aw.gz.Close()
+	}
+	return nil
+}
+
+// readArchive calls fn for every regular file in an archive, in the order
+// they are stored.
+func readArchive(archivePath string, format ArchiveFormat, fn func(name string, r io.Reader) error) error {
+	if format == AFZip {
+		zr, err := zip.OpenReader(archivePath)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		for _, zf := range zr.File {
+			if zf.FileInfo().IsDir() {
+				continue
+			}
+			rc, err := zf.Open()
+			if err != nil {
+				return err
+			}
+			err = fn(zf.Name, rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if format == AFTarGzip {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := fn(hdr.Name, tr); err != nil {
+			return err
+		}
+	}
+}
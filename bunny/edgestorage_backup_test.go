@@ -0,0 +1,141 @@
+// Copyright (c) 2021 Jan Koppe
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package bunny
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStorageBackupRestore(t *testing.T) {
+	src, srcStorage := newFakeStorage(t)
+	ctx := context.Background()
+	srcStorage.put("uploads/a.jpg", "jpeg data")
+	srcStorage.put("uploads/2021/b.pdf", "pdf data")
+	srcStorage.put("uploads/empty", "")
+	srcStorage.put("other.txt", "not part of the backup")
+
+	dir, err := ioutil.TempDir("", "bunny-backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"backup.tar", "backup.tar.gz", "backup.zip"} {
+		archive := filepath.Join(dir, name)
+		m, err := src.BackupToFile(ctx, archive, "/uploads/", nil)
+		if err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		if len(m.Files) != 3 || m.Prefix != "uploads" || m.Files[0].Path != "2021/b.pdf" {
+			t.Fatalf("%v: unexpected manifest %+v", name, m)
+		}
+
+		dst, dstStorage := newFakeStorage(t)
+		restored := 0
+		_, err = dst.RestoreFromFile(ctx, archive, &RestoreOptions{Prefix: "restored", OnFile: func(BackupFile) { restored++ }})
+		if err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		if got, _ := dstStorage.get("restored/2021/b.pdf"); got != "pdf data" || restored != 3 {
+			t.Errorf("%v: unexpected restore, b.pdf = %q, %d files", name, got, restored)
+		}
+		if _, ok := dstStorage.get("restored/empty"); !ok {
+			t.Errorf("%v: empty file was not restored", name)
+		}
+		if _, ok := dstStorage.get("other.txt"); ok {
+			t.Errorf("%v: file outside of the prefix was restored", name)
+		}
+	}
+}
+
+func TestStorageRestoreCorrupted(t *testing.T) {
+	s, storage := newFakeStorage(t)
+
+	dir, err := ioutil.TempDir("", "bunny-backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	archive := filepath.Join(dir, "corrupt.tar")
+	f, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aw, _ := newArchiveWriter(f, AFTar)
+	sum, _ := SHA256Checksum(strings.NewReader("original"))
+	m, _ := json.Marshal(&BackupManifest{Files: []BackupFile{{Path: "a.txt", Size: 8, Checksum: sum}}})
+	_ = aw.add(backupManifestName, int64(len(m)), time.Now(), bytes.NewReader(m))
+	_ = aw.add(backupFilesDir+"a.txt", 8, time.Now(), strings.NewReader("modified"))
+	aw.Close()
+	f.Close()
+
+	_, err = s.RestoreFromFile(context.Background(), archive, nil)
+	if !errors.Is(err, errChecksumMismatch) {
+		t.Errorf("expected checksum mismatch, got %v", err)
+	}
+	if _, ok := storage.get("a.txt"); ok {
+		t.Error("corrupted file was uploaded")
+	}
+}
+
+func TestStorageBackupWithoutChecksums(t *testing.T) {
+	s, storage := newFakeStorage(t)
+	storage.noChecksums = true
+	storage.put("a.txt", "first")
+	storage.put("b.txt", "second")
+
+	dir, err := ioutil.TempDir("", "bunny-backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	archive := filepath.Join(dir, "backup.tar")
+
+	m, err := s.BackupToFile(context.Background(), archive, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum, _ := SHA256Checksum(strings.NewReader("first"))
+	if m.Files[0].Checksum != sum {
+		t.Errorf("unexpected checksum %v in manifest", m.Files[0].Checksum)
+	}
+	if storage.downloads != 2 {
+		t.Errorf("expected every file to be downloaded once, got %d downloads", storage.downloads)
+	}
+
+	// the manifest with the computed checksums is stored after the files
+	dst, dstStorage := newFakeStorage(t)
+	if _, err := dst.RestoreFromFile(context.Background(), archive, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := dstStorage.get("b.txt"); got != "second" {
+		t.Errorf("unexpected restored content %q", got)
+	}
+}
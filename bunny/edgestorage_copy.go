@@ -0,0 +1,312 @@
+// Copyright (c) 2021 Jan Koppe
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package bunny
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// CopyStorageOptions configures CopyStorage. The zero value is usable.
+type CopyStorageOptions struct {
+	// number of files copied at the same time, defaults to 4
+	Concurrency int
+	// path of a local checkpoint file. Every copied file is recorded in it,
+	// and files recorded with an unchanged checksum are skipped, so an
+	// interrupted copy can be resumed by running it again.
+	CheckpointFile string
+	// called after each file, from the goroutine copying it
+	OnFile func(CopyStorageResult)
+}
+
+// CopyStorageResult is the outcome of copying a single file. Path is
+// relative to the copied prefix.
+type CopyStorageResult struct {
+	Path    string
+	Size    int64
+	Skipped bool
+	Err     error
+}
+
+// CopyStorageReport summarizes a CopyStorage run.
+type CopyStorageReport struct {
+	Results []CopyStorageResult
+	Copied  int
+	Skipped int
+	Failed  int
+	Bytes   int64
+}
+
+// Failures returns the results of all files that could not be copied.
+func (r *CopyStorageReport) Failures() []CopyStorageResult {
+	failed := []CopyStorageResult{}
+	for _, res := range r.Results {
+		if res.Err != nil {
+			failed = append(failed, res)
+		}
+	}
+	return failed
+}
+
+func (r *CopyStorageReport) String() string {
+	return fmt.Sprintf("%d files, %d copied, %d skipped, %d failed, %v", len(r.Results), r.Copied, r.Skipped, r.Failed, formatBytes(r.Bytes))
+}
+
+// CopyStorage copies all files below prefix from one StorageZone to the same
+// paths in another, keeping their content types. Files are streamed from src
+// to dst without touching the local disk, and verified with their SHA256
+// checksums on both sides. Files that already exist in dst are replaced.
+//
+// An error is returned if the context was cancelled or any file failed, the
+// report has the details.
+func CopyStorage(ctx context.Context, src *StorageClient, dst *StorageClient, prefix string, opts *CopyStorageOptions) (*CopyStorageReport, error) {
+	o := CopyStorageOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.Concurrency <= 0 {
+		o.Concurrency = 4
+	}
+	prefix, err := cleanStoragePath(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var cp *copyCheckpoint
+	if o.CheckpointFile != "" {
+		cp, err = openCopyCheckpoint(o.CheckpointFile)
+		if err != nil {
+			return nil, err
+		}
+		defer cp.Close()
+	}
+
+	objects := []StorageObject{}
+	err = src.WalkDirectory(ctx, prefix, func(obj StorageObject) error {
+		if !obj.IsDirectory {
+			objects = append(objects, obj)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	report := &CopyStorageReport{Results: make([]CopyStorageResult, len(objects))}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < o.Concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				obj := objects[i]
+				res := &report.Results[i]
+				res.Path = strings.TrimPrefix(strings.TrimPrefix(obj.RelativePath(), prefix), "/")
+				res.Size = obj.Length
+				if cp != nil && cp.done(obj) {
+					res.Skipped = true
+				} else {
+					res.Err = copyStorageObject(ctx, src, dst, obj)
+					if res.Err == nil && cp != nil {
+						res.Err = cp.record(obj)
+					}
+				}
+				if o.OnFile != nil {
+					o.OnFile(*res)
+				}
+			}
+		}()
+	}
+
+	var ctxErr error
+	for i := range objects {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			ctxErr = ctx.Err()
+		}
+		if ctxErr != nil {
+			for j := i; j < len(objects); j++ {
+				report.Results[j].Path = strings.TrimPrefix(strings.TrimPrefix(objects[j].RelativePath(), prefix), "/")
+				report.Results[j].Err = ctxErr
+			}
+			break
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, res := range report.Results {
+		switch {
+		case res.Err != nil:
+			report.Failed++
+		case res.Skipped:
+			report.Skipped++
+		default:
+			report.Copied++
+			report.Bytes += res.Size
+		}
+	}
+
+	if ctxErr != nil {
+		return report, ctxErr
+	}
+	if report.Failed > 0 {
+		return report, fmt.Errorf("%d of %d files failed to copy", report.Failed, len(report.Results))
+	}
+	return report, nil
+}
+
+func copyStorageObject(ctx context.Context, src *StorageClient, dst *StorageClient, obj StorageObject) error {
+	p := obj.RelativePath()
+	sf, err := src.GetFile(ctx, p)
+	if err != nil {
+		return err
+	}
+	defer sf.Close()
+
+	hr := &hashingReader{r: sf, h: sha256.New()}
+	err = dst.PutFile(ctx, p, hr, &PutFileOptions{Checksum: obj.Checksum, ContentLength: obj.Length, ContentType: obj.ContentType})
+	if err != nil {
+		return err
+	}
+	sum := hr.sum()
+	if obj.Checksum != "" && !strings.EqualFold(sum, obj.Checksum) {
+		return errChecksumMismatch
+	}
+
+	copied, err := dst.Stat(ctx, p)
+	if err != nil {
+		return err
+	}
+	if copied.Length != obj.Length || (copied.Checksum != "" && !strings.EqualFold(copied.Checksum, sum)) {
+		return errChecksumMismatch
+	}
+	return nil
+}
+
+// copyCheckpoint is an append-only file of copied paths and their checksums,
+// one tab separated pair per line.
+type copyCheckpoint struct {
+	mu     sync.Mutex
+	f      *os.File
+	copied map[string]string
+}
+
+func openCopyCheckpoint(p string) (*copyCheckpoint, error) {
+	f, err := os.OpenFile(p, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	cp := &copyCheckpoint{f: f, copied: map[string]string{}}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), "\t", 2)
+		if len(parts) == 2 {
+			cp.copied[parts[0]] = parts[1]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return cp, nil
+}
+
+// done reports whether obj was copied before and hasn't changed since. Files
+// without a checksum are always copied again.
+func (cp *copyCheckpoint) done(obj StorageObject) bool {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	sum, ok := cp.copied[obj.RelativePath()]
+	return ok && obj.Checksum != "" && strings.EqualFold(sum, obj.Checksum)
+}
+
+func (cp *copyCheckpoint) record(obj StorageObject) error {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.copied[obj.RelativePath()] = obj.Checksum
+	_, err := fmt.Fprintf(cp.f, "%v\t%v\n", obj.RelativePath(), obj.Checksum)
+	return err
+}
+
+func (cp *copyCheckpoint) Close() error {
+	return cp.f.Close()
+}
+
+// MigrateStorageZoneOptions configures MigrateStorageZone. The zero value
+// only copies the files.
+type MigrateStorageZoneOptions struct {
+	CopyStorageOptions
+	// point the PullZones linked to the old StorageZone to the new one,
+	// after all files were copied successfully
+	RepointPullZones bool
+}
+
+// MigrateStorageZone copies all files of a StorageZone into another one, e.g.
+// to move to a different primary region, which can't be changed after a zone
+// was created. The old zone is left untouched, delete it once the migration
+// was verified.
+func (c *Client) MigrateStorageZone(ctx context.Context, fromID int64, toID int64, opts *MigrateStorageZoneOptions) (*CopyStorageReport, error) {
+	o := MigrateStorageZoneOptions{}
+	if opts != nil {
+		o = *opts
+	}
+
+	from, err := c.GetStorageZone(fromID)
+	if err != nil {
+		return nil, err
+	}
+	to, err := c.GetStorageZone(toID)
+	if err != nil {
+		return nil, err
+	}
+	src, err := NewStorageClientForZone(from)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := NewStorageClientForZone(to)
+	if err != nil {
+		return nil, err
+	}
+
+	report, err := CopyStorage(ctx, src, dst, "", &o.CopyStorageOptions)
+	if err != nil || !o.RepointPullZones {
+		return report, err
+	}
+
+	for _, linked := range from.PullZones {
+		pz, err := c.GetPullZone(linked.ID)
+		if err != nil {
+			return report, err
+		}
+		pz.StorageZoneID = to.ID
+		if err := c.UpdatePullZone(*pz); err != nil {
+			return report, fmt.Errorf("repointing pull zone %v: %w", pz.Name, err)
+		}
+	}
+	return report, nil
+}
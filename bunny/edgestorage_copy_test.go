@@ -0,0 +1,133 @@
+// Copyright (c) 2021 Jan Koppe
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package bunny
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCopyStorage(t *testing.T) {
+	src, srcStorage := newFakeStorage(t)
+	dst, dstStorage := newFakeStorage(t)
+	ctx := context.Background()
+	srcStorage.put("site/index.html", "<h1>home</h1>")
+	srcStorage.put("site/img/logo.svg", "<svg/>")
+	srcStorage.put("site/empty", "")
+	srcStorage.put("private/key", "not copied")
+
+	dir, err := ioutil.TempDir("", "bunny-copy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	checkpoint := filepath.Join(dir, "checkpoint")
+
+	opts := &CopyStorageOptions{CheckpointFile: checkpoint}
+	report, err := CopyStorage(ctx, src, dst, "site", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Copied != 3 || report.Bytes != int64(len("<h1>home</h1><svg/>")) {
+		t.Errorf("unexpected report %v", report)
+	}
+	if got, _ := dstStorage.get("site/img/logo.svg"); got != "<svg/>" {
+		t.Errorf("unexpected copied content %q", got)
+	}
+	if _, ok := dstStorage.get("private/key"); ok {
+		t.Error("file outside of the prefix was copied")
+	}
+
+	// resuming skips everything that is unchanged
+	srcStorage.put("site/index.html", "<h1>new home</h1>")
+	report, err = CopyStorage(ctx, src, dst, "site", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Copied != 1 || report.Skipped != 2 {
+		t.Errorf("unexpected resumed report %v", report)
+	}
+	if got, _ := dstStorage.get("site/index.html"); got != "<h1>new home</h1>" {
+		t.Errorf("changed file was not copied again, got %q", got)
+	}
+}
+
+func TestMigrateStorageZone(t *testing.T) {
+	from := &fakeStorage{zone: "old", files: map[string]fakeStorageFile{}}
+	to := &fakeStorage{zone: "new", files: map[string]fakeStorageFile{}}
+	from.put("a.txt", "a")
+	from.put("b/c.txt", "c")
+
+	storageSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/old/") {
+			from.ServeHTTP(w, r)
+		} else {
+			to.ServeHTTP(w, r)
+		}
+	}))
+	defer storageSrv.Close()
+	os.Setenv("BUNNYCDN_STORAGE_URL", storageSrv.URL)
+	defer os.Unsetenv("BUNNYCDN_STORAGE_URL")
+
+	updated := PullZone{}
+	apiSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method + " " + r.URL.Path {
+		case "GET /storagezone/1":
+			_ = json.NewEncoder(w).Encode(StorageZone{ID: 1, Name: "old", Password: "password", PullZones: []PullZone{{ID: 7}}})
+		case "GET /storagezone/2":
+			_ = json.NewEncoder(w).Encode(StorageZone{ID: 2, Name: "new", Password: "password", Region: "NY"})
+		case "GET /pullzone/7":
+			_ = json.NewEncoder(w).Encode(PullZone{ID: 7, Name: "site", StorageZoneID: 1})
+		case "POST /pullzone/7":
+			_ = json.NewDecoder(r.Body).Decode(&updated)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer apiSrv.Close()
+
+	c, err := NewClient("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.BaseURL, _ = url.Parse(apiSrv.URL)
+
+	report, err := c.MigrateStorageZone(context.Background(), 1, 2, &MigrateStorageZoneOptions{RepointPullZones: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Copied != 2 {
+		t.Errorf("unexpected report %v", report)
+	}
+	if got, _ := to.get("b/c.txt"); got != "c" {
+		t.Errorf("unexpected migrated content %q", got)
+	}
+	if updated.StorageZoneID != 2 || updated.Name != "site" {
+		t.Errorf("pull zone was not repointed: %+v", updated)
+	}
+}
@@ -0,0 +1,230 @@
+// Copyright (c) 2021 Jan Koppe
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package bunny
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"time"
+)
+
+// StorageFS gives read-only filesystem access to a StorageZone. On Go 1.16
+// and later it implements fs.FS, fs.ReadDirFS and fs.StatFS, so it can be
+// used with fs.WalkDir, template.ParseFS or testing/fstest.
+//
+// Every Open and Stat lists the parent directory, and file contents are
+// downloaded with ranged requests as they are read, so nothing is cached.
+type StorageFS struct {
+	client *StorageClient
+	ctx    context.Context
+}
+
+// FS returns a filesystem view of the StorageZone. ctx is used for all
+// requests made through it.
+func (s *StorageClient) FS(ctx context.Context) *StorageFS {
+	return &StorageFS{client: s, ctx: ctx}
+}
+
+// HTTPFileSystem returns the StorageZone as http.FileSystem, for use with
+// http.FileServer.
+func (fsys *StorageFS) HTTPFileSystem() http.FileSystem {
+	return storageHTTPFS{fsys}
+}
+
+// Handler serves the files of the StorageZone like http.FileServer, e.g. to
+// preview a site locally before pointing a PullZone at it. Requests to the
+// Edge Storage API use the context of the incoming request.
+func (s *StorageClient) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.FileServer(s.FS(r.Context()).HTTPFileSystem()).ServeHTTP(w, r)
+	})
+}
+
+type storageHTTPFS struct {
+	fsys *StorageFS
+}
+
+func (h storageHTTPFS) Open(name string) (http.File, error) {
+	return h.fsys.open("open", name)
+}
+
+// storageFileInfo implements os.FileInfo for a StorageObject.
+type storageFileInfo struct {
+	name string
+	obj  StorageObject
+}
+
+func (fi *storageFileInfo) Name() string { return fi.name }
+func (fi *storageFileInfo) Size() int64  { return fi.obj.Length }
+func (fi *storageFileInfo) Mode() os.FileMode {
+	if fi.obj.IsDirectory {
+		return os.ModeDir | 0555
+	}
+	return 0444
+}
+func (fi *storageFileInfo) ModTime() time.Time { return fi.obj.LastChanged.Time }
+func (fi *storageFileInfo) IsDir() bool        { return fi.obj.IsDirectory }
+
+// Sys returns the underlying StorageObject.
+func (fi *storageFileInfo) Sys() interface{} { return fi.obj }
+
+// stat looks up a file or directory in the listing of its parent. name has
+// to be cleaned already, the root directory is "".
+func (fsys *StorageFS) stat(name string) (*storageFileInfo, error) {
+	if name == "" {
+		return &storageFileInfo{".", StorageObject{StorageZoneName: fsys.client.ZoneName, Path: "/" + fsys.client.ZoneName + "/", IsDirectory: true}}, nil
+	}
+	o, err := fsys.client.Stat(fsys.ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return &storageFileInfo{path.Base(name), *o}, nil
+}
+
+func (fsys *StorageFS) open(op string, name string) (*storageFile, error) {
+	clean, err := cleanStoragePath(name)
+	if err != nil {
+		return nil, &os.PathError{Op: op, Path: name, Err: os.ErrInvalid}
+	}
+	fi, err := fsys.stat(clean)
+	if err != nil {
+		return nil, &os.PathError{Op: op, Path: name, Err: err}
+	}
+	return &storageFile{fsys: fsys, path: clean, info: fi}, nil
+}
+
+// storageFile is an open file or directory. File contents are fetched
+// lazily from the current offset, so seeking is cheap until the next Read.
+type storageFile struct {
+	fsys   *StorageFS
+	path   string
+	info   *storageFileInfo
+	offset int64
+	body   io.ReadCloser
+	// remaining directory entries, nil until the first Readdir
+	entries []os.FileInfo
+	closed  bool
+}
+
+func (f *storageFile) Stat() (os.FileInfo, error) {
+	if f.closed {
+		return nil, os.ErrClosed
+	}
+	return f.info, nil
+}
+
+func (f *storageFile) Read(p []byte) (int, error) {
+	if f.closed {
+		return 0, &os.PathError{Op: "read", Path: f.info.name, Err: os.ErrClosed}
+	}
+	if f.info.IsDir() {
+		return 0, &os.PathError{Op: "read", Path: f.info.name, Err: errors.New("is a directory")}
+	}
+	if f.offset >= f.info.Size() {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if f.body == nil {
+		sf, err := f.fsys.client.GetFileRange(f.fsys.ctx, f.path, f.offset, -1)
+		if err != nil {
+			return 0, &os.PathError{Op: "read", Path: f.info.name, Err: err}
+		}
+		f.body = sf
+	}
+	n, err := f.body.Read(p)
+	f.offset += int64(n)
+	if err == io.EOF && f.offset < f.info.Size() {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (f *storageFile) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, &os.PathError{Op: "seek", Path: f.info.name, Err: os.ErrClosed}
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.Size()
+	default:
+		return 0, &os.PathError{Op: "seek", Path: f.info.name, Err: os.ErrInvalid}
+	}
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.info.name, Err: os.ErrInvalid}
+	}
+	if offset != f.offset && f.body != nil {
+		f.body.Close()
+		f.body = nil
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+// Readdir behaves like os.File.Readdir, returning the entries sorted by name.
+func (f *storageFile) Readdir(count int) ([]os.FileInfo, error) {
+	if f.closed {
+		return nil, &os.PathError{Op: "readdir", Path: f.info.name, Err: os.ErrClosed}
+	}
+	if !f.info.IsDir() {
+		return nil, &os.PathError{Op: "readdir", Path: f.info.name, Err: errors.New("not a directory")}
+	}
+	if f.entries == nil {
+		objects, err := f.fsys.client.ListDirectory(f.fsys.ctx, f.path)
+		if err != nil {
+			return nil, &os.PathError{Op: "readdir", Path: f.info.name, Err: err}
+		}
+		f.entries = make([]os.FileInfo, 0, len(objects))
+		for _, o := range objects {
+			f.entries = append(f.entries, &storageFileInfo{o.ObjectName, o})
+		}
+		sort.Slice(f.entries, func(i, j int) bool { return f.entries[i].Name() < f.entries[j].Name() })
+	}
+
+	if count > 0 && len(f.entries) == 0 {
+		return nil, io.EOF
+	}
+	if count <= 0 || count > len(f.entries) {
+		count = len(f.entries)
+	}
+	entries := f.entries[:count]
+	f.entries = f.entries[count:]
+	return entries, nil
+}
+
+func (f *storageFile) Close() error {
+	if f.closed {
+		return os.ErrClosed
+	}
+	f.closed = true
+	if f.body != nil {
+		return f.body.Close()
+	}
+	return nil
+}
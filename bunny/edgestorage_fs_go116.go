@@ -0,0 +1,83 @@
+// Copyright (c) 2021 Jan Koppe
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+//go:build go1.16
+// +build go1.16
+
+package bunny
+
+import "io/fs"
+
+var (
+	_ fs.ReadDirFS = (*StorageFS)(nil)
+	_ fs.StatFS    = (*StorageFS)(nil)
+)
+
+// Open opens a file or directory for reading. Directories implement
+// fs.ReadDirFile, files io.Seeker.
+func (fsys *StorageFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	f, err := fsys.open("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return storageFSFile{f}, nil
+}
+
+func (fsys *StorageFS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	f, err := fsys.open("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return f.info, nil
+}
+
+func (fsys *StorageFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	f, err := fsys.open("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return storageFSFile{f}.ReadDir(-1)
+}
+
+// storageFSFile adds the fs.ReadDirFile method to storageFile.
+type storageFSFile struct {
+	*storageFile
+}
+
+func (f storageFSFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	infos, err := f.Readdir(n)
+	entries := make([]fs.DirEntry, len(infos))
+	for i, fi := range infos {
+		entries[i] = fi.(*storageFileInfo)
+	}
+	return entries, err
+}
+
+func (fi *storageFileInfo) Type() fs.FileMode          { return fi.Mode().Type() }
+func (fi *storageFileInfo) Info() (fs.FileInfo, error) { return fi, nil }
@@ -0,0 +1,131 @@
+// Copyright (c) 2021 Jan Koppe
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+//go:build go1.16
+// +build go1.16
+
+package bunny
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestStorageFS(t *testing.T) {
+	s, storage := newFakeStorage(t)
+	storage.put("index.html", "<h1>home</h1>")
+	storage.put("css/site.css", "body {}")
+	storage.put("blog/2021/first.html", "first post")
+	storage.put("empty.txt", "")
+
+	fsys := s.FS(context.Background())
+	if err := fstest.TestFS(fsys, "index.html", "css/site.css", "blog/2021/first.html", "empty.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{}
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		names = append(names, p)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(names, " "); got != ". blog blog/2021 blog/2021/first.html css css/site.css empty.txt index.html" {
+		t.Errorf("unexpected walk %v", got)
+	}
+
+	f, err := fsys.Open("blog/2021/first.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.(io.Seeker).Seek(6, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(f)
+	if string(data) != "post" {
+		t.Errorf("unexpected content after seek %q", data)
+	}
+
+	if _, err := fsys.Stat("missing.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected not exist error, got %v", err)
+	}
+	if _, err := fsys.Open("../escape"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("expected invalid path error, got %v", err)
+	}
+}
+
+func TestStorageHandler(t *testing.T) {
+	s, storage := newFakeStorage(t)
+	storage.put("index.html", "<h1>home</h1>")
+	storage.put("docs/guide.txt", "read the manual")
+
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(data) != "<h1>home</h1>" {
+		t.Errorf("index.html was not served, got %q", data)
+	}
+
+	req, _ := http.NewRequest("GET", srv.URL+"/docs/guide.txt", nil)
+	req.Header.Set("Range", "bytes=9-14")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent || string(data) != "manual" {
+		t.Errorf("unexpected ranged response %v %q", resp.Status, data)
+	}
+
+	resp, err = http.Get(srv.URL + "/docs/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(data), `<a href="guide.txt">guide.txt</a>`) {
+		t.Errorf("unexpected directory listing %q", data)
+	}
+
+	resp, err = http.Get(srv.URL + "/missing.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 for missing files, got %v", resp.Status)
+	}
+}
@@ -0,0 +1,329 @@
+// Copyright (c) 2021 Jan Koppe
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package bunny
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LifecycleRule selects files to delete from a StorageZone. A rule applies to
+// all files below Prefix that match Match, if set, and deletes those that are
+// too old or exceed the maximum count.
+type LifecycleRule struct {
+	Name string
+	// directory the rule applies to, "" for the whole StorageZone
+	Prefix string
+	// pattern matched against the path relative to Prefix, with the same
+	// syntax as SyncOptions.Include
+	Match string
+	// delete files last changed longer ago than this, 0 disables
+	MaxAge time.Duration
+	// keep only the newest MaxCount files, 0 disables
+	MaxCount int
+	// never delete the newest KeepLatest files, even if they are older than
+	// MaxAge
+	KeepLatest int
+}
+
+func (r LifecycleRule) validate() error {
+	if r.MaxAge <= 0 && r.MaxCount <= 0 {
+		return fmt.Errorf("lifecycle rule %q has neither MaxAge nor MaxCount", r.Name)
+	}
+	if r.MaxAge < 0 || r.MaxCount < 0 || r.KeepLatest < 0 {
+		return fmt.Errorf("lifecycle rule %q has negative limits", r.Name)
+	}
+	if _, err := cleanStoragePath(r.Prefix); err != nil {
+		return err
+	}
+	return nil
+}
+
+// LifecycleDeletion is a file selected for deletion by a LifecycleRule.
+type LifecycleDeletion struct {
+	Path        string
+	Size        int64
+	LastChanged time.Time
+	Rule        string
+	Reason      string
+}
+
+// LifecyclePlan lists the files to delete, sorted by path. It can be
+// reviewed, e.g. as JSON, before passing it to ApplyLifecycle.
+type LifecyclePlan struct {
+	Created   time.Time
+	Evaluated int
+	Deletions []LifecycleDeletion
+}
+
+// Bytes returns the storage freed by the plan.
+func (p *LifecyclePlan) Bytes() int64 {
+	var n int64
+	for _, d := range p.Deletions {
+		n += d.Size
+	}
+	return n
+}
+
+func (p *LifecyclePlan) String() string {
+	return fmt.Sprintf("%d files evaluated, %d to delete, %v", p.Evaluated, len(p.Deletions), formatBytes(p.Bytes()))
+}
+
+// PlanLifecycle evaluates rules against the current listing of the
+// StorageZone. Nothing is deleted. If several rules select the same file,
+// the first one is recorded.
+func (s *StorageClient) PlanLifecycle(ctx context.Context, rules []LifecycleRule) (*LifecyclePlan, error) {
+	if len(rules) == 0 {
+		return nil, errors.New("no lifecycle rules given")
+	}
+	for _, r := range rules {
+		if err := r.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	plan := &LifecyclePlan{Created: time.Now().UTC(), Deletions: []LifecycleDeletion{}}
+	evaluated := map[string]bool{}
+	selected := map[string]bool{}
+	listings := map[string][]StorageObject{}
+
+	for _, r := range rules {
+		prefix, _ := cleanStoragePath(r.Prefix)
+		objects, ok := listings[prefix]
+		if !ok {
+			objects = []StorageObject{}
+			err := s.WalkDirectory(ctx, prefix, func(obj StorageObject) error {
+				if !obj.IsDirectory {
+					objects = append(objects, obj)
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+			listings[prefix] = objects
+		}
+
+		matched := []StorageObject{}
+		for _, obj := range objects {
+			rel := strings.TrimPrefix(strings.TrimPrefix(obj.RelativePath(), prefix), "/")
+			if r.Match == "" || matchSyncPatterns([]string{r.Match}, rel) {
+				matched = append(matched, obj)
+				evaluated[obj.RelativePath()] = true
+			}
+		}
+		// newest first
+		sort.SliceStable(matched, func(i, j int) bool {
+			return matched[i].LastChanged.After(matched[j].LastChanged.Time)
+		})
+
+		for i, obj := range matched {
+			if i < r.KeepLatest {
+				continue
+			}
+			reason := ""
+			if r.MaxCount > 0 && i >= r.MaxCount {
+				reason = fmt.Sprintf("more than %d files", r.MaxCount)
+			} else if age := plan.Created.Sub(obj.LastChanged.Time); r.MaxAge > 0 && age > r.MaxAge {
+				reason = "older than " + formatAge(r.MaxAge)
+			}
+			if reason == "" || selected[obj.RelativePath()] {
+				continue
+			}
+			selected[obj.RelativePath()] = true
+			plan.Deletions = append(plan.Deletions, LifecycleDeletion{obj.RelativePath(), obj.Length, obj.LastChanged.Time, r.Name, reason})
+		}
+	}
+
+	plan.Evaluated = len(evaluated)
+	sort.Slice(plan.Deletions, func(i, j int) bool { return plan.Deletions[i].Path < plan.Deletions[j].Path })
+	return plan, nil
+}
+
+// ApplyLifecycleOptions configures ApplyLifecycle. The zero value is usable.
+type ApplyLifecycleOptions struct {
+	// number of files deleted per batch, defaults to 100
+	BatchSize int
+	// pause between batches
+	BatchDelay time.Duration
+	// number of deletes running at the same time within a batch, defaults
+	// to 4
+	Concurrency int
+	// only report what would be deleted
+	DryRun bool
+	// called after each file, from the goroutine deleting it
+	OnDelete func(LifecycleResult)
+}
+
+// LifecycleResult is the outcome of a single deletion.
+type LifecycleResult struct {
+	LifecycleDeletion
+	Deleted time.Time
+	Err     error
+}
+
+// LifecycleReport is the audit trail of an ApplyLifecycle run.
+type LifecycleReport struct {
+	Started  time.Time
+	Finished time.Time
+	DryRun   bool
+	Results  []LifecycleResult
+	Deleted  int
+	Failed   int
+	Bytes    int64
+}
+
+func (r *LifecycleReport) String() string {
+	verb := "deleted"
+	if r.DryRun {
+		verb = "would be deleted"
+	}
+	return fmt.Sprintf("%d files %v, %d failed, %v freed", r.Deleted, verb, r.Failed, formatBytes(r.Bytes))
+}
+
+// WriteCSV writes one line per file with the columns path, size,
+// last_changed, rule, reason, deleted and error.
+func (r *LifecycleReport) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"path", "size", "last_changed", "rule", "reason", "deleted", "error"})
+	for _, res := range r.Results {
+		deleted, errMsg := "", ""
+		if !res.Deleted.IsZero() {
+			deleted = res.Deleted.Format(time.RFC3339)
+		}
+		if res.Err != nil {
+			errMsg = res.Err.Error()
+		}
+		_ = cw.Write([]string{res.Path, strconv.FormatInt(res.Size, 10), res.LastChanged.Format(time.RFC3339), res.Rule, res.Reason, deleted, errMsg})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// ErrLifecycleFileChanged is recorded for files of a plan that were changed
+// after the plan was made. They are not deleted.
+var ErrLifecycleFileChanged = errors.New("file changed since the lifecycle plan was made")
+
+// ApplyLifecycle deletes the files of a plan in batches. Every file is
+// checked again before it's deleted, files that are gone or were changed
+// since the plan was made are skipped. Failed deletes and skipped files are
+// recorded in the report and don't stop the run. An error is returned if the
+// context was cancelled, in which case the remaining files are recorded as
+// failed.
+func (s *StorageClient) ApplyLifecycle(ctx context.Context, plan *LifecyclePlan, opts *ApplyLifecycleOptions) (*LifecycleReport, error) {
+	o := ApplyLifecycleOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = 100
+	}
+	if o.Concurrency <= 0 {
+		o.Concurrency = 4
+	}
+
+	report := &LifecycleReport{Started: time.Now().UTC(), DryRun: o.DryRun, Results: make([]LifecycleResult, len(plan.Deletions))}
+	for i, d := range plan.Deletions {
+		report.Results[i].LifecycleDeletion = d
+	}
+
+	var ctxErr error
+	for start := 0; start < len(report.Results) && ctxErr == nil; start += o.BatchSize {
+		if start > 0 && o.BatchDelay > 0 {
+			t := time.NewTimer(o.BatchDelay)
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+			}
+		}
+		if ctxErr = ctx.Err(); ctxErr != nil {
+			for i := start; i < len(report.Results); i++ {
+				report.Results[i].Err = ctxErr
+			}
+			break
+		}
+
+		end := start + o.BatchSize
+		if end > len(report.Results) {
+			end = len(report.Results)
+		}
+		s.applyLifecycleBatch(ctx, report.Results[start:end], o)
+	}
+
+	for _, res := range report.Results {
+		if res.Err != nil {
+			report.Failed++
+		} else {
+			report.Deleted++
+			report.Bytes += res.Size
+		}
+	}
+	report.Finished = time.Now().UTC()
+	return report, ctxErr
+}
+
+func (s *StorageClient) applyLifecycleBatch(ctx context.Context, batch []LifecycleResult, o ApplyLifecycleOptions) {
+	jobs := make(chan *LifecycleResult)
+	var wg sync.WaitGroup
+	for w := 0; w < o.Concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for res := range jobs {
+				res.Err = s.checkLifecycleFile(ctx, res.LifecycleDeletion)
+				if res.Err == nil && !o.DryRun {
+					res.Err = s.DeleteFile(ctx, res.Path)
+					if res.Err == nil {
+						res.Deleted = time.Now().UTC()
+					}
+				}
+				if o.OnDelete != nil {
+					o.OnDelete(*res)
+				}
+			}
+		}()
+	}
+	for i := range batch {
+		jobs <- &batch[i]
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// checkLifecycleFile makes sure a file is still the one selected by the plan.
+func (s *StorageClient) checkLifecycleFile(ctx context.Context, d LifecycleDeletion) error {
+	obj, err := s.Stat(ctx, d.Path)
+	if err != nil {
+		return err
+	}
+	if obj.Length != d.Size || !obj.LastChanged.Equal(d.LastChanged) {
+		return ErrLifecycleFileChanged
+	}
+	return nil
+}
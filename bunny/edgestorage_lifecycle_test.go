@@ -0,0 +1,113 @@
+// Copyright (c) 2021 Jan Koppe
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package bunny
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStorageLifecycle(t *testing.T) {
+	s, storage := newFakeStorage(t)
+	ctx := context.Background()
+
+	age := func(p string, d time.Duration) {
+		storage.put(p, "data")
+		storage.mu.Lock()
+		f := storage.files[p]
+		f.modified = time.Now().Add(-d)
+		storage.files[p] = f
+		storage.mu.Unlock()
+	}
+	day := 24 * time.Hour
+	age("tmp/fresh.upload", time.Hour)
+	age("tmp/stale.upload", 3*day)
+	age("tmp/stale.keep", 3*day)
+	age("logs/1.log", 1*day)
+	age("logs/2.log", 2*day)
+	age("logs/3.log", 3*day)
+	age("logs/4.log", 40*day)
+	age("site/index.html", 100*day)
+
+	rules := []LifecycleRule{
+		{Name: "tmp", Prefix: "tmp", Match: "*.upload", MaxAge: day},
+		{Name: "logs", Prefix: "/logs/", MaxCount: 2},
+		{Name: "old logs", Prefix: "logs", MaxAge: 30 * day, KeepLatest: 1},
+	}
+	plan, err := s.PlanLifecycle(ctx, rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, d := range plan.Deletions {
+		got = append(got, d.Path+" "+d.Rule+" "+d.Reason)
+	}
+	want := "logs/3.log logs more than 2 files, logs/4.log logs more than 2 files, tmp/stale.upload tmp older than 1d"
+	if strings.Join(got, ", ") != want {
+		t.Errorf("got plan %v, want %v", strings.Join(got, ", "), want)
+	}
+	if plan.Evaluated != 6 || plan.Bytes() != 12 {
+		t.Errorf("unexpected plan %v", plan)
+	}
+
+	report, err := s.ApplyLifecycle(ctx, plan, &ApplyLifecycleOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := storage.get("logs/4.log"); !ok || report.Deleted != 3 || !report.Results[0].Deleted.IsZero() {
+		t.Errorf("dry run deleted files: %v", report)
+	}
+
+	// a file uploaded again after the plan was reviewed is kept
+	storage.put("logs/4.log", "new data")
+	report, err = s.ApplyLifecycle(ctx, plan, &ApplyLifecycleOptions{BatchSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Deleted != 2 || report.Failed != 1 || report.Bytes != 8 || !errors.Is(report.Results[1].Err, ErrLifecycleFileChanged) {
+		t.Errorf("unexpected report %v", report)
+	}
+	for _, p := range []string{"logs/3.log", "tmp/stale.upload"} {
+		if _, ok := storage.get(p); ok {
+			t.Errorf("%v was not deleted", p)
+		}
+	}
+	for _, p := range []string{"logs/1.log", "logs/4.log", "tmp/stale.keep", "site/index.html"} {
+		if _, ok := storage.get(p); !ok {
+			t.Errorf("%v was deleted", p)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	if err := report.WriteCSV(buf); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(buf.String()), "\n"); len(lines) != 4 || !strings.HasPrefix(lines[1], "logs/3.log,4,") {
+		t.Errorf("unexpected csv %v", buf.String())
+	}
+
+	if _, err := s.PlanLifecycle(ctx, []LifecycleRule{{Name: "everything"}}); err == nil {
+		t.Error("rules without limits should be refused")
+	}
+}
@@ -0,0 +1,662 @@
+// Copyright (c) 2021 Jan Koppe
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package bunny
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const s3Namespace = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+// S3Gateway is a http.Handler that serves a StorageZone as a single bucket
+// over a subset of the S3 API, for tools that only speak S3:
+//
+//   - ListBuckets, HeadBucket, GetBucketLocation
+//   - ListObjects and ListObjectsV2, with "/" as the only delimiter
+//   - PutObject, GetObject and HeadObject with ranges, DeleteObject
+//   - multipart uploads, with the parts assembled in a local directory
+//     before the object is uploaded to the StorageZone
+//
+// Requests have to be signed with AWS Signature Version 4, in the header or
+// as presigned URL, with one of the configured credentials. Any region is
+// accepted. Only path-style URLs (http://host/bucket/key) are supported.
+//
+// ETags of uploaded objects are MD5 checksums like in S3, but objects that
+// are listed or downloaded have the SHA256 checksum of the Edge Storage API
+// as ETag instead.
+type S3Gateway struct {
+	storage     *StorageClient
+	bucket      string
+	credentials map[string]string
+	// directory multipart uploads are assembled in, defaults to the
+	// system's temporary directory
+	TempDir string
+
+	mu      sync.Mutex
+	uploads map[string]*s3MultipartUpload
+}
+
+// NewS3Gateway creates a gateway serving the StorageZone as bucket, or under
+// the name of the zone if bucket is empty. credentials maps access key IDs
+// to their secret keys.
+func NewS3Gateway(s *StorageClient, bucket string, credentials map[string]string) (*S3Gateway, error) {
+	if len(credentials) == 0 {
+		return nil, errors.New("at least one credential is required")
+	}
+	if bucket == "" {
+		bucket = s.ZoneName
+	}
+	return &S3Gateway{storage: s, bucket: bucket, credentials: credentials, uploads: map[string]*s3MultipartUpload{}}, nil
+}
+
+type s3Error struct {
+	XMLName  xml.Name `xml:"Error"`
+	Code     string
+	Message  string
+	Resource string
+}
+
+func writeS3Error(w http.ResponseWriter, r *http.Request, status int, code string, msg string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	if r.Method != "HEAD" {
+		_, _ = io.WriteString(w, xml.Header)
+		_ = xml.NewEncoder(w).Encode(s3Error{Code: code, Message: msg, Resource: r.URL.Path})
+	}
+}
+
+// writeStorageError translates a failed Edge Storage request into an S3
+// error.
+func writeStorageError(w http.ResponseWriter, r *http.Request, err error) {
+	var se *StorageErrorResponse
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		writeS3Error(w, r, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+	case errors.As(err, &se) && se.HTTPCode == http.StatusBadRequest:
+		writeS3Error(w, r, http.StatusBadRequest, "InvalidRequest", se.Message)
+	case errors.Is(err, errChecksumMismatch):
+		writeS3Error(w, r, http.StatusBadRequest, "XAmzContentSHA256Mismatch", err.Error())
+	default:
+		writeS3Error(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+	}
+}
+
+func writeS3XML(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	_, _ = io.WriteString(w, xml.Header)
+	_ = xml.NewEncoder(w).Encode(v)
+}
+
+func (g *S3Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	sig, err := parseS3Signature(r)
+	if err != nil {
+		writeS3Error(w, r, http.StatusForbidden, "AccessDenied", err.Error())
+		return
+	}
+	secret, ok := g.credentials[sig.AccessKey]
+	if !ok {
+		writeS3Error(w, r, http.StatusForbidden, "InvalidAccessKeyId", "The access key ID does not exist.")
+		return
+	}
+	if err := verifyS3Signature(r, sig, secret, time.Now()); err != nil {
+		writeS3Error(w, r, http.StatusForbidden, "SignatureDoesNotMatch", err.Error())
+		return
+	}
+	body, err := s3Body(r, sig, secret)
+	if err != nil {
+		writeS3Error(w, r, http.StatusBadRequest, "InvalidRequest", err.Error())
+		return
+	}
+
+	p := strings.TrimPrefix(r.URL.Path, "/")
+	bucket, key := p, ""
+	if i := strings.Index(p, "/"); i >= 0 {
+		bucket, key = p[:i], p[i+1:]
+	}
+	q := r.URL.Query()
+
+	switch {
+	case bucket == "" && r.Method == "GET":
+		g.listBuckets(w)
+		return
+	case bucket != g.bucket:
+		writeS3Error(w, r, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist.")
+		return
+	}
+
+	_, hasUploads := q["uploads"]
+	_, hasLocation := q["location"]
+	switch {
+	case key == "" && r.Method == "HEAD":
+		w.WriteHeader(http.StatusOK)
+	case key == "" && r.Method == "GET" && hasLocation:
+		writeS3XML(w, struct {
+			XMLName xml.Name `xml:"LocationConstraint"`
+			Xmlns   string   `xml:"xmlns,attr"`
+		}{Xmlns: s3Namespace})
+	case key == "" && r.Method == "GET":
+		g.listObjects(w, r)
+	case key == "":
+		writeS3Error(w, r, http.StatusNotImplemented, "NotImplemented", "This bucket operation is not supported.")
+	case r.Method == "PUT" && r.Header.Get("X-Amz-Copy-Source") != "":
+		writeS3Error(w, r, http.StatusNotImplemented, "NotImplemented", "Copying objects is not supported.")
+	case r.Method == "PUT" && q.Get("uploadId") != "":
+		g.uploadPart(w, r, key, body)
+	case r.Method == "PUT":
+		g.putObject(w, r, key, body)
+	case r.Method == "POST" && hasUploads:
+		g.createMultipartUpload(w, r, key)
+	case r.Method == "POST" && q.Get("uploadId") != "":
+		g.completeMultipartUpload(w, r, key, body)
+	case r.Method == "GET" || r.Method == "HEAD":
+		g.getObject(w, r, key)
+	case r.Method == "DELETE" && q.Get("uploadId") != "":
+		g.abortMultipartUpload(w, r, key)
+	case r.Method == "DELETE":
+		g.deleteObject(w, r, key)
+	default:
+		writeS3Error(w, r, http.StatusNotImplemented, "NotImplemented", "This object operation is not supported.")
+	}
+}
+
+// s3RequestBody is the decoded body of a request. For signed payloads, Hash
+// is the expected SHA256 of the content as upper case hex.
+type s3RequestBody struct {
+	io.Reader
+	Length int64
+	Hash   string
+}
+
+func s3Body(r *http.Request, sig *s3Signature, secret string) (*s3RequestBody, error) {
+	switch sig.PayloadHash {
+	case s3StreamingPayload, s3StreamingUnsigned:
+		length, err := strconv.ParseInt(r.Header.Get("X-Amz-Decoded-Content-Length"), 10, 64)
+		if err != nil {
+			return nil, errors.New("missing x-amz-decoded-content-length header")
+		}
+		var key []byte
+		if sig.PayloadHash == s3StreamingPayload {
+			key = s3SigningKey(secret, sig.Date, sig.Region, sig.Service)
+		}
+		return &s3RequestBody{newS3ChunkedReader(r.Body, sig, key), length, ""}, nil
+	case s3UnsignedPayload:
+		return &s3RequestBody{r.Body, r.ContentLength, ""}, nil
+	}
+	if _, err := hex.DecodeString(sig.PayloadHash); err != nil || len(sig.PayloadHash) != 64 {
+		return nil, fmt.Errorf("unsupported x-amz-content-sha256 %q", sig.PayloadHash)
+	}
+	return &s3RequestBody{r.Body, r.ContentLength, strings.ToUpper(sig.PayloadHash)}, nil
+}
+
+// readAll reads a small body completely and verifies its hash.
+func (b *s3RequestBody) readAll() ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(b, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if b.Hash != "" {
+		if sum, _ := SHA256Checksum(strings.NewReader(string(data))); sum != b.Hash {
+			return nil, errChecksumMismatch
+		}
+	}
+	return data, nil
+}
+
+func (g *S3Gateway) listBuckets(w http.ResponseWriter) {
+	type bucket struct {
+		Name         string
+		CreationDate string
+	}
+	writeS3XML(w, struct {
+		XMLName xml.Name `xml:"ListAllMyBucketsResult"`
+		Xmlns   string   `xml:"xmlns,attr"`
+		Owner   struct{ ID, DisplayName string }
+		Buckets []bucket `xml:"Buckets>Bucket"`
+	}{Xmlns: s3Namespace, Buckets: []bucket{{g.bucket, time.Time{}.Format(time.RFC3339)}}})
+}
+
+type s3Object struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int64
+	StorageClass string
+}
+
+type s3Prefix struct {
+	Prefix string
+}
+
+type s3ListResult struct {
+	XMLName               xml.Name `xml:"ListBucketResult"`
+	Xmlns                 string   `xml:"xmlns,attr"`
+	Name                  string
+	Prefix                string
+	Delimiter             string `xml:",omitempty"`
+	MaxKeys               int
+	IsTruncated           bool
+	KeyCount              int    `xml:",omitempty"`
+	ContinuationToken     string `xml:",omitempty"`
+	NextContinuationToken string `xml:",omitempty"`
+	StartAfter            string `xml:",omitempty"`
+	Marker                string `xml:",omitempty"`
+	NextMarker            string `xml:",omitempty"`
+	Contents              []s3Object
+	CommonPrefixes        []s3Prefix
+}
+
+func s3ETag(checksum string) string {
+	return `"` + strings.ToLower(checksum) + `"`
+}
+
+func (g *S3Gateway) listObjects(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	v2 := q.Get("list-type") == "2"
+	res := s3ListResult{Xmlns: s3Namespace, Name: g.bucket, Prefix: q.Get("prefix"), Delimiter: q.Get("delimiter"), MaxKeys: 1000}
+	if res.Delimiter != "" && res.Delimiter != "/" {
+		writeS3Error(w, r, http.StatusBadRequest, "InvalidArgument", "Only / is supported as delimiter.")
+		return
+	}
+	if mk := q.Get("max-keys"); mk != "" {
+		n, err := strconv.Atoi(mk)
+		if err != nil || n < 0 {
+			writeS3Error(w, r, http.StatusBadRequest, "InvalidArgument", "Invalid max-keys.")
+			return
+		}
+		if n < res.MaxKeys {
+			res.MaxKeys = n
+		}
+	}
+
+	after := ""
+	if v2 {
+		res.StartAfter = q.Get("start-after")
+		after = res.StartAfter
+		if token := q.Get("continuation-token"); token != "" {
+			res.ContinuationToken = token
+			decoded, err := base64.RawURLEncoding.DecodeString(token)
+			if err != nil {
+				writeS3Error(w, r, http.StatusBadRequest, "InvalidArgument", "Invalid continuation token.")
+				return
+			}
+			after = string(decoded)
+		}
+	} else {
+		res.Marker = q.Get("marker")
+		after = res.Marker
+	}
+
+	// the deepest directory that contains everything matching the prefix
+	dir := ""
+	if i := strings.LastIndex(res.Prefix, "/"); i >= 0 {
+		dir = res.Prefix[:i+1]
+	}
+	type entry struct {
+		key string
+		obj StorageObject
+	}
+	entries := []entry{}
+	add := func(obj StorageObject, key string) {
+		if strings.HasPrefix(key, res.Prefix) && key > after {
+			entries = append(entries, entry{key, obj})
+		}
+	}
+
+	var err error
+	if res.Delimiter == "/" {
+		var objects []StorageObject
+		objects, err = g.storage.ListDirectory(r.Context(), dir)
+		for _, obj := range objects {
+			key := dir + obj.ObjectName
+			if obj.IsDirectory {
+				key += "/"
+			}
+			add(obj, key)
+		}
+	} else {
+		err = g.storage.WalkDirectory(r.Context(), dir, func(obj StorageObject) error {
+			if !obj.IsDirectory {
+				add(obj, obj.RelativePath())
+			}
+			return nil
+		})
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		writeStorageError(w, r, err)
+		return
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+	if len(entries) > res.MaxKeys {
+		entries = entries[:res.MaxKeys]
+		res.IsTruncated = true
+	}
+	for _, e := range entries {
+		if e.obj.IsDirectory {
+			res.CommonPrefixes = append(res.CommonPrefixes, s3Prefix{e.key})
+			continue
+		}
+		res.Contents = append(res.Contents, s3Object{e.key, e.obj.LastChanged.UTC().Format("2006-01-02T15:04:05.000Z"), s3ETag(e.obj.Checksum), e.obj.Length, "STANDARD"})
+	}
+	if res.IsTruncated && len(entries) > 0 {
+		last := entries[len(entries)-1].key
+		if v2 {
+			res.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(last))
+		} else {
+			res.NextMarker = last
+		}
+	}
+	if v2 {
+		res.KeyCount = len(entries)
+	}
+	writeS3XML(w, res)
+}
+
+func (g *S3Gateway) getObject(w http.ResponseWriter, r *http.Request, key string) {
+	f, err := g.storage.FS(r.Context()).open("open", key)
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	defer f.Close()
+	if f.info.IsDir() {
+		writeS3Error(w, r, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+
+	contentType := f.info.obj.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	if f.info.obj.Checksum != "" {
+		w.Header().Set("ETag", s3ETag(f.info.obj.Checksum))
+	}
+	http.ServeContent(w, r, "", f.info.ModTime(), f)
+}
+
+func (g *S3Gateway) putObject(w http.ResponseWriter, r *http.Request, key string, body *s3RequestBody) {
+	if strings.HasSuffix(key, "/") {
+		// folder markers, directories exist implicitly in a StorageZone
+		if _, err := io.Copy(ioutil.Discard, body); err != nil {
+			writeS3Error(w, r, http.StatusBadRequest, "IncompleteBody", err.Error())
+			return
+		}
+		w.Header().Set("ETag", `"d41d8cd98f00b204e9800998ecf8427e"`)
+		return
+	}
+
+	md := md5.New()
+	err := g.storage.PutFile(r.Context(), key, io.TeeReader(body, md), &PutFileOptions{
+		Checksum:      body.Hash,
+		ContentLength: body.Length,
+		ContentType:   r.Header.Get("Content-Type"),
+	})
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", `"`+hex.EncodeToString(md.Sum(nil))+`"`)
+}
+
+func (g *S3Gateway) deleteObject(w http.ResponseWriter, r *http.Request, key string) {
+	err := g.storage.DeleteFile(r.Context(), key)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		writeStorageError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// s3MultipartUpload is an unfinished multipart upload. The parts are stored
+// as files in dir until the upload is completed.
+type s3MultipartUpload struct {
+	key         string
+	dir         string
+	contentType string
+
+	mu    sync.Mutex
+	parts map[int]s3Part
+}
+
+type s3Part struct {
+	etag string
+	size int64
+}
+
+func (u *s3MultipartUpload) partPath(n int) string {
+	return filepath.Join(u.dir, fmt.Sprintf("part-%05d", n))
+}
+
+func (g *S3Gateway) upload(w http.ResponseWriter, r *http.Request, key string) *s3MultipartUpload {
+	g.mu.Lock()
+	u, ok := g.uploads[r.URL.Query().Get("uploadId")]
+	g.mu.Unlock()
+	if !ok || u.key != key {
+		writeS3Error(w, r, http.StatusNotFound, "NoSuchUpload", "The specified multipart upload does not exist.")
+		return nil
+	}
+	return u
+}
+
+func (g *S3Gateway) createMultipartUpload(w http.ResponseWriter, r *http.Request, key string) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		writeS3Error(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	dir, err := ioutil.TempDir(g.TempDir, "bunny-s3-upload")
+	if err != nil {
+		writeS3Error(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+
+	u := &s3MultipartUpload{key: key, dir: dir, contentType: r.Header.Get("Content-Type"), parts: map[int]s3Part{}}
+	uploadID := hex.EncodeToString(id)
+	g.mu.Lock()
+	g.uploads[uploadID] = u
+	g.mu.Unlock()
+
+	writeS3XML(w, struct {
+		XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+		Xmlns    string   `xml:"xmlns,attr"`
+		Bucket   string
+		Key      string
+		UploadID string `xml:"UploadId"`
+	}{Xmlns: s3Namespace, Bucket: g.bucket, Key: key, UploadID: uploadID})
+}
+
+func (g *S3Gateway) uploadPart(w http.ResponseWriter, r *http.Request, key string, body *s3RequestBody) {
+	n, err := strconv.Atoi(r.URL.Query().Get("partNumber"))
+	if err != nil || n < 1 || n > 10000 {
+		writeS3Error(w, r, http.StatusBadRequest, "InvalidArgument", "Part number must be an integer between 1 and 10000.")
+		return
+	}
+	u := g.upload(w, r, key)
+	if u == nil {
+		return
+	}
+
+	f, err := os.Create(u.partPath(n))
+	if err != nil {
+		writeS3Error(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	md, sh := md5.New(), sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, md, sh), body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		writeS3Error(w, r, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+	if body.Hash != "" && strings.ToUpper(hex.EncodeToString(sh.Sum(nil))) != body.Hash {
+		writeStorageError(w, r, errChecksumMismatch)
+		return
+	}
+
+	etag := hex.EncodeToString(md.Sum(nil))
+	u.mu.Lock()
+	u.parts[n] = s3Part{etag, size}
+	u.mu.Unlock()
+	w.Header().Set("ETag", `"`+etag+`"`)
+}
+
+func (g *S3Gateway) completeMultipartUpload(w http.ResponseWriter, r *http.Request, key string, body *s3RequestBody) {
+	u := g.upload(w, r, key)
+	if u == nil {
+		return
+	}
+	data, err := body.readAll()
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	req := struct {
+		Parts []struct {
+			PartNumber int
+			ETag       string
+		} `xml:"Part"`
+	}{}
+	if err := xml.Unmarshal(data, &req); err != nil || len(req.Parts) == 0 {
+		writeS3Error(w, r, http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed.")
+		return
+	}
+
+	u.mu.Lock()
+	parts := []s3Part{}
+	paths := []string{}
+	md := md5.New()
+	for i, p := range req.Parts {
+		part, ok := u.parts[p.PartNumber]
+		if i > 0 && p.PartNumber <= req.Parts[i-1].PartNumber {
+			ok = false
+		}
+		if !ok || strings.Trim(p.ETag, `"`) != part.etag {
+			u.mu.Unlock()
+			writeS3Error(w, r, http.StatusBadRequest, "InvalidPart", fmt.Sprintf("Part %d is missing or doesn't match.", p.PartNumber))
+			return
+		}
+		parts = append(parts, part)
+		paths = append(paths, u.partPath(p.PartNumber))
+		raw, _ := hex.DecodeString(part.etag)
+		md.Write(raw)
+	}
+	u.mu.Unlock()
+
+	err = g.putParts(r, key, u.contentType, paths)
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+
+	g.removeUpload(r.URL.Query().Get("uploadId"))
+	writeS3XML(w, struct {
+		XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+		Xmlns   string   `xml:"xmlns,attr"`
+		Bucket  string
+		Key     string
+		ETag    string
+	}{Xmlns: s3Namespace, Bucket: g.bucket, Key: key, ETag: fmt.Sprintf(`"%x-%d"`, md.Sum(nil), len(parts))})
+}
+
+// putParts uploads the concatenated parts as a single file, with the SHA256
+// computed in a first pass so the Edge Storage API can verify it.
+func (g *S3Gateway) putParts(r *http.Request, key string, contentType string, paths []string) error {
+	open := func() (io.Reader, func(), int64, error) {
+		files := []*os.File{}
+		readers := []io.Reader{}
+		closeAll := func() {
+			for _, f := range files {
+				f.Close()
+			}
+		}
+		var size int64
+		for _, p := range paths {
+			f, err := os.Open(p)
+			if err != nil {
+				closeAll()
+				return nil, nil, 0, err
+			}
+			info, err := f.Stat()
+			if err != nil {
+				closeAll()
+				return nil, nil, 0, err
+			}
+			files = append(files, f)
+			readers = append(readers, f)
+			size += info.Size()
+		}
+		return io.MultiReader(readers...), closeAll, size, nil
+	}
+
+	mr, closeAll, _, err := open()
+	if err != nil {
+		return err
+	}
+	sum, err := SHA256Checksum(mr)
+	closeAll()
+	if err != nil {
+		return err
+	}
+
+	mr, closeAll, size, err := open()
+	if err != nil {
+		return err
+	}
+	defer closeAll()
+	return g.storage.PutFile(r.Context(), key, mr, &PutFileOptions{Checksum: sum, ContentLength: size, ContentType: contentType})
+}
+
+func (g *S3Gateway) abortMultipartUpload(w http.ResponseWriter, r *http.Request, key string) {
+	if u := g.upload(w, r, key); u == nil {
+		return
+	}
+	g.removeUpload(r.URL.Query().Get("uploadId"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (g *S3Gateway) removeUpload(id string) {
+	g.mu.Lock()
+	u, ok := g.uploads[id]
+	delete(g.uploads, id)
+	g.mu.Unlock()
+	if ok {
+		os.RemoveAll(u.dir)
+	}
+}
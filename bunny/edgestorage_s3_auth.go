@@ -0,0 +1,332 @@
+// Copyright (c) 2021 Jan Koppe
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package bunny
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AWS Signature Version 4, as used by S3. Requests are signed either in the
+// Authorization header, or in the query string of presigned URLs. See
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-authenticating-requests.html
+
+const (
+	s3SignatureAlgorithm  = "AWS4-HMAC-SHA256"
+	s3TimeFormat          = "20060102T150405Z"
+	s3UnsignedPayload     = "UNSIGNED-PAYLOAD"
+	s3StreamingPayload    = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	s3StreamingUnsigned   = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+	s3EmptyPayloadHash    = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	s3MaxClockSkew        = 15 * time.Minute
+	s3MaxPresignedExpires = 7 * 24 * time.Hour
+)
+
+// s3Signature is the parsed signature of a request.
+type s3Signature struct {
+	AccessKey     string
+	Date          string
+	Region        string
+	Service       string
+	SignedHeaders []string
+	Signature     string
+	// request time in s3TimeFormat
+	AmzDate string
+	// hex SHA256 of the body, or one of the special values for unsigned and
+	// streaming payloads
+	PayloadHash string
+	Presigned   bool
+	Expires     time.Duration
+}
+
+func (sig *s3Signature) scope() string {
+	return strings.Join([]string{sig.Date, sig.Region, sig.Service, "aws4_request"}, "/")
+}
+
+// signs reports whether a header is part of the signature.
+func (sig *s3Signature) signs(header string) bool {
+	for _, h := range sig.SignedHeaders {
+		if h == header {
+			return true
+		}
+	}
+	return false
+}
+
+// parseS3Signature extracts the signature from the Authorization header or
+// the query string.
+func parseS3Signature(r *http.Request) (*s3Signature, error) {
+	sig := &s3Signature{}
+	var credential, signedHeaders string
+
+	q := r.URL.Query()
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		if !strings.HasPrefix(auth, s3SignatureAlgorithm+" ") {
+			return nil, errors.New("unsupported authorization type")
+		}
+		for _, part := range strings.Split(strings.TrimPrefix(auth, s3SignatureAlgorithm+" "), ",") {
+			kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+			if len(kv) != 2 {
+				return nil, errors.New("malformed authorization header")
+			}
+			switch kv[0] {
+			case "Credential":
+				credential = kv[1]
+			case "SignedHeaders":
+				signedHeaders = kv[1]
+			case "Signature":
+				sig.Signature = kv[1]
+			}
+		}
+		sig.AmzDate = r.Header.Get("X-Amz-Date")
+		sig.PayloadHash = r.Header.Get("X-Amz-Content-Sha256")
+		if sig.PayloadHash == "" {
+			return nil, errors.New("missing x-amz-content-sha256 header")
+		}
+	} else if q.Get("X-Amz-Algorithm") != "" {
+		if q.Get("X-Amz-Algorithm") != s3SignatureAlgorithm {
+			return nil, errors.New("unsupported signature algorithm")
+		}
+		credential = q.Get("X-Amz-Credential")
+		signedHeaders = q.Get("X-Amz-SignedHeaders")
+		sig.Signature = q.Get("X-Amz-Signature")
+		sig.AmzDate = q.Get("X-Amz-Date")
+		sig.PayloadHash = s3UnsignedPayload
+		sig.Presigned = true
+		expires, err := strconv.Atoi(q.Get("X-Amz-Expires"))
+		if err != nil || expires <= 0 || time.Duration(expires)*time.Second > s3MaxPresignedExpires {
+			return nil, errors.New("invalid X-Amz-Expires")
+		}
+		sig.Expires = time.Duration(expires) * time.Second
+	} else {
+		return nil, errors.New("request is not signed")
+	}
+
+	parts := strings.Split(credential, "/")
+	if len(parts) != 5 || parts[4] != "aws4_request" {
+		return nil, errors.New("malformed credential")
+	}
+	sig.AccessKey, sig.Date, sig.Region, sig.Service = parts[0], parts[1], parts[2], parts[3]
+	sig.SignedHeaders = strings.Split(signedHeaders, ";")
+	if sig.Signature == "" || signedHeaders == "" {
+		return nil, errors.New("incomplete signature")
+	}
+	if !sig.signs("host") {
+		// like AWS, so a signature can't be replayed against another host
+		return nil, errors.New("host header is not signed")
+	}
+	if !strings.HasPrefix(sig.AmzDate, sig.Date) {
+		return nil, errors.New("request date doesn't match the credential scope")
+	}
+	return sig, nil
+}
+
+// verifyS3Signature checks the signature of a request against the secret of
+// its access key, and that it isn't expired.
+func verifyS3Signature(r *http.Request, sig *s3Signature, secret string, now time.Time) error {
+	t, err := time.Parse(s3TimeFormat, sig.AmzDate)
+	if err != nil {
+		return errors.New("invalid request date")
+	}
+	if sig.Presigned {
+		if now.Before(t.Add(-s3MaxClockSkew)) || now.After(t.Add(sig.Expires)) {
+			return errors.New("presigned url is expired")
+		}
+	} else if now.Sub(t) > s3MaxClockSkew || t.Sub(now) > s3MaxClockSkew {
+		return errors.New("request time is too skewed")
+	}
+
+	canonical := s3CanonicalRequest(r, sig.SignedHeaders, sig.PayloadHash, sig.Presigned)
+	key := s3SigningKey(secret, sig.Date, sig.Region, sig.Service)
+	expected := hex.EncodeToString(hmacSHA256(key, s3StringToSign(sig.AmzDate, sig.scope(), canonical)))
+	if !hmac.Equal([]byte(expected), []byte(sig.Signature)) {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
+
+func s3CanonicalRequest(r *http.Request, signedHeaders []string, payloadHash string, presigned bool) string {
+	params := []string{}
+	for k, vs := range r.URL.Query() {
+		if presigned && k == "X-Amz-Signature" {
+			continue
+		}
+		for _, v := range vs {
+			params = append(params, s3URIEncode(k, true)+"="+s3URIEncode(v, true))
+		}
+	}
+	sort.Strings(params)
+
+	headers := &strings.Builder{}
+	for _, h := range signedHeaders {
+		v := ""
+		if h == "host" {
+			v = r.Host
+		} else {
+			v = strings.Join(r.Header[http.CanonicalHeaderKey(h)], ",")
+		}
+		headers.WriteString(h + ":" + strings.Join(strings.Fields(v), " ") + "\n")
+	}
+
+	return strings.Join([]string{
+		r.Method,
+		s3URIEncode(r.URL.Path, false),
+		strings.Join(params, "&"),
+		headers.String(),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+}
+
+func s3StringToSign(amzDate string, scope string, canonicalRequest string) string {
+	h := sha256.Sum256([]byte(canonicalRequest))
+	return strings.Join([]string{s3SignatureAlgorithm, amzDate, scope, hex.EncodeToString(h[:])}, "\n")
+}
+
+func s3SigningKey(secret string, date string, region string, service string) []byte {
+	k := hmacSHA256([]byte("AWS4"+secret), date)
+	k = hmacSHA256(k, region)
+	k = hmacSHA256(k, service)
+	return hmacSHA256(k, "aws4_request")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3URIEncode encodes everything except unreserved characters, and slashes
+// unless encodeSlash is set.
+func s3URIEncode(s string, encodeSlash bool) string {
+	b := &strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '.', c == '_', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// s3ChunkedReader decodes an aws-chunked body. With a signing key, every
+// chunk signature is verified, chained to the signature of the request.
+// Trailing headers are skipped.
+type s3ChunkedReader struct {
+	r         *bufio.Reader
+	key       []byte
+	sig       *s3Signature
+	prevSig   string
+	chunk     *bytes.Reader
+	remaining int64
+	done      bool
+}
+
+func newS3ChunkedReader(r io.Reader, sig *s3Signature, key []byte) *s3ChunkedReader {
+	return &s3ChunkedReader{r: bufio.NewReader(r), key: key, sig: sig, prevSig: sig.Signature}
+}
+
+func (cr *s3ChunkedReader) Read(p []byte) (int, error) {
+	for cr.chunk == nil || cr.chunk.Len() == 0 {
+		if cr.done {
+			return 0, io.EOF
+		}
+		if err := cr.nextChunk(); err != nil {
+			return 0, err
+		}
+	}
+	return cr.chunk.Read(p)
+}
+
+func (cr *s3ChunkedReader) nextChunk() error {
+	line, err := cr.readLine()
+	if err != nil {
+		return err
+	}
+	sizeHex, ext := line, ""
+	if i := strings.Index(line, ";"); i >= 0 {
+		sizeHex, ext = line[:i], line[i+1:]
+	}
+	size, err := strconv.ParseInt(sizeHex, 16, 64)
+	if err != nil || size < 0 || size > 16<<20 {
+		return errors.New("malformed aws-chunked encoding")
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(cr.r, data); err != nil {
+		return err
+	}
+
+	if cr.key != nil {
+		if !strings.HasPrefix(ext, "chunk-signature=") {
+			return errors.New("missing chunk signature")
+		}
+		h := sha256.Sum256(data)
+		sts := strings.Join([]string{s3SignatureAlgorithm + "-PAYLOAD", cr.sig.AmzDate, cr.sig.scope(), cr.prevSig, s3EmptyPayloadHash, hex.EncodeToString(h[:])}, "\n")
+		expected := hex.EncodeToString(hmacSHA256(cr.key, sts))
+		got := strings.TrimPrefix(ext, "chunk-signature=")
+		if !hmac.Equal([]byte(expected), []byte(got)) {
+			return errors.New("chunk signature mismatch")
+		}
+		cr.prevSig = got
+	}
+
+	if size == 0 {
+		// skip trailers up to the final empty line
+		for {
+			line, err := cr.readLine()
+			if err != nil || line == "" {
+				cr.done = true
+				return err
+			}
+		}
+	}
+	if l, err := cr.readLine(); err != nil || l != "" {
+		return errors.New("malformed aws-chunked encoding")
+	}
+	cr.chunk = bytes.NewReader(data)
+	return nil
+}
+
+func (cr *s3ChunkedReader) readLine() (string, error) {
+	line, err := cr.r.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	} else if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return strings.TrimRight(line, "\r\n"), err
+}
@@ -0,0 +1,366 @@
+// Copyright (c) 2021 Jan Koppe
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package bunny
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type SyncChangeType int32
+
+const (
+	SCTCreate SyncChangeType = 0
+	SCTUpdate SyncChangeType = 1
+	SCTDelete SyncChangeType = 2
+)
+
+func (t SyncChangeType) String() string {
+	switch t {
+	case SCTCreate:
+		return "create"
+	case SCTUpdate:
+		return "update"
+	case SCTDelete:
+		return "delete"
+	}
+	return fmt.Sprintf("SyncChangeType(%d)", int32(t))
+}
+
+// SyncOptions configures SyncDirectory. The zero value uploads new and
+// changed files, but doesn't delete anything.
+type SyncOptions struct {
+	// delete remote files that don't exist locally
+	Delete bool
+	// only sync files matching one of these patterns, if set
+	Include []string
+	// skip files and directories matching one of these patterns. Excluded
+	// remote files are never deleted.
+	Exclude []string
+	// only compute the changes, don't upload or delete anything
+	DryRun bool
+	// number of uploads and deletes running at the same time, defaults to 4
+	Concurrency int
+	// called after each change was applied, from the goroutine applying it
+	OnChange func(SyncChange)
+}
+
+// SyncChange is a single upload or delete. Path is relative to the synced
+// directories, RemotePath the full path in the StorageZone.
+type SyncChange struct {
+	Type       SyncChangeType
+	Path       string
+	RemotePath string
+	Size       int64
+	Err        error
+}
+
+// SyncSummary describes what SyncDirectory did, or would have done in a dry
+// run.
+type SyncSummary struct {
+	Changes       []SyncChange
+	Unchanged     int
+	BytesUploaded int64
+	Failed        int
+	DryRun        bool
+}
+
+// ChangedPaths returns the remote paths of all successfully changed files,
+// including deleted ones.
+func (s *SyncSummary) ChangedPaths() []string {
+	paths := []string{}
+	for _, ch := range s.Changes {
+		if ch.Err == nil {
+			paths = append(paths, ch.RemotePath)
+		}
+	}
+	return paths
+}
+
+// PurgeURLs returns the URLs of all changed files on every hostname of a
+// PullZone serving the StorageZone, ready to be passed to Client.PurgeURLs.
+func (s *SyncSummary) PurgeURLs(pz *PullZone) []string {
+	urls := []string{}
+	for _, p := range s.ChangedPaths() {
+		urls = append(urls, PullZoneURLs(pz, (&url.URL{Path: "/" + p}).EscapedPath())...)
+	}
+	return urls
+}
+
+func (s *SyncSummary) String() string {
+	counts := map[SyncChangeType]int{}
+	for _, ch := range s.Changes {
+		counts[ch.Type]++
+	}
+	return fmt.Sprintf("%d created, %d updated, %d deleted, %d unchanged, %d failed",
+		counts[SCTCreate], counts[SCTUpdate], counts[SCTDelete], s.Unchanged, s.Failed)
+}
+
+type syncLocalFile struct {
+	abs  string
+	info os.FileInfo
+}
+
+// SyncDirectory makes remotePrefix in the StorageZone match the local
+// directory. Files are compared by size and SHA256 checksum, or by
+// modification time if the remote file has no checksum. New and changed files
+// are uploaded in parallel, and remote files missing locally are deleted if
+// opts.Delete is set.
+//
+// Patterns in Include and Exclude use the syntax of path.Match and are
+// matched against the slash separated path relative to the synced
+// directories. Patterns without a slash also match against the file name
+// alone, and a pattern ending in /** matches everything below a directory.
+func (s *StorageClient) SyncDirectory(ctx context.Context, localDir string, remotePrefix string, opts *SyncOptions) (*SyncSummary, error) {
+	o := SyncOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.Concurrency <= 0 {
+		o.Concurrency = 4
+	}
+
+	remotePrefix, err := cleanStoragePath(remotePrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	local := map[string]syncLocalFile{}
+	err = filepath.Walk(localDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(localDir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			return nil
+		}
+		if info.IsDir() {
+			if matchSyncPatterns(o.Exclude, rel) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode().IsRegular() && syncIncluded(o, rel) {
+			local[rel] = syncLocalFile{p, info}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	remote := map[string]StorageObject{}
+	err = s.WalkDirectory(ctx, remotePrefix, func(obj StorageObject) error {
+		rel := strings.TrimPrefix(strings.TrimPrefix(obj.RelativePath(), remotePrefix), "/")
+		if obj.IsDirectory {
+			if matchSyncPatterns(o.Exclude, rel) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if syncIncluded(o, rel) {
+			remote[rel] = obj
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	summary := &SyncSummary{Changes: []SyncChange{}, DryRun: o.DryRun}
+
+	rels := make([]string, 0, len(local))
+	for rel := range local {
+		rels = append(rels, rel)
+	}
+	sort.Strings(rels)
+
+	for _, rel := range rels {
+		lf := local[rel]
+		ch := SyncChange{Type: SCTCreate, Path: rel, RemotePath: path.Join(remotePrefix, rel), Size: lf.info.Size()}
+		if obj, ok := remote[rel]; ok {
+			changed, err := syncFileChanged(lf, obj)
+			if err != nil {
+				return nil, err
+			}
+			if !changed {
+				summary.Unchanged++
+				continue
+			}
+			ch.Type = SCTUpdate
+		}
+		summary.Changes = append(summary.Changes, ch)
+	}
+
+	if o.Delete {
+		deletes := []string{}
+		for rel := range remote {
+			if _, ok := local[rel]; !ok {
+				deletes = append(deletes, rel)
+			}
+		}
+		sort.Strings(deletes)
+		for _, rel := range deletes {
+			summary.Changes = append(summary.Changes, SyncChange{Type: SCTDelete, Path: rel, RemotePath: path.Join(remotePrefix, rel), Size: remote[rel].Length})
+		}
+	}
+
+	if o.DryRun {
+		return summary, nil
+	}
+
+	// uploads first, so a failing sync doesn't leave the remote side with
+	// files missing
+	for _, t := range []SyncChangeType{SCTCreate, SCTUpdate, SCTDelete} {
+		s.applySyncChanges(ctx, o, summary, t, local)
+	}
+
+	for _, ch := range summary.Changes {
+		if ch.Err != nil {
+			summary.Failed++
+		} else if ch.Type != SCTDelete {
+			summary.BytesUploaded += ch.Size
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return summary, err
+	}
+	if summary.Failed > 0 {
+		return summary, fmt.Errorf("%d of %d changes failed", summary.Failed, len(summary.Changes))
+	}
+	return summary, nil
+}
+
+func (s *StorageClient) applySyncChanges(ctx context.Context, o SyncOptions, summary *SyncSummary, t SyncChangeType, local map[string]syncLocalFile) {
+	jobs := make(chan *SyncChange)
+	var wg sync.WaitGroup
+	for w := 0; w < o.Concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ch := range jobs {
+				if ch.Type == SCTDelete {
+					ch.Err = s.DeleteFile(ctx, ch.RemotePath)
+				} else {
+					ch.Err = s.uploadLocalFile(ctx, local[ch.Path].abs, ch.RemotePath)
+				}
+				if o.OnChange != nil {
+					o.OnChange(*ch)
+				}
+			}
+		}()
+	}
+
+	for i := range summary.Changes {
+		if summary.Changes[i].Type == t {
+			jobs <- &summary.Changes[i]
+		}
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+func (s *StorageClient) uploadLocalFile(ctx context.Context, localPath string, remotePath string) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sum, err := SHA256Checksum(f)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return err
+	}
+
+	return s.PutFile(ctx, remotePath, f, &PutFileOptions{Checksum: sum, ContentLength: info.Size()})
+}
+
+func syncFileChanged(lf syncLocalFile, obj StorageObject) (bool, error) {
+	if lf.info.Size() != obj.Length {
+		return true, nil
+	}
+	if obj.Checksum == "" {
+		return lf.info.ModTime().After(obj.LastChanged.Time), nil
+	}
+
+	f, err := os.Open(lf.abs)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	sum, err := SHA256Checksum(f)
+	if err != nil {
+		return false, err
+	}
+	return !strings.EqualFold(sum, obj.Checksum), nil
+}
+
+func syncIncluded(o SyncOptions, rel string) bool {
+	if len(o.Include) > 0 && !matchSyncPatterns(o.Include, rel) {
+		return false
+	}
+	return !matchSyncPatterns(o.Exclude, rel)
+}
+
+func matchSyncPatterns(patterns []string, rel string) bool {
+	for _, p := range patterns {
+		if strings.HasSuffix(p, "/**") {
+			dir := strings.TrimSuffix(p, "/**")
+			if ok, _ := path.Match(dir, rel); ok {
+				return true
+			}
+			for d := path.Dir(rel); d != "."; d = path.Dir(d) {
+				if ok, _ := path.Match(dir, d); ok {
+					return true
+				}
+			}
+			continue
+		}
+		if ok, _ := path.Match(p, rel); ok {
+			return true
+		}
+		if !strings.Contains(p, "/") {
+			if ok, _ := path.Match(p, path.Base(rel)); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
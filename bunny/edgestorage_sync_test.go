@@ -0,0 +1,164 @@
+// Copyright (c) 2021 Jan Koppe
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package bunny
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSyncDirectory(t *testing.T) {
+	s, fs := newFakeStorage(t)
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "bunny-sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, content := range map[string]string{
+		"index.html":        "<h1>home</h1>",
+		"css/site.css":      "body {}",
+		"about/index.html":  "<h1>about</h1>",
+		"drafts/wip.html":   "unfinished",
+		"notes.tmp":         "scratch",
+		"css/unchanged.css": "p {}",
+	} {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fs.put("site/css/unchanged.css", "p {}")
+	fs.put("site/css/site.css", "body { old }")
+	fs.put("site/old.html", "gone")
+	fs.put("site/drafts/keep.html", "excluded remote files stay")
+	fs.put("other/file.txt", "outside of the prefix")
+
+	opts := &SyncOptions{Delete: true, Exclude: []string{"drafts/**", "*.tmp"}, DryRun: true}
+	summary, err := s.SyncDirectory(ctx, dir, "/site/", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := summary.String(); got != "2 created, 1 updated, 1 deleted, 1 unchanged, 0 failed" {
+		t.Errorf("unexpected dry run summary %v", got)
+	}
+	if _, ok := fs.get("site/index.html"); ok {
+		t.Error("dry run uploaded files")
+	}
+
+	opts.DryRun = false
+	changed := 0
+	opts.OnChange = func(SyncChange) { changed++ }
+	opts.Concurrency = 1
+	summary, err = s.SyncDirectory(ctx, dir, "site", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"site/about/index.html", "site/css/site.css", "site/index.html", "site/old.html"}
+	if got := summary.ChangedPaths(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got changed paths %v, want %v", got, want)
+	}
+	if changed != 4 || summary.BytesUploaded != int64(len("<h1>about</h1><h1>home</h1>body {}")) {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+	if got, _ := fs.get("site/css/site.css"); got != "body {}" {
+		t.Errorf("changed file was not uploaded, got %q", got)
+	}
+	if _, ok := fs.get("site/old.html"); ok {
+		t.Error("remote extra was not deleted")
+	}
+	if _, ok := fs.get("site/drafts/keep.html"); !ok {
+		t.Error("excluded remote file was deleted")
+	}
+	if _, ok := fs.get("other/file.txt"); !ok {
+		t.Error("file outside of the prefix was deleted")
+	}
+
+	pz := &PullZone{Name: "mysite"}
+	if urls := summary.PurgeURLs(pz); len(urls) != 4 || urls[0] != "https://mysite.b-cdn.net/site/about/index.html" {
+		t.Errorf("unexpected purge URLs %v", urls)
+	}
+
+	summary, err = s.SyncDirectory(ctx, dir, "site", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summary.Changes) != 0 || summary.Unchanged != 4 {
+		t.Errorf("second sync wasn't a no-op: %v", summary)
+	}
+}
+
+func TestSyncDirectoryMissingPrefix(t *testing.T) {
+	s, fs := newFakeStorage(t)
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "bunny-sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "index.html"), []byte("<h1>home</h1>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.ListDirectory(ctx, "new-site"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected listing a missing directory to fail with not exist, got %v", err)
+	}
+
+	summary, err := s.SyncDirectory(ctx, dir, "new-site", &SyncOptions{Delete: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := summary.String(); got != "1 created, 0 updated, 0 deleted, 0 unchanged, 0 failed" {
+		t.Errorf("unexpected summary %v", got)
+	}
+	if got, _ := fs.get("new-site/index.html"); got != "<h1>home</h1>" {
+		t.Errorf("file was not uploaded, got %q", got)
+	}
+}
+func TestMatchSyncPatterns(t *testing.T) {
+	for _, tc := range []struct {
+		pattern string
+		path    string
+		match   bool
+	}{
+		{"*.html", "index.html", true},
+		{"*.html", "a/b/index.html", true},
+		{"a/*.html", "a/b/index.html", false},
+		{"a/*/index.html", "a/b/index.html", true},
+		{"a/**", "a/b/index.html", true},
+		{"a/**", "a", true},
+		{"a/**", "b/a/index.html", false},
+	} {
+		if got := matchSyncPatterns([]string{tc.pattern}, tc.path); got != tc.match {
+			t.Errorf("matchSyncPatterns(%q, %q) = %v", tc.pattern, tc.path, got)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+// Copyright (c) 2021 Jan Koppe
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package bunny
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeStorage is an in-memory stand-in for the Edge Storage API, holding a
+// single StorageZone.
+type fakeStorage struct {
+	mu    sync.Mutex
+	zone  string
+	files map[string]fakeStorageFile
+	// listings leave out checksums, like for some older files
+	noChecksums bool
+	// number of file downloads
+	downloads int
+}
+
+type fakeStorageFile struct {
+	data        []byte
+	contentType string
+	modified    time.Time
+}
+
+func newFakeStorage(t *testing.T) (*StorageClient, *fakeStorage) {
+	fs := &fakeStorage{zone: "testzone", files: map[string]fakeStorageFile{}}
+	srv := httptest.NewServer(fs)
+	t.Cleanup(srv.Close)
+
+	s, err := NewStorageClient(fs.zone, "password", "DE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.BaseURL, _ = url.Parse(srv.URL)
+	return s, fs
+}
+
+func (fs *fakeStorage) put(p string, data string) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.files[p] = fakeStorageFile{[]byte(data), "application/octet-stream", time.Now().UTC()}
+}
+
+func (fs *fakeStorage) get(p string) (string, bool) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	f, ok := fs.files[p]
+	return string(f.data), ok
+}
+
+func (fs *fakeStorage) fail(w http.ResponseWriter, code int, msg string) {
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(StorageErrorResponse{code, msg})
+}
+
+func (fs *fakeStorage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("AccessKey") != "password" {
+		fs.fail(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	prefix := "/" + fs.zone + "/"
+	if !strings.HasPrefix(r.URL.Path+"/", prefix) {
+		fs.fail(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	p := strings.TrimPrefix(r.URL.Path+"/", prefix)
+	p = strings.Trim(p, "/")
+	isDir := strings.HasSuffix(r.URL.Path, "/")
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	switch {
+	case r.Method == "PUT":
+		data, _ := ioutil.ReadAll(r.Body)
+		if sum := r.Header.Get("Checksum"); sum != "" {
+			if actual, _ := SHA256Checksum(bytes.NewReader(data)); actual != sum {
+				fs.fail(w, http.StatusBadRequest, "Checksum mismatch")
+				return
+			}
+		}
+		if isDir {
+			// empty directories are kept as marker entries
+			p += "/"
+		}
+		fs.files[p] = fakeStorageFile{data, r.Header.Get("Content-Type"), time.Now().UTC()}
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"HttpCode":201,"Message":"File uploaded."}`))
+	case r.Method == "GET" && isDir:
+		if !fs.exists(p) {
+			fs.fail(w, http.StatusNotFound, "Object Not Found")
+			return
+		}
+		objects := fs.list(p)
+		_ = json.NewEncoder(w).Encode(&objects)
+	case r.Method == "GET":
+		f, ok := fs.files[p]
+		if !ok {
+			fs.fail(w, http.StatusNotFound, "Object Not Found")
+			return
+		}
+		fs.downloads++
+		w.Header().Set("Content-Type", f.contentType)
+		http.ServeContent(w, r, path.Base(p), f.modified, bytes.NewReader(f.data))
+	case r.Method == "DELETE":
+		deleted := false
+		for k := range fs.files {
+			if k == p || (isDir && strings.HasPrefix(k, p+"/")) {
+				delete(fs.files, k)
+				deleted = true
+			}
+		}
+		if !deleted {
+			fs.fail(w, http.StatusNotFound, "Object Not Found")
+			return
+		}
+		_, _ = w.Write([]byte(`{"HttpCode":200,"Message":"File deleted successfuly."}`))
+	default:
+		fs.fail(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// exists reports whether a directory exists. Like the real API, listing a
+// missing directory fails with 404, while the root always exists.
+func (fs *fakeStorage) exists(dir string) bool {
+	if dir == "" {
+		return true
+	}
+	for k := range fs.files {
+		if strings.HasPrefix(k, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (fs *fakeStorage) list(dir string) []StorageObject {
+	objects := []StorageObject{}
+	seen := map[string]int{}
+	prefix := ""
+	if dir != "" {
+		prefix = dir + "/"
+	}
+	for k, f := range fs.files {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(k, prefix)
+		if rest == "" {
+			continue
+		}
+		o := StorageObject{
+			StorageZoneName: fs.zone,
+			Path:            "/" + fs.zone + "/" + prefix,
+			LastChanged:     BunnyTime{f.modified.Truncate(time.Second)},
+		}
+		if i := strings.Index(rest, "/"); i >= 0 {
+			// directories carry the latest change of their contents
+			if j, ok := seen[rest[:i]]; ok {
+				if o.LastChanged.After(objects[j].LastChanged.Time) {
+					objects[j].LastChanged = o.LastChanged
+				}
+				continue
+			}
+			seen[rest[:i]] = len(objects)
+			o.ObjectName, o.IsDirectory = rest[:i], true
+		} else {
+			sum, _ := SHA256Checksum(bytes.NewReader(f.data))
+			if fs.noChecksums {
+				sum = ""
+			}
+			o.ObjectName, o.Length, o.Checksum, o.ContentType = rest, int64(len(f.data)), sum, f.contentType
+		}
+		objects = append(objects, o)
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].ObjectName < objects[j].ObjectName })
+	return objects
+}
+
+func TestStorageClient(t *testing.T) {
+	s, fs := newFakeStorage(t)
+	ctx := context.Background()
+
+	content := "hello edge storage"
+	sum, _ := SHA256Checksum(strings.NewReader(content))
+	if err := s.PutFile(ctx, "/docs/hello world.txt", strings.NewReader(content), &PutFileOptions{Checksum: sum}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := fs.get("docs/hello world.txt"); got != content {
+		t.Errorf("unexpected stored content %q", got)
+	}
+
+	err := s.PutFile(ctx, "docs/bad.txt", strings.NewReader(content), &PutFileOptions{Checksum: "00"})
+	if e, ok := err.(*StorageErrorResponse); !ok || e.HTTPCode != http.StatusBadRequest {
+		t.Errorf("expected checksum mismatch error, got %v", err)
+	}
+
+	f, err := s.GetFile(ctx, "docs/hello world.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(f)
+	f.Close()
+	if string(data) != content || f.Size != int64(len(content)) {
+		t.Errorf("unexpected download %q (%d bytes)", data, f.Size)
+	}
+
+	f, err = s.GetFileRange(ctx, "docs/hello world.txt", 6, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ = ioutil.ReadAll(f)
+	f.Close()
+	if string(data) != "edge" || f.TotalSize != int64(len(content)) {
+		t.Errorf("unexpected ranged download %q of %d bytes", data, f.TotalSize)
+	}
+
+	fs.put("docs/sub/nested.txt", "x")
+	objects, err := s.ListDirectory(ctx, "docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 2 || objects[0].ObjectName != "hello world.txt" || !objects[1].IsDirectory {
+		t.Fatalf("unexpected listing %+v", objects)
+	}
+	if objects[0].Checksum != sum || objects[0].Length != int64(len(content)) || !objects[0].LastChanged.IsSet() {
+		t.Errorf("unexpected object details %+v", objects[0])
+	}
+	if p := objects[0].RelativePath(); p != "docs/hello world.txt" {
+		t.Errorf("unexpected relative path %v", p)
+	}
+
+	if err := s.DeleteFile(ctx, "docs/hello world.txt"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.GetFile(ctx, "docs/hello world.txt")
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected not exist error, got %v", err)
+	}
+
+	if err := s.DeleteDirectory(ctx, "docs"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fs.get("docs/sub/nested.txt"); ok {
+		t.Error("directory was not deleted")
+	}
+
+	if err := s.DeleteDirectory(ctx, "/"); err == nil {
+		t.Error("deleting the root directory should be refused")
+	}
+	if _, err := s.GetFile(ctx, "../other/file"); err == nil {
+		t.Error("paths leaving the storage zone should be refused")
+	}
+}
+
+func TestStorageEndpoint(t *testing.T) {
+	for region, want := range map[string]string{
+		"DE":  "storage.bunnycdn.com",
+		"":    "storage.bunnycdn.com",
+		"NY":  "ny.storage.bunnycdn.com",
+		"SYD": "syd.storage.bunnycdn.com",
+	} {
+		if got := StorageEndpoint(region); got != want {
+			t.Errorf("StorageEndpoint(%q) = %v, want %v", region, got, want)
+		}
+	}
+}
@@ -0,0 +1,424 @@
+// Copyright (c) 2021 Jan Koppe
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package bunny
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type TransferDirection int32
+
+const (
+	TDUpload   TransferDirection = 0
+	TDDownload TransferDirection = 1
+)
+
+func (d TransferDirection) String() string {
+	switch d {
+	case TDUpload:
+		return "upload"
+	case TDDownload:
+		return "download"
+	}
+	return fmt.Sprintf("TransferDirection(%d)", int32(d))
+}
+
+// Transfer is a single file to upload or download.
+type Transfer struct {
+	Direction  TransferDirection
+	LocalPath  string
+	RemotePath string
+}
+
+// TransferProgress is passed to TransferOptions.OnProgress while a file is
+// transferred.
+type TransferProgress struct {
+	Transfer
+	// bytes of the file transferred so far, including resumed ones
+	Bytes int64
+	// size of the file, -1 if not known yet
+	Total   int64
+	Attempt int
+	Done    bool
+}
+
+func (p TransferProgress) String() string {
+	name := p.RemotePath
+	if p.Total <= 0 {
+		return fmt.Sprintf("%v %v: %v", p.Direction, name, formatBytes(p.Bytes))
+	}
+	return fmt.Sprintf("%v %v: %v / %v (%.1f%%)", p.Direction, name, formatBytes(p.Bytes), formatBytes(p.Total), float64(p.Bytes)*100/float64(p.Total))
+}
+
+// TransferOptions configures Transfer. The zero value is usable.
+type TransferOptions struct {
+	// number of files transferred at the same time, defaults to 4
+	Concurrency int
+	// retries of a failed file, defaults to 3. Use a negative value to
+	// disable retries. Downloads continue where the failed attempt stopped.
+	Retries int
+	// delay before the first retry, doubled for every further one.
+	// Defaults to one second.
+	RetryDelay time.Duration
+	// called from the goroutine transferring the file, at most every
+	// ProgressInterval and once when the file is done
+	OnProgress func(TransferProgress)
+	// defaults to 500ms
+	ProgressInterval time.Duration
+	// don't compare the checksum of the file on both sides after the
+	// transfer
+	SkipVerify bool
+}
+
+// TransferResult is the outcome of a single Transfer. Resumed is the number
+// of bytes of a download that were already present locally from an earlier
+// run.
+type TransferResult struct {
+	Transfer
+	Bytes    int64
+	Resumed  int64
+	Attempts int
+	Err      error
+}
+
+// TransferReport summarizes a Transfer run.
+type TransferReport struct {
+	Results   []TransferResult
+	Succeeded int
+	Failed    int
+	Bytes     int64
+}
+
+// Failures returns the results of all files that could not be transferred.
+func (r *TransferReport) Failures() []TransferResult {
+	failed := []TransferResult{}
+	for _, res := range r.Results {
+		if res.Err != nil {
+			failed = append(failed, res)
+		}
+	}
+	return failed
+}
+
+func (r *TransferReport) String() string {
+	return fmt.Sprintf("%d files, %d transferred, %d failed, %v", len(r.Results), r.Succeeded, r.Failed, formatBytes(r.Bytes))
+}
+
+// errChecksumMismatch is returned if the verification after a transfer
+// fails. The transfer is retried from the start.
+var errChecksumMismatch = errors.New("checksum mismatch after transfer")
+
+// partialSuffix is appended to the local path of unfinished downloads.
+const partialSuffix = ".part"
+
+// Transfer uploads and downloads files in parallel. Failed files are retried,
+// and both uploads and downloads are verified with the SHA256 checksum
+// reported by the Edge Storage API afterwards.
+//
+// Downloads are written to LocalPath with a ".part" suffix first and renamed
+// when complete. If a partial download exists, from a failed attempt or an
+// earlier run, only the missing rest is requested.
+//
+// Every transfer gets a result in the report, in the given order. An error is
+// only returned if the context was cancelled.
+func (s *StorageClient) Transfer(ctx context.Context, transfers []Transfer, opts *TransferOptions) (*TransferReport, error) {
+	o := TransferOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.Concurrency <= 0 {
+		o.Concurrency = 4
+	}
+	if o.Retries == 0 {
+		o.Retries = 3
+	} else if o.Retries < 0 {
+		o.Retries = 0
+	}
+	if o.RetryDelay <= 0 {
+		o.RetryDelay = time.Second
+	}
+	if o.ProgressInterval <= 0 {
+		o.ProgressInterval = 500 * time.Millisecond
+	}
+
+	report := &TransferReport{Results: make([]TransferResult, len(transfers))}
+	for i, t := range transfers {
+		report.Results[i].Transfer = t
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < o.Concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				s.transferWithRetry(ctx, &report.Results[i], o)
+			}
+		}()
+	}
+
+	var ctxErr error
+	for i := range report.Results {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			ctxErr = ctx.Err()
+		}
+		if ctxErr != nil {
+			for j := i; j < len(report.Results); j++ {
+				report.Results[j].Err = ctxErr
+			}
+			break
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, res := range report.Results {
+		if res.Err != nil {
+			report.Failed++
+		} else {
+			report.Succeeded++
+			report.Bytes += res.Bytes
+		}
+	}
+
+	return report, ctxErr
+}
+
+func (s *StorageClient) transferWithRetry(ctx context.Context, res *TransferResult, o TransferOptions) {
+	delay := o.RetryDelay
+	for {
+		res.Attempts++
+		var transient bool
+		if res.Direction == TDDownload {
+			transient, res.Err = s.download(ctx, res, o)
+		} else {
+			transient, res.Err = s.upload(ctx, res, o)
+		}
+		if res.Err == nil || res.Attempts > o.Retries || !transient || ctx.Err() != nil {
+			return
+		}
+
+		t := time.NewTimer(delay)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			res.Err = ctx.Err()
+			return
+		}
+		delay *= 2
+	}
+}
+
+// upload makes a single attempt to upload a file. The returned bool reports
+// whether the error might go away when retried.
+func (s *StorageClient) upload(ctx context.Context, res *TransferResult, o TransferOptions) (bool, error) {
+	f, err := os.Open(res.LocalPath)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	sum, err := SHA256Checksum(f)
+	if err != nil {
+		return false, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+
+	pr := &progressReader{r: f, res: res, total: info.Size(), o: o}
+	err = s.PutFile(ctx, res.RemotePath, pr, &PutFileOptions{Checksum: sum, ContentLength: info.Size()})
+	if err != nil {
+		return isTransientStorageError(err), err
+	}
+	res.Bytes = pr.n
+
+	if !o.SkipVerify {
+		obj, err := s.Stat(ctx, res.RemotePath)
+		if err != nil {
+			return isTransientStorageError(err), err
+		}
+		if obj.Length != info.Size() || (obj.Checksum != "" && !strings.EqualFold(obj.Checksum, sum)) {
+			return true, errChecksumMismatch
+		}
+	}
+	pr.finish()
+	return false, nil
+}
+
+// download makes a single attempt to download a file, continuing a partial
+// download if there is one. The returned bool reports whether the error might
+// go away when retried.
+func (s *StorageClient) download(ctx context.Context, res *TransferResult, o TransferOptions) (bool, error) {
+	obj, err := s.Stat(ctx, res.RemotePath)
+	if err != nil {
+		return isTransientStorageError(err), err
+	}
+	if obj.IsDirectory {
+		return false, fmt.Errorf("%v is a directory", res.RemotePath)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(res.LocalPath), 0755); err != nil {
+		return false, err
+	}
+	part := res.LocalPath + partialSuffix
+	f, err := os.OpenFile(part, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return false, err
+	}
+	transient, err := s.downloadTo(ctx, f, res, obj, o)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return transient, err
+	}
+	return false, os.Rename(part, res.LocalPath)
+}
+
+func (s *StorageClient) downloadTo(ctx context.Context, f *os.File, res *TransferResult, obj *StorageObject, o TransferOptions) (bool, error) {
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return false, err
+	}
+	if offset > obj.Length {
+		// the remote file changed since, start over
+		if err := f.Truncate(0); err != nil {
+			return false, err
+		}
+		offset = 0
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return false, err
+		}
+	}
+	if res.Attempts == 1 {
+		res.Resumed = offset
+	}
+
+	pr := &progressReader{res: res, n: offset, total: obj.Length, o: o}
+	if offset < obj.Length {
+		sf, err := s.GetFileRange(ctx, res.RemotePath, offset, -1)
+		if err != nil {
+			return isTransientStorageError(err), err
+		}
+		pr.r = sf
+		n, err := io.Copy(f, pr)
+		sf.Close()
+		res.Bytes += n
+		if err != nil {
+			// the partial file is kept to continue from
+			return ctx.Err() == nil, err
+		}
+	}
+
+	if !o.SkipVerify && obj.Checksum != "" {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return false, err
+		}
+		sum, err := SHA256Checksum(f)
+		if err != nil {
+			return false, err
+		}
+		if !strings.EqualFold(sum, obj.Checksum) {
+			// the partial file can't be trusted, start over
+			if err := f.Truncate(0); err != nil {
+				return false, err
+			}
+			return true, errChecksumMismatch
+		}
+	}
+	pr.finish()
+	return false, nil
+}
+
+// progressReader counts the bytes read through it and reports them to
+// TransferOptions.OnProgress. n starts at the offset the transfer resumed
+// from.
+type progressReader struct {
+	r     io.Reader
+	res   *TransferResult
+	n     int64
+	total int64
+	o     TransferOptions
+	last  time.Time
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.n += int64(n)
+	if p.o.OnProgress != nil && time.Since(p.last) >= p.o.ProgressInterval {
+		p.last = time.Now()
+		p.o.OnProgress(TransferProgress{p.res.Transfer, p.n, p.total, p.res.Attempts, false})
+	}
+	return n, err
+}
+
+func (p *progressReader) finish() {
+	if p.o.OnProgress != nil {
+		p.o.OnProgress(TransferProgress{p.res.Transfer, p.n, p.total, p.res.Attempts, true})
+	}
+}
+
+// isTransientStorageError reports whether a failed Edge Storage request might
+// succeed when retried.
+func isTransientStorageError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var se *StorageErrorResponse
+	if errors.As(err, &se) {
+		return se.HTTPCode == http.StatusTooManyRequests || se.HTTPCode >= 500
+	}
+	// network errors
+	var ue *url.Error
+	return errors.As(err, &ue)
+}
+
+// formatBytes formats a byte count with a binary unit, like 1.5 GiB.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
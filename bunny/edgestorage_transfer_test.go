@@ -0,0 +1,149 @@
+// Copyright (c) 2021 Jan Koppe
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package bunny
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStorageTransfer(t *testing.T) {
+	s, storage := newFakeStorage(t)
+	ctx := context.Background()
+
+	// the first download of big.bin breaks off halfway
+	var mu sync.Mutex
+	broken := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		breakOff := !broken && r.Method == "GET" && r.URL.Path == "/testzone/big.bin"
+		broken = broken || breakOff
+		mu.Unlock()
+		if breakOff {
+			data, _ := storage.get("big.bin")
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+			_, _ = w.Write([]byte(data[:len(data)/2]))
+			return
+		}
+		storage.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	s.BaseURL, _ = url.Parse(srv.URL)
+
+	dir, err := ioutil.TempDir("", "bunny-transfer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	big := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+	if err := ioutil.WriteFile(filepath.Join(dir, "big.bin"), big, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "small.txt"), []byte("small"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	done := map[string]TransferProgress{}
+	opts := &TransferOptions{
+		Concurrency: 2,
+		RetryDelay:  time.Millisecond,
+		OnProgress: func(p TransferProgress) {
+			mu.Lock()
+			defer mu.Unlock()
+			if p.Done {
+				done[p.RemotePath] = p
+			}
+		},
+	}
+	report, err := s.Transfer(ctx, []Transfer{
+		{TDUpload, filepath.Join(dir, "big.bin"), "big.bin"},
+		{TDUpload, filepath.Join(dir, "small.txt"), "docs/small.txt"},
+	}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Succeeded != 2 || report.Bytes != int64(len(big)+5) {
+		t.Fatalf("unexpected upload report %v %+v", report, report.Failures())
+	}
+	if got, _ := storage.get("docs/small.txt"); got != "small" {
+		t.Errorf("unexpected uploaded content %q", got)
+	}
+	if p := done["big.bin"]; p.Bytes != int64(len(big)) || p.Total != int64(len(big)) {
+		t.Errorf("unexpected final progress %v", p)
+	}
+
+	// a partial download from an earlier run is continued
+	target := filepath.Join(dir, "down", "big.bin")
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(target+partialSuffix, big[:10000], 0644); err != nil {
+		t.Fatal(err)
+	}
+	report, err = s.Transfer(ctx, []Transfer{
+		{TDDownload, target, "big.bin"},
+		{TDDownload, filepath.Join(dir, "down", "missing.txt"), "missing.txt"},
+	}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Succeeded != 1 || report.Failed != 1 {
+		t.Fatalf("unexpected download report %v", report)
+	}
+	res := report.Results[0]
+	if res.Err != nil || res.Resumed != 10000 || res.Attempts != 2 {
+		t.Errorf("unexpected download result %+v", res)
+	}
+	if data, _ := ioutil.ReadFile(target); !bytes.Equal(data, big) {
+		t.Error("downloaded content differs")
+	}
+	if _, err := os.Stat(target + partialSuffix); !os.IsNotExist(err) {
+		t.Error("partial file was not renamed")
+	}
+	if res := report.Results[1]; !errors.Is(res.Err, os.ErrNotExist) || res.Attempts != 1 {
+		t.Errorf("missing files shouldn't be retried: %+v", res)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	for n, want := range map[int64]string{
+		0:               "0 B",
+		1023:            "1023 B",
+		1536:            "1.5 KiB",
+		5 * 1024 * 1024: "5.0 MiB",
+		3 << 30:         "3.0 GiB",
+	} {
+		if got := formatBytes(n); got != want {
+			t.Errorf("formatBytes(%d) = %v, want %v", n, got, want)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+// Copyright (c) 2021 Jan Koppe
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package bunny
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// StorageUsageOptions configures AnalyzeStorage. The zero value is usable.
+type StorageUsageOptions struct {
+	// number of directory levels usage is grouped by, defaults to 1
+	Depth int
+	// number of largest files to report, defaults to 10
+	Largest int
+	// upper bounds of the age groups, by time since the last change.
+	// Defaults to a day, a week, 30 days and a year.
+	AgeBuckets []time.Duration
+}
+
+// StorageUsageEntry is the usage of one group of files.
+type StorageUsageEntry struct {
+	Key   string
+	Files int64
+	Bytes int64
+}
+
+// StorageUsageFile is a single file in a usage report.
+type StorageUsageFile struct {
+	Path        string
+	Size        int64
+	LastChanged time.Time
+}
+
+// StorageDuplicate is a set of files with the same content.
+type StorageDuplicate struct {
+	Checksum string
+	Size     int64
+	Paths    []string
+}
+
+// Wasted returns the bytes that could be saved by keeping only one copy.
+func (d StorageDuplicate) Wasted() int64 {
+	return d.Size * int64(len(d.Paths)-1)
+}
+
+// StorageUsage is the result of AnalyzeStorage. All paths are relative to
+// Prefix. Usage by directory and extension is sorted by size, largest first,
+// usage by age from new to old.
+type StorageUsage struct {
+	Prefix      string
+	Files       int64
+	Bytes       int64
+	ByDirectory []StorageUsageEntry
+	ByExtension []StorageUsageEntry
+	ByAge       []StorageUsageEntry
+	Largest     []StorageUsageFile
+	// sorted by wasted bytes, most first
+	Duplicates []StorageDuplicate
+}
+
+// AnalyzeStorage walks all files below prefix and summarizes where the
+// storage is used. Duplicates are found by the checksums reported by the
+// Edge Storage API, files without one are never reported as duplicates.
+func (s *StorageClient) AnalyzeStorage(ctx context.Context, prefix string, opts *StorageUsageOptions) (*StorageUsage, error) {
+	o := StorageUsageOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.Depth <= 0 {
+		o.Depth = 1
+	}
+	if o.Largest <= 0 {
+		o.Largest = 10
+	}
+	if len(o.AgeBuckets) == 0 {
+		o.AgeBuckets = []time.Duration{24 * time.Hour, 7 * 24 * time.Hour, 30 * 24 * time.Hour, 365 * 24 * time.Hour}
+	}
+	sort.Slice(o.AgeBuckets, func(i, j int) bool { return o.AgeBuckets[i] < o.AgeBuckets[j] })
+
+	prefix, err := cleanStoragePath(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	u := &StorageUsage{Prefix: prefix}
+	dirs := map[string]*StorageUsageEntry{}
+	exts := map[string]*StorageUsageEntry{}
+	u.ByAge = make([]StorageUsageEntry, len(o.AgeBuckets)+1)
+	for i := range u.ByAge {
+		u.ByAge[i].Key = ageBucketName(o.AgeBuckets, i)
+	}
+	files := []StorageUsageFile{}
+	sums := map[string]*StorageDuplicate{}
+	now := time.Now()
+
+	err = s.WalkDirectory(ctx, prefix, func(obj StorageObject) error {
+		if obj.IsDirectory {
+			return nil
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(obj.RelativePath(), prefix), "/")
+		u.Files++
+		u.Bytes += obj.Length
+
+		addUsage(dirs, usageDirectory(rel, o.Depth), obj.Length)
+		ext := strings.ToLower(path.Ext(rel))
+		if ext == "" {
+			ext = "(none)"
+		}
+		addUsage(exts, ext, obj.Length)
+
+		age := now.Sub(obj.LastChanged.Time)
+		i := sort.Search(len(o.AgeBuckets), func(i int) bool { return age < o.AgeBuckets[i] })
+		u.ByAge[i].Files++
+		u.ByAge[i].Bytes += obj.Length
+
+		files = append(files, StorageUsageFile{rel, obj.Length, obj.LastChanged.Time})
+
+		if obj.Checksum != "" {
+			sum := strings.ToUpper(obj.Checksum)
+			if d, ok := sums[sum]; ok {
+				d.Paths = append(d.Paths, rel)
+			} else {
+				sums[sum] = &StorageDuplicate{sum, obj.Length, []string{rel}}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	u.ByDirectory = sortedUsage(dirs)
+	u.ByExtension = sortedUsage(exts)
+
+	sort.SliceStable(files, func(i, j int) bool { return files[i].Size > files[j].Size })
+	if len(files) > o.Largest {
+		files = files[:o.Largest]
+	}
+	u.Largest = files
+
+	u.Duplicates = []StorageDuplicate{}
+	for _, d := range sums {
+		if len(d.Paths) > 1 {
+			sort.Strings(d.Paths)
+			u.Duplicates = append(u.Duplicates, *d)
+		}
+	}
+	sort.Slice(u.Duplicates, func(i, j int) bool {
+		a, b := u.Duplicates[i], u.Duplicates[j]
+		if a.Wasted() != b.Wasted() {
+			return a.Wasted() > b.Wasted()
+		}
+		return a.Paths[0] < b.Paths[0]
+	})
+
+	return u, nil
+}
+
+// usageDirectory returns the first depth directories of rel, with a
+// trailing slash, or "/" for files at the top level.
+func usageDirectory(rel string, depth int) string {
+	parts := strings.Split(rel, "/")
+	parts = parts[:len(parts)-1]
+	if len(parts) == 0 {
+		return "/"
+	}
+	if len(parts) > depth {
+		parts = parts[:depth]
+	}
+	return strings.Join(parts, "/") + "/"
+}
+
+func ageBucketName(buckets []time.Duration, i int) string {
+	switch {
+	case i == 0:
+		return "< " + formatAge(buckets[0])
+	case i == len(buckets):
+		return ">= " + formatAge(buckets[i-1])
+	}
+	return formatAge(buckets[i-1]) + " - " + formatAge(buckets[i])
+}
+
+// formatAge formats whole days as "7d" and everything else like
+// time.Duration.
+func formatAge(d time.Duration) string {
+	if d >= 24*time.Hour && d%(24*time.Hour) == 0 {
+		return strconv.FormatInt(int64(d/(24*time.Hour)), 10) + "d"
+	}
+	return d.String()
+}
+
+func addUsage(m map[string]*StorageUsageEntry, key string, size int64) {
+	e, ok := m[key]
+	if !ok {
+		e = &StorageUsageEntry{Key: key}
+		m[key] = e
+	}
+	e.Files++
+	e.Bytes += size
+}
+
+func sortedUsage(m map[string]*StorageUsageEntry) []StorageUsageEntry {
+	entries := make([]StorageUsageEntry, 0, len(m))
+	for _, e := range m {
+		entries = append(entries, *e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Bytes != entries[j].Bytes {
+			return entries[i].Bytes > entries[j].Bytes
+		}
+		return entries[i].Key < entries[j].Key
+	})
+	return entries
+}
+
+// WriteJSON writes the usage as indented JSON.
+func (u *StorageUsage) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(u)
+}
+
+// WriteCSV writes the usage as CSV with the columns section, key, files,
+// bytes and detail. Largest files have their last change as detail,
+// duplicates their paths separated by spaces.
+func (u *StorageUsage) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"section", "key", "files", "bytes", "detail"})
+	row := func(section string, key string, files int64, bytes int64, detail string) {
+		_ = cw.Write([]string{section, key, strconv.FormatInt(files, 10), strconv.FormatInt(bytes, 10), detail})
+	}
+
+	row("total", u.Prefix, u.Files, u.Bytes, "")
+	for _, e := range u.ByDirectory {
+		row("directory", e.Key, e.Files, e.Bytes, "")
+	}
+	for _, e := range u.ByExtension {
+		row("extension", e.Key, e.Files, e.Bytes, "")
+	}
+	for _, e := range u.ByAge {
+		row("age", e.Key, e.Files, e.Bytes, "")
+	}
+	for _, f := range u.Largest {
+		row("largest", f.Path, 1, f.Size, f.LastChanged.Format(time.RFC3339))
+	}
+	for _, d := range u.Duplicates {
+		row("duplicate", d.Checksum, int64(len(d.Paths)), d.Wasted(), strings.Join(d.Paths, " "))
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteTable writes the usage as human readable, aligned tables.
+func (u *StorageUsage) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	root := u.Prefix
+	if root == "" {
+		root = "/"
+	}
+	fmt.Fprintf(tw, "%v:\t%d files\t%v\n", root, u.Files, formatBytes(u.Bytes))
+
+	section := func(title string, entries []StorageUsageEntry) {
+		fmt.Fprintf(tw, "\n%v\tfiles\tsize\n", title)
+		for _, e := range entries {
+			fmt.Fprintf(tw, "%v\t%d\t%v\n", e.Key, e.Files, formatBytes(e.Bytes))
+		}
+	}
+	section("directory", u.ByDirectory)
+	section("extension", u.ByExtension)
+	section("age", u.ByAge)
+
+	fmt.Fprintf(tw, "\nlargest files\tsize\tlast changed\n")
+	for _, f := range u.Largest {
+		fmt.Fprintf(tw, "%v\t%v\t%v\n", f.Path, formatBytes(f.Size), f.LastChanged.Format("2006-01-02"))
+	}
+
+	fmt.Fprintf(tw, "\nduplicates\tcopies\twasted\n")
+	for _, d := range u.Duplicates {
+		fmt.Fprintf(tw, "%v\t%d\t%v\n", strings.Join(d.Paths, " "), len(d.Paths), formatBytes(d.Wasted()))
+	}
+
+	return tw.Flush()
+}
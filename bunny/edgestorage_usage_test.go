@@ -0,0 +1,99 @@
+// Copyright (c) 2021 Jan Koppe
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package bunny
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAnalyzeStorage(t *testing.T) {
+	s, storage := newFakeStorage(t)
+	storage.put("img/a.JPG", "0123456789")
+	storage.put("img/2021/b.jpg", "0123456789")
+	storage.put("img/2021/c.png", "png")
+	storage.put("docs/readme", "hello")
+	storage.put("index.html", "<h1>home</h1>")
+	storage.mu.Lock()
+	f := storage.files["docs/readme"]
+	f.modified = time.Now().Add(-48 * time.Hour)
+	storage.files["docs/readme"] = f
+	storage.mu.Unlock()
+
+	u, err := s.AnalyzeStorage(context.Background(), "/", &StorageUsageOptions{Largest: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Files != 5 || u.Bytes != 41 {
+		t.Errorf("unexpected totals %d files, %d bytes", u.Files, u.Bytes)
+	}
+	wantDirs := []StorageUsageEntry{{"img/", 3, 23}, {"/", 1, 13}, {"docs/", 1, 5}}
+	if !reflect.DeepEqual(u.ByDirectory, wantDirs) {
+		t.Errorf("got directories %+v, want %+v", u.ByDirectory, wantDirs)
+	}
+	wantExts := []StorageUsageEntry{{".jpg", 2, 20}, {".html", 1, 13}, {"(none)", 1, 5}, {".png", 1, 3}}
+	if !reflect.DeepEqual(u.ByExtension, wantExts) {
+		t.Errorf("got extensions %+v, want %+v", u.ByExtension, wantExts)
+	}
+	if u.ByAge[0].Key != "< 1d" || u.ByAge[0].Files != 4 || u.ByAge[1].Key != "1d - 7d" || u.ByAge[1].Files != 1 {
+		t.Errorf("unexpected ages %+v", u.ByAge)
+	}
+	if len(u.Largest) != 2 || u.Largest[0].Path != "index.html" {
+		t.Errorf("unexpected largest files %+v", u.Largest)
+	}
+	if len(u.Duplicates) != 1 || !reflect.DeepEqual(u.Duplicates[0].Paths, []string{"img/2021/b.jpg", "img/a.JPG"}) || u.Duplicates[0].Wasted() != 10 {
+		t.Errorf("unexpected duplicates %+v", u.Duplicates)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := u.WriteCSV(buf); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1+1+3+4+5+2+1 || rows[len(rows)-1][0] != "duplicate" {
+		t.Errorf("unexpected csv %v", rows)
+	}
+
+	buf.Reset()
+	if err := u.WriteJSON(buf); err != nil {
+		t.Fatal(err)
+	}
+	decoded := StorageUsage{}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil || decoded.Bytes != 41 {
+		t.Errorf("unexpected json %v", buf.String())
+	}
+
+	buf.Reset()
+	if err := u.WriteTable(buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "img/2021/b.jpg img/a.JPG  2       10 B") {
+		t.Errorf("unexpected table\n%v", buf.String())
+	}
+}
@@ -0,0 +1,414 @@
+// Copyright (c) 2021 Jan Koppe
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package bunny
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// WebDAVOptions configures WebDAVHandler. The zero value is usable.
+type WebDAVOptions struct {
+	// path prefix the handler is mounted at, removed from request paths
+	Prefix string
+	// if both are set, the URLs of changed files on all hostnames of the
+	// PullZone are added to the queue after every write
+	PullZone *PullZone
+	Purge    *PurgeQueue
+}
+
+// WebDAVHandler serves the StorageZone over WebDAV, so it can be mounted as
+// a network drive. It supports OPTIONS, PROPFIND with a depth of 0 or 1,
+// GET, HEAD, PUT, DELETE, MKCOL, COPY and MOVE. The Edge Storage API has no
+// copy or move, so both download and upload again, and MOVE deletes the
+// source afterwards.
+//
+// LOCK and UNLOCK are accepted so clients like Finder mount the drive
+// writable, but locks are not enforced. Properties can't be changed.
+func (s *StorageClient) WebDAVHandler(opts *WebDAVOptions) http.Handler {
+	o := WebDAVOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	o.Prefix = "/" + strings.Trim(o.Prefix, "/")
+	if o.Prefix == "/" {
+		o.Prefix = ""
+	}
+	return &webDAVHandler{s, o}
+}
+
+type webDAVHandler struct {
+	storage *StorageClient
+	o       WebDAVOptions
+}
+
+// storagePath maps a request path to a path in the StorageZone.
+func (h *webDAVHandler) storagePath(p string) (string, bool) {
+	if !strings.HasPrefix(p, h.o.Prefix+"/") && p != h.o.Prefix {
+		return "", false
+	}
+	clean, err := cleanStoragePath(strings.TrimPrefix(p, h.o.Prefix))
+	return clean, err == nil
+}
+
+// href is the URL path of a file or directory in responses.
+func (h *webDAVHandler) href(p string, dir bool) string {
+	u := &url.URL{Path: h.o.Prefix + "/" + p}
+	if dir && p != "" {
+		u.Path += "/"
+	}
+	return u.EscapedPath()
+}
+
+func (h *webDAVHandler) purge(p string, dir bool) {
+	if h.o.Purge == nil || h.o.PullZone == nil {
+		return
+	}
+	escaped := (&url.URL{Path: "/" + p}).EscapedPath()
+	if dir {
+		escaped = strings.TrimSuffix(escaped, "/") + "/*"
+	}
+	_ = h.o.Purge.Add(PullZoneURLs(h.o.PullZone, escaped)...)
+}
+
+func webDAVError(w http.ResponseWriter, err error) {
+	var se *StorageErrorResponse
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		http.Error(w, "Not Found", http.StatusNotFound)
+	case errors.As(err, &se) && se.HTTPCode < 500:
+		http.Error(w, se.Message, se.HTTPCode)
+	default:
+		http.Error(w, err.Error(), http.StatusBadGateway)
+	}
+}
+
+func (h *webDAVHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p, ok := h.storagePath(r.URL.Path)
+	if !ok {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case "OPTIONS":
+		w.Header().Set("DAV", "1, 2")
+		w.Header().Set("MS-Author-Via", "DAV")
+		w.Header().Set("Allow", "OPTIONS, PROPFIND, GET, HEAD, PUT, DELETE, MKCOL, COPY, MOVE, LOCK, UNLOCK")
+	case "PROPFIND":
+		h.propfind(w, r, p)
+	case "GET", "HEAD":
+		h.get(w, r, p)
+	case "PUT":
+		h.put(w, r, p)
+	case "DELETE":
+		h.delete(w, r, p)
+	case "MKCOL":
+		h.mkcol(w, r, p)
+	case "COPY", "MOVE":
+		h.copy(w, r, p, r.Method == "MOVE")
+	case "LOCK":
+		h.lock(w, r)
+	case "UNLOCK":
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *webDAVHandler) propfind(w http.ResponseWriter, r *http.Request, p string) {
+	fsys := h.storage.FS(r.Context())
+	fi, err := fsys.stat(p)
+	if err != nil {
+		webDAVError(w, err)
+		return
+	}
+	infos := []*storageFileInfo{fi}
+	if fi.IsDir() && r.Header.Get("Depth") != "0" {
+		objects, err := h.storage.ListDirectory(r.Context(), p)
+		if err != nil {
+			webDAVError(w, err)
+			return
+		}
+		for _, o := range objects {
+			infos = append(infos, &storageFileInfo{o.ObjectName, o})
+		}
+	}
+
+	b := &strings.Builder{}
+	b.WriteString(xml.Header + `<D:multistatus xmlns:D="DAV:">`)
+	for i, fi := range infos {
+		fp := p
+		if i > 0 {
+			fp = path.Join(p, fi.name)
+		}
+		b.WriteString("<D:response><D:href>" + xmlEscape(h.href(fp, fi.IsDir())) + "</D:href><D:propstat><D:prop>")
+		b.WriteString("<D:displayname>" + xmlEscape(path.Base("/"+fp)) + "</D:displayname>")
+		if fi.IsDir() {
+			b.WriteString("<D:resourcetype><D:collection/></D:resourcetype>")
+		} else {
+			b.WriteString("<D:resourcetype/>")
+			b.WriteString("<D:getcontentlength>" + strconv.FormatInt(fi.Size(), 10) + "</D:getcontentlength>")
+			if ct := fi.obj.ContentType; ct != "" {
+				b.WriteString("<D:getcontenttype>" + xmlEscape(ct) + "</D:getcontenttype>")
+			}
+			if fi.obj.Checksum != "" {
+				b.WriteString(`<D:getetag>"` + strings.ToLower(fi.obj.Checksum) + `"</D:getetag>`)
+			}
+		}
+		if !fi.ModTime().IsZero() {
+			b.WriteString("<D:getlastmodified>" + fi.ModTime().UTC().Format(http.TimeFormat) + "</D:getlastmodified>")
+		}
+		b.WriteString("</D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response>")
+	}
+	b.WriteString("</D:multistatus>")
+
+	w.Header().Set("Content-Type", `application/xml; charset="utf-8"`)
+	w.WriteHeader(http.StatusMultiStatus)
+	_, _ = io.WriteString(w, b.String())
+}
+
+func xmlEscape(s string) string {
+	b := &strings.Builder{}
+	_ = xml.EscapeText(b, []byte(s))
+	return b.String()
+}
+
+func (h *webDAVHandler) get(w http.ResponseWriter, r *http.Request, p string) {
+	f, err := h.storage.FS(r.Context()).open("open", p)
+	if err != nil {
+		webDAVError(w, err)
+		return
+	}
+	defer f.Close()
+	if f.info.IsDir() {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if ct := f.info.obj.ContentType; ct != "" {
+		w.Header().Set("Content-Type", ct)
+	}
+	if f.info.obj.Checksum != "" {
+		w.Header().Set("ETag", `"`+strings.ToLower(f.info.obj.Checksum)+`"`)
+	}
+	http.ServeContent(w, r, f.info.name, f.info.ModTime(), f)
+}
+
+func (h *webDAVHandler) put(w http.ResponseWriter, r *http.Request, p string) {
+	if p == "" || strings.HasSuffix(r.URL.Path, "/") {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	_, err := h.storage.Stat(r.Context(), p)
+	created := errors.Is(err, os.ErrNotExist)
+
+	err = h.storage.PutFile(r.Context(), p, r.Body, &PutFileOptions{ContentLength: r.ContentLength, ContentType: r.Header.Get("Content-Type")})
+	if err != nil {
+		webDAVError(w, err)
+		return
+	}
+	h.purge(p, false)
+	if created {
+		w.WriteHeader(http.StatusCreated)
+	} else {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (h *webDAVHandler) delete(w http.ResponseWriter, r *http.Request, p string) {
+	if p == "" {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	obj, err := h.storage.Stat(r.Context(), p)
+	if err == nil {
+		if obj.IsDirectory {
+			err = h.storage.DeleteDirectory(r.Context(), p)
+		} else {
+			err = h.storage.DeleteFile(r.Context(), p)
+		}
+	}
+	if err != nil {
+		webDAVError(w, err)
+		return
+	}
+	h.purge(p, obj.IsDirectory)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *webDAVHandler) mkcol(w http.ResponseWriter, r *http.Request, p string) {
+	if r.ContentLength > 0 {
+		http.Error(w, "Unsupported Media Type", http.StatusUnsupportedMediaType)
+		return
+	}
+	if p == "" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, err := h.storage.Stat(r.Context(), p); err == nil {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := h.storage.CreateDirectory(r.Context(), p); err != nil {
+		webDAVError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (h *webDAVHandler) copy(w http.ResponseWriter, r *http.Request, p string, move bool) {
+	dest, err := url.Parse(r.Header.Get("Destination"))
+	if err != nil || dest.Path == "" {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	destPath, ok := h.storagePath(dest.Path)
+	if !ok || p == "" || destPath == "" || destPath == p || strings.HasPrefix(destPath+"/", p+"/") {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	src, err := h.storage.Stat(r.Context(), p)
+	if err != nil {
+		webDAVError(w, err)
+		return
+	}
+	existing, err := h.storage.Stat(r.Context(), destPath)
+	exists := err == nil
+	if exists && r.Header.Get("Overwrite") == "F" {
+		http.Error(w, "Precondition Failed", http.StatusPreconditionFailed)
+		return
+	}
+
+	// the destination is only cleaned up after everything was copied, so a
+	// failed copy never loses it. Files at the same path are simply replaced
+	// by the upload.
+	var stale []StorageObject
+	if exists {
+		stale, err = h.storageFiles(r, existing)
+		if err != nil {
+			webDAVError(w, err)
+			return
+		}
+	}
+	files, err := h.storageFiles(r, src)
+	if err == nil && src.IsDirectory && len(files) == 0 {
+		err = h.storage.CreateDirectory(r.Context(), destPath)
+	}
+	copied := map[string]bool{}
+	for _, obj := range files {
+		if err != nil {
+			break
+		}
+		target := destPath + strings.TrimPrefix(obj.RelativePath(), p)
+		err = copyStorageFile(r, h.storage, obj.RelativePath(), target, obj)
+		copied[target] = true
+	}
+	for _, obj := range stale {
+		if err != nil {
+			break
+		}
+		if !copied[obj.RelativePath()] {
+			err = h.storage.DeleteFile(r.Context(), obj.RelativePath())
+		}
+	}
+	if err == nil && move {
+		if src.IsDirectory {
+			err = h.storage.DeleteDirectory(r.Context(), p)
+		} else {
+			err = h.storage.DeleteFile(r.Context(), p)
+		}
+	}
+	if err != nil {
+		webDAVError(w, err)
+		return
+	}
+
+	h.purge(destPath, src.IsDirectory)
+	if move {
+		h.purge(p, src.IsDirectory)
+	}
+	if exists {
+		w.WriteHeader(http.StatusNoContent)
+	} else {
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+// storageFiles returns all files below a directory, or just the file itself.
+func (h *webDAVHandler) storageFiles(r *http.Request, obj *StorageObject) ([]StorageObject, error) {
+	if !obj.IsDirectory {
+		return []StorageObject{*obj}, nil
+	}
+	files := []StorageObject{}
+	err := h.storage.WalkDirectory(r.Context(), obj.RelativePath(), func(obj StorageObject) error {
+		if !obj.IsDirectory {
+			files = append(files, obj)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// copyStorageFile copies a file within the StorageZone by downloading and
+// uploading it again.
+func copyStorageFile(r *http.Request, s *StorageClient, from string, to string, obj StorageObject) error {
+	sf, err := s.GetFile(r.Context(), from)
+	if err != nil {
+		return err
+	}
+	defer sf.Close()
+	return s.PutFile(r.Context(), to, sf, &PutFileOptions{Checksum: obj.Checksum, ContentLength: obj.Length, ContentType: obj.ContentType})
+}
+
+// lock hands out lock tokens without enforcing them, which is enough for
+// clients that refuse to write without locking.
+func (h *webDAVHandler) lock(w http.ResponseWriter, r *http.Request) {
+	_, _ = io.Copy(ioutil.Discard, r.Body)
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	token := "opaquelocktoken:" + hex.EncodeToString(id)
+	timeout := r.Header.Get("Timeout")
+	if timeout == "" {
+		timeout = "Second-3600"
+	}
+
+	w.Header().Set("Content-Type", `application/xml; charset="utf-8"`)
+	w.Header().Set("Lock-Token", "<"+token+">")
+	fmt.Fprintf(w, xml.Header+`<D:prop xmlns:D="DAV:"><D:lockdiscovery><D:activelock>`+
+		`<D:locktype><D:write/></D:locktype><D:lockscope><D:exclusive/></D:lockscope>`+
+		`<D:depth>infinity</D:depth><D:timeout>%v</D:timeout>`+
+		`<D:locktoken><D:href>%v</D:href></D:locktoken>`+
+		`</D:activelock></D:lockdiscovery></D:prop>`, xmlEscape(timeout), token)
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2021 Jan Koppe
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package bunny
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebDAVHandler(t *testing.T) {
+	s, storage := newFakeStorage(t)
+	storage.put("docs/readme.txt", "read me")
+
+	apiSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer apiSrv.Close()
+	c, err := NewClient("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.BaseURL, _ = url.Parse(apiSrv.URL)
+	q := c.NewPurgeQueue(PurgeQueueOptions{FlushInterval: time.Hour})
+	defer q.Close(context.Background())
+	pz := &PullZone{Name: "files"}
+
+	srv := httptest.NewServer(s.WebDAVHandler(&WebDAVOptions{Prefix: "/dav/", PullZone: pz, Purge: q}))
+	defer srv.Close()
+
+	do := func(method string, p string, body string, header http.Header) (*http.Response, string) {
+		r, _ := http.NewRequest(method, srv.URL+p, strings.NewReader(body))
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp, string(data)
+	}
+
+	resp, body := do("PROPFIND", "/dav/", "", http.Header{"Depth": {"1"}})
+	if resp.StatusCode != http.StatusMultiStatus || !strings.Contains(body, "<D:href>/dav/docs/</D:href>") || !strings.Contains(body, "<D:collection/>") {
+		t.Errorf("unexpected root propfind %v %v", resp.Status, body)
+	}
+	resp, body = do("PROPFIND", "/dav/docs/readme.txt", "", http.Header{"Depth": {"0"}})
+	if resp.StatusCode != http.StatusMultiStatus || !strings.Contains(body, "<D:getcontentlength>7</D:getcontentlength>") {
+		t.Errorf("unexpected file propfind %v %v", resp.Status, body)
+	}
+
+	resp, _ = do("MKCOL", "/dav/new%20folder", "", nil)
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("unexpected mkcol response %v", resp.Status)
+	}
+	resp, body = do("PROPFIND", "/dav/", "", http.Header{"Depth": {"1"}})
+	if !strings.Contains(body, "<D:href>/dav/new%20folder/</D:href>") {
+		t.Errorf("created directory is not listed: %v", body)
+	}
+
+	resp, _ = do("PUT", "/dav/new%20folder/a.txt", "first", nil)
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("unexpected put response %v", resp.Status)
+	}
+	resp, _ = do("PUT", "/dav/new%20folder/a.txt", "second", nil)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("unexpected overwrite response %v", resp.Status)
+	}
+	resp, body = do("GET", "/dav/new%20folder/a.txt", "", nil)
+	if body != "second" {
+		t.Errorf("unexpected content %q", body)
+	}
+
+	resp, _ = do("MOVE", "/dav/new%20folder", "", http.Header{"Destination": {srv.URL + "/dav/moved"}})
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("unexpected move response %v", resp.Status)
+	}
+	if got, _ := storage.get("moved/a.txt"); got != "second" {
+		t.Errorf("directory was not moved, got %q", got)
+	}
+	if _, ok := storage.get("new folder/a.txt"); ok {
+		t.Error("source of the move was not deleted")
+	}
+
+	resp, _ = do("COPY", "/dav/docs/readme.txt", "", http.Header{"Destination": {"/dav/moved/a.txt"}, "Overwrite": {"F"}})
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("copy without overwrite should fail, got %v", resp.Status)
+	}
+
+	storage.put("backup/a.txt", "old")
+	storage.put("backup/stale.txt", "not in the source")
+	resp, _ = do("COPY", "/dav/moved", "", http.Header{"Destination": {"/dav/backup"}})
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("unexpected overwriting copy response %v", resp.Status)
+	}
+	if got, _ := storage.get("backup/a.txt"); got != "second" {
+		t.Errorf("existing file was not overwritten, got %q", got)
+	}
+	if _, ok := storage.get("backup/stale.txt"); ok {
+		t.Error("stale file in the destination was not deleted")
+	}
+
+	resp, _ = do("DELETE", "/dav/moved", "", nil)
+	if _, ok := storage.get("moved/a.txt"); ok || resp.StatusCode != http.StatusNoContent {
+		t.Errorf("directory was not deleted: %v", resp.Status)
+	}
+	resp, _ = do("GET", "/dav/moved/a.txt", "", nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 after delete, got %v", resp.Status)
+	}
+	resp, _ = do("GET", "/elsewhere", "", nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("paths outside of the prefix should not be served, got %v", resp.Status)
+	}
+
+	resp, body = do("LOCK", "/dav/docs/readme.txt", "", nil)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Lock-Token") == "" || !strings.Contains(body, "opaquelocktoken:") {
+		t.Errorf("unexpected lock response %v %v", resp.Status, body)
+	}
+
+	// the writes above queued purges of both sides of the move and the
+	// copy destination, which cover the single files
+	f, err := q.Flush(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "https://files.b-cdn.net/backup/* https://files.b-cdn.net/moved/* https://files.b-cdn.net/new%20folder/*"
+	if got := strings.Join(f.URLs, " "); got != want {
+		t.Errorf("got purges %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,382 @@
+// Copyright (c) 2021 Jan Koppe
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package bunny
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// StaticSiteOptions describes a static site served from a StorageZone
+// through a PullZone.
+type StaticSiteOptions struct {
+	// name of the StorageZone and PullZone, unless they are set separately
+	Name            string
+	StorageZoneName string
+	PullZoneName    string
+	// primary storage region, defaults to SRFalkenstein
+	Region StorageRegion
+	// added to an existing StorageZone as well. Replication regions can't
+	// be removed again, so they stay even if provisioning fails.
+	ReplicationRegions []StorageRegion
+	PullZoneType       PullZoneType
+	// custom hostnames, which need to point to the PullZone already for
+	// the free certificates to be issued
+	Hostnames []string
+	// redirect http to https on the custom hostnames
+	ForceSSL bool
+	// served instead of 404 errors, e.g. "/index.html" with
+	// Rewrite404To200 for single page apps
+	Custom404FilePath string
+	Rewrite404To200   bool
+	// how long to wait for certificates to be issued, defaults to 10
+	// minutes
+	CertificateTimeout time.Duration
+	// delay between checks of the certificates, defaults to 10 seconds
+	PollInterval time.Duration
+	// don't delete resources created by the call if a later step fails
+	KeepOnFailure bool
+}
+
+// StaticSite is a provisioned static site.
+type StaticSite struct {
+	StorageZone *StorageZone
+	PullZone    *PullZone
+	// whether the zones were created, or already existed
+	CreatedStorageZone bool
+	CreatedPullZone    bool
+}
+
+// URLs returns the https base URL of every hostname of the PullZone,
+// including the system hostname.
+func (s *StaticSite) URLs() []string {
+	urls := []string{}
+	for _, h := range s.PullZone.Hostnames {
+		urls = append(urls, "https://"+h.Value+"/")
+	}
+	return urls
+}
+
+// StorageClient returns a client for uploading the site to its StorageZone.
+func (s *StaticSite) StorageClient() (*StorageClient, error) {
+	return NewStorageClientForZone(s.StorageZone)
+}
+
+// ProvisionError is returned when a step of ProvisionStaticSite fails.
+type ProvisionError struct {
+	Step string
+	Err  error
+	// errors while deleting the resources created before the failure
+	RollbackErrors []error
+}
+
+func (e *ProvisionError) Error() string {
+	msg := fmt.Sprintf("provisioning failed to %v: %v", e.Step, e.Err)
+	if len(e.RollbackErrors) > 0 {
+		rbs := []string{}
+		for _, err := range e.RollbackErrors {
+			rbs = append(rbs, err.Error())
+		}
+		msg += fmt.Sprintf(" (rollback failed: %v)", strings.Join(rbs, "; "))
+	}
+	return msg
+}
+
+func (e *ProvisionError) Unwrap() error {
+	return e.Err
+}
+
+// ErrCertificateTimeout is returned, wrapped in a ProvisionError, when the
+// free certificates were not issued within StaticSiteOptions.CertificateTimeout.
+var ErrCertificateTimeout = errors.New("timed out waiting for certificates")
+
+// ProvisionStaticSite creates a StorageZone, a PullZone serving it, adds the
+// custom hostnames and loads free certificates for them. It can be called
+// again with the same options: zones and hostnames that exist already are
+// reused, so a failed or interrupted run can simply be repeated.
+//
+// If a step fails, everything created by this call is deleted again, unless
+// KeepOnFailure is set. Resources that existed before are not deleted, and
+// the 404 settings of an existing StorageZone are restored, but replication
+// regions added to it stay, as they can't be removed.
+func (c *Client) ProvisionStaticSite(ctx context.Context, opts StaticSiteOptions) (*StaticSite, error) {
+	o := opts
+	if o.StorageZoneName == "" {
+		o.StorageZoneName = o.Name
+	}
+	if o.PullZoneName == "" {
+		o.PullZoneName = o.Name
+	}
+	if o.StorageZoneName == "" || o.PullZoneName == "" {
+		return nil, errors.New("required site name not provided")
+	}
+	if o.Region == "" {
+		o.Region = SRFalkenstein
+	}
+	if o.CertificateTimeout <= 0 {
+		o.CertificateTimeout = 10 * time.Minute
+	}
+	if o.PollInterval <= 0 {
+		o.PollInterval = 10 * time.Second
+	}
+
+	p := &provisioner{c: c, opts: o, site: &StaticSite{}}
+	if err := p.run(ctx); err != nil {
+		if !o.KeepOnFailure {
+			err.RollbackErrors = p.rollback()
+		}
+		return nil, err
+	}
+	return p.site, nil
+}
+
+type provisioner struct {
+	c     *Client
+	opts  StaticSiteOptions
+	site  *StaticSite
+	added []string // hostnames added to an existing PullZone
+	// original 404 settings of an existing StorageZone, if they were changed
+	settings *StorageZoneUpdate
+}
+
+func (p *provisioner) run(ctx context.Context) *ProvisionError {
+	steps := []struct {
+		name string
+		fn   func(context.Context) error
+	}{
+		{"create storage zone", p.storageZone},
+		{"create pull zone", p.pullZone},
+		{"add hostnames", p.hostnames},
+		{"load certificates", p.certificates},
+		{"wait for certificates", p.waitForCertificates},
+		{"enable force ssl", p.forceSSL},
+	}
+	for _, s := range steps {
+		if err := ctx.Err(); err != nil {
+			return &ProvisionError{Step: s.name, Err: err}
+		}
+		if err := s.fn(ctx); err != nil {
+			return &ProvisionError{Step: s.name, Err: err}
+		}
+	}
+	return nil
+}
+
+func (p *provisioner) storageZone(ctx context.Context) error {
+	zones, err := p.c.ListStorageZones()
+	if err != nil {
+		return err
+	}
+	for _, sz := range *zones {
+		if sz.Name == p.opts.StorageZoneName && !sz.Deleted {
+			if sz.Region != p.opts.Region {
+				return fmt.Errorf("storage zone %v exists in region %v", sz.Name, sz.Region)
+			}
+			// fetch it, the list doesn't necessarily include the passwords
+			p.site.StorageZone, err = p.c.GetStorageZone(sz.ID)
+			if err != nil {
+				return err
+			}
+			if len(p.opts.ReplicationRegions) > 0 {
+				if err := p.c.AddStorageZoneReplicationRegions(sz.ID, p.opts.ReplicationRegions...); err != nil {
+					return err
+				}
+			}
+			return p.storageZoneSettings()
+		}
+	}
+
+	sz, err := p.c.AddStorageZone("", p.opts.StorageZoneName, p.opts.Region, p.opts.ReplicationRegions)
+	if err != nil {
+		return err
+	}
+	p.site.StorageZone = sz
+	p.site.CreatedStorageZone = true
+	return p.storageZoneSettings()
+}
+
+func (p *provisioner) storageZoneSettings() error {
+	sz := p.site.StorageZone
+	if p.opts.Custom404FilePath == "" || (sz.Custom404FilePath == p.opts.Custom404FilePath && sz.Rewrite404To200 == p.opts.Rewrite404To200) {
+		return nil
+	}
+	if !p.site.CreatedStorageZone {
+		custom404, rewrite := sz.Custom404FilePath, sz.Rewrite404To200
+		p.settings = &StorageZoneUpdate{Custom404FilePath: &custom404, Rewrite404To200: &rewrite}
+	}
+	if err := p.c.UpdateStorageZone(sz.ID, StorageZoneUpdate{Custom404FilePath: &p.opts.Custom404FilePath, Rewrite404To200: &p.opts.Rewrite404To200}); err != nil {
+		return err
+	}
+	sz.Custom404FilePath = p.opts.Custom404FilePath
+	sz.Rewrite404To200 = p.opts.Rewrite404To200
+	return nil
+}
+
+func (p *provisioner) pullZone(ctx context.Context) error {
+	zones, err := p.c.ListPullZones()
+	if err != nil {
+		return err
+	}
+	for _, pz := range *zones {
+		if pz.Name == p.opts.PullZoneName {
+			if pz.StorageZoneID != p.site.StorageZone.ID {
+				return fmt.Errorf("pull zone %v exists, but doesn't serve storage zone %v", pz.Name, p.site.StorageZone.Name)
+			}
+			return p.refreshPullZone(pz.ID)
+		}
+	}
+
+	pz, err := p.c.CreatePullZone(p.opts.PullZoneName, "", p.site.StorageZone.ID, p.opts.PullZoneType)
+	if err != nil {
+		return err
+	}
+	p.site.PullZone = pz
+	p.site.CreatedPullZone = true
+	// the created zone is returned without its system hostname
+	return p.refreshPullZone(pz.ID)
+}
+
+func (p *provisioner) refreshPullZone(zoneID int64) error {
+	pz, err := p.c.GetPullZone(zoneID)
+	if err != nil {
+		return err
+	}
+	p.site.PullZone = pz
+	return nil
+}
+
+// hostname returns the hostname of the PullZone with the given value.
+func (p *provisioner) hostname(value string) *PullZoneHostname {
+	for i, h := range p.site.PullZone.Hostnames {
+		if strings.EqualFold(h.Value, value) {
+			return &p.site.PullZone.Hostnames[i]
+		}
+	}
+	return nil
+}
+
+func (p *provisioner) hostnames(ctx context.Context) error {
+	for _, h := range p.opts.Hostnames {
+		if p.hostname(h) != nil {
+			continue
+		}
+		if err := p.c.AddPullZoneHostname(p.site.PullZone.ID, h); err != nil {
+			return fmt.Errorf("%v: %w", h, err)
+		}
+		if !p.site.CreatedPullZone {
+			p.added = append(p.added, h)
+		}
+	}
+	return p.refreshPullZone(p.site.PullZone.ID)
+}
+
+func (p *provisioner) certificates(ctx context.Context) error {
+	for _, h := range p.opts.Hostnames {
+		if hn := p.hostname(h); hn != nil && hn.HasCertificate {
+			continue
+		}
+		if err := p.c.LoadFreeCertificate(h); err != nil {
+			return fmt.Errorf("%v: %w", h, err)
+		}
+	}
+	return nil
+}
+
+func (p *provisioner) waitForCertificates(ctx context.Context) error {
+	deadline := time.Now().Add(p.opts.CertificateTimeout)
+	for {
+		if err := p.refreshPullZone(p.site.PullZone.ID); err != nil {
+			return err
+		}
+		missing := []string{}
+		for _, h := range p.opts.Hostnames {
+			if hn := p.hostname(h); hn == nil || !hn.HasCertificate {
+				missing = append(missing, h)
+			}
+		}
+		if len(missing) == 0 {
+			return nil
+		}
+		if time.Now().Add(p.opts.PollInterval).After(deadline) {
+			return fmt.Errorf("%w for %v", ErrCertificateTimeout, strings.Join(missing, ", "))
+		}
+
+		t := time.NewTimer(p.opts.PollInterval)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+func (p *provisioner) forceSSL(ctx context.Context) error {
+	if !p.opts.ForceSSL {
+		return nil
+	}
+	for _, h := range p.opts.Hostnames {
+		hn := p.hostname(h)
+		if hn != nil && hn.ForceSSL {
+			continue
+		}
+		if err := p.c.SetPullZoneHostnameForceSSL(p.site.PullZone.ID, h, true); err != nil {
+			return fmt.Errorf("%v: %w", h, err)
+		}
+		if hn != nil {
+			hn.ForceSSL = true
+		}
+	}
+	return nil
+}
+
+// rollback deletes what was created, in reverse order, and restores the
+// settings of an existing StorageZone. The PullZone goes first, as it
+// references the StorageZone.
+func (p *provisioner) rollback() []error {
+	errs := []error{}
+	if p.site.CreatedPullZone {
+		if err := p.c.DeletePullZone(p.site.PullZone.ID); err != nil {
+			errs = append(errs, fmt.Errorf("deleting pull zone %v: %w", p.site.PullZone.Name, err))
+		}
+	} else {
+		for _, h := range p.added {
+			if err := p.c.RemovePullZoneHostname(p.site.PullZone.ID, h); err != nil {
+				errs = append(errs, fmt.Errorf("removing hostname %v: %w", h, err))
+			}
+		}
+	}
+	if p.site.CreatedStorageZone {
+		if err := p.c.DeleteStorageZone(p.site.StorageZone.ID); err != nil {
+			errs = append(errs, fmt.Errorf("deleting storage zone %v: %w", p.site.StorageZone.Name, err))
+		}
+	} else if p.settings != nil {
+		if err := p.c.UpdateStorageZone(p.site.StorageZone.ID, *p.settings); err != nil {
+			errs = append(errs, fmt.Errorf("restoring settings of storage zone %v: %w", p.site.StorageZone.Name, err))
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
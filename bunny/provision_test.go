@@ -0,0 +1,308 @@
+// Copyright (c) 2021 Jan Koppe
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package bunny
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeAPI keeps StorageZones and PullZones in memory, for workflows that
+// need a few consistent API calls.
+type fakeAPI struct {
+	mu           sync.Mutex
+	nextID       int64
+	storageZones map[int64]*StorageZone
+	pullZones    map[int64]*PullZone
+	libraries    map[int64]*VideoLibrary
+	// hostnames whose certificate is issued after that many polls, or
+	// fails to load if negative
+	certPolls map[string]int
+	calls     []string
+}
+
+func newFakeAPI() *fakeAPI {
+	return &fakeAPI{nextID: 1, storageZones: map[int64]*StorageZone{}, pullZones: map[int64]*PullZone{}, libraries: map[int64]*VideoLibrary{}, certPolls: map[string]int{}}
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	body := map[string]interface{}{}
+	_ = json.NewDecoder(r.Body).Decode(&body)
+	str := func(k string) string { s, _ := body[k].(string); return s }
+
+	var id int64
+	var action string
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) > 1 {
+		if _, err := fmt.Sscan(parts[1], &id); err != nil {
+			action = parts[1]
+		}
+	}
+	if len(parts) > 2 {
+		action = parts[2]
+	}
+	call := r.Method + " " + parts[0]
+	if id != 0 {
+		call += " " + parts[1]
+	}
+	if action != "" {
+		call += " " + action
+	}
+	// reads are left out, apart from the certificate loading GET
+	if r.Method != "GET" || action != "" {
+		f.calls = append(f.calls, call)
+	}
+
+	reply := func(v interface{}) { _ = json.NewEncoder(w).Encode(v) }
+	switch {
+	case call == "GET storagezone":
+		zones := []StorageZone{}
+		for _, sz := range f.storageZones {
+			zones = append(zones, *sz)
+		}
+		reply(zones)
+	case call == "POST storagezone":
+		sz := &StorageZone{ID: f.nextID, Name: str("Name"), Region: StorageRegion(str("Region")), Password: "password", ReadOnlyPassword: "ro"}
+		f.nextID++
+		f.storageZones[sz.ID] = sz
+		reply(sz)
+	case parts[0] == "storagezone" && f.storageZones[id] != nil:
+		sz := f.storageZones[id]
+		switch r.Method {
+		case "GET":
+			reply(sz)
+		case "POST":
+			if v, ok := body["Custom404FilePath"].(string); ok {
+				sz.Custom404FilePath = v
+			}
+			if v, ok := body["Rewrite404To200"].(bool); ok {
+				sz.Rewrite404To200 = v
+			}
+		case "DELETE":
+			for _, pz := range f.pullZones {
+				if pz.StorageZoneID == id {
+					w.WriteHeader(http.StatusBadRequest)
+					reply(ErrorResponse{ErrorKey: "storagezone.in_use", Message: "still linked"})
+					return
+				}
+			}
+			delete(f.storageZones, id)
+		}
+	case call == "GET pullzone":
+		zones := []PullZone{}
+		for _, pz := range f.pullZones {
+			zones = append(zones, *pz)
+		}
+		reply(zones)
+	case call == "POST pullzone":
+		pz := &PullZone{ID: f.nextID, Name: str("Name"), StorageZoneID: int64(body["StorageZoneId"].(float64))}
+		f.nextID++
+		f.pullZones[pz.ID] = pz
+		reply(PullZone{ID: pz.ID, Name: pz.Name})
+		pz.Hostnames = []PullZoneHostname{{Value: pz.Name + ".b-cdn.net", IsSystemHostname: true, HasCertificate: true}}
+	case call == "GET pullzone loadFreeCertificate":
+		h := r.URL.Query().Get("hostname")
+		if f.certPolls[h] < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			reply(ErrorResponse{Message: "dns not pointing to bunny"})
+		}
+	case parts[0] == "pullzone" && f.pullZones[id] != nil:
+		pz := f.pullZones[id]
+		switch call[len(call)-len(action):] {
+		case "":
+			switch r.Method {
+			case "GET":
+				for i, h := range pz.Hostnames {
+					if f.certPolls[h.Value] == 0 {
+						pz.Hostnames[i].HasCertificate = true
+					}
+					f.certPolls[h.Value]--
+				}
+				reply(pz)
+			case "POST":
+				if v, ok := body["OriginUrl"].(string); ok {
+					pz.OriginURL = v
+				}
+				if v, ok := body["StorageZoneId"].(float64); ok {
+					pz.StorageZoneID = int64(v)
+				}
+			case "DELETE":
+				delete(f.pullZones, id)
+			}
+		case "addHostname":
+			pz.Hostnames = append(pz.Hostnames, PullZoneHostname{Value: str("Hostname")})
+		case "removeHostname":
+			for i, h := range pz.Hostnames {
+				if h.Value == str("Hostname") {
+					pz.Hostnames = append(pz.Hostnames[:i], pz.Hostnames[i+1:]...)
+					break
+				}
+			}
+		case "setForceSSL":
+			for i, h := range pz.Hostnames {
+				if h.Value == str("Hostname") {
+					pz.Hostnames[i].ForceSSL = body["ForceSSL"].(bool)
+				}
+			}
+		}
+	case call == "GET videolibrary":
+		libs := []VideoLibrary{}
+		for _, lib := range f.libraries {
+			libs = append(libs, *lib)
+		}
+		reply(libs)
+	case r.Method == "DELETE" && parts[0] == "videolibrary" && f.libraries[id] != nil:
+		delete(f.libraries, id)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeAPI) takeCalls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	calls := f.calls
+	f.calls = nil
+	return calls
+}
+
+func newFakeAPIClient(t *testing.T, f *fakeAPI) (*Client, func()) {
+	srv := httptest.NewServer(f)
+	c, err := NewClient("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.BaseURL, _ = url.Parse(srv.URL)
+	return c, srv.Close
+}
+
+func TestProvisionStaticSite(t *testing.T) {
+	api := newFakeAPI()
+	api.certPolls["www.example.com"] = 2
+	c, done := newFakeAPIClient(t, api)
+	defer done()
+	ctx := context.Background()
+
+	opts := StaticSiteOptions{
+		Name:              "example",
+		Hostnames:         []string{"www.example.com"},
+		ForceSSL:          true,
+		Custom404FilePath: "/index.html",
+		Rewrite404To200:   true,
+		PollInterval:      time.Millisecond,
+	}
+	site, err := c.ProvisionStaticSite(ctx, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !site.CreatedStorageZone || !site.CreatedPullZone || site.PullZone.StorageZoneID != site.StorageZone.ID {
+		t.Errorf("unexpected site %+v", site)
+	}
+	if site.StorageZone.Password != "password" || site.StorageZone.Region != SRFalkenstein || !site.StorageZone.Rewrite404To200 {
+		t.Errorf("unexpected storage zone %+v", site.StorageZone)
+	}
+	wantURLs := []string{"https://example.b-cdn.net/", "https://www.example.com/"}
+	if !reflect.DeepEqual(site.URLs(), wantURLs) {
+		t.Errorf("got urls %v, want %v", site.URLs(), wantURLs)
+	}
+	if h := site.PullZone.Hostnames[1]; !h.HasCertificate || !h.ForceSSL {
+		t.Errorf("unexpected hostname %+v", h)
+	}
+	want := []string{
+		"POST storagezone", "POST storagezone 1", "POST pullzone",
+		"POST pullzone 2 addHostname", "GET pullzone loadFreeCertificate", "POST pullzone 2 setForceSSL",
+	}
+	if calls := api.takeCalls(); !reflect.DeepEqual(calls, want) {
+		t.Errorf("got calls %v, want %v", calls, want)
+	}
+
+	// everything is in place, so a second run changes nothing
+	site, err = c.ProvisionStaticSite(ctx, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if site.CreatedStorageZone || site.CreatedPullZone || site.PullZone.ID != 2 {
+		t.Errorf("unexpected site %+v", site)
+	}
+	if calls := api.takeCalls(); len(calls) != 0 {
+		t.Errorf("unexpected calls %v", calls)
+	}
+}
+
+func TestProvisionStaticSiteRollback(t *testing.T) {
+	api := newFakeAPI()
+	api.certPolls["broken.example.com"] = -1
+	api.certPolls["slow.example.com"] = 1000
+	c, done := newFakeAPIClient(t, api)
+	defer done()
+	ctx := context.Background()
+
+	_, err := c.ProvisionStaticSite(ctx, StaticSiteOptions{Name: "broken", Hostnames: []string{"broken.example.com"}})
+	pe := &ProvisionError{}
+	if !errors.As(err, &pe) || pe.Step != "load certificates" || pe.RollbackErrors != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(api.storageZones) != 0 || len(api.pullZones) != 0 {
+		t.Errorf("rollback left zones behind: %v %v", api.storageZones, api.pullZones)
+	}
+
+	// hostnames added to an existing pull zone are removed again and the
+	// storage zone settings are restored, the zones stay
+	if _, err := c.ProvisionStaticSite(ctx, StaticSiteOptions{Name: "slow", Custom404FilePath: "/404.html"}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.ProvisionStaticSite(ctx, StaticSiteOptions{
+		Name:               "slow",
+		Hostnames:          []string{"slow.example.com"},
+		Custom404FilePath:  "/index.html",
+		Rewrite404To200:    true,
+		PollInterval:       time.Millisecond,
+		CertificateTimeout: 20 * time.Millisecond,
+	})
+	if !errors.Is(err, ErrCertificateTimeout) {
+		t.Fatalf("expected certificate timeout, got %v", err)
+	}
+	if len(api.pullZones) != 1 || len(api.storageZones) != 1 {
+		t.Errorf("existing zones were deleted")
+	}
+	for _, sz := range api.storageZones {
+		if sz.Custom404FilePath != "/404.html" || sz.Rewrite404To200 {
+			t.Errorf("storage zone settings were not restored: %+v", sz)
+		}
+	}
+	for _, pz := range api.pullZones {
+		if len(pz.Hostnames) != 1 {
+			t.Errorf("unexpected hostnames %v", pz.Hostnames)
+		}
+	}
+}
@@ -20,30 +20,54 @@
 package bunny
 
 import (
+	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
 )
 
+// The EdgeRule enums are sent to the API as plain numbers, but are known by
+// name everywhere else. All of them implement fmt.Stringer and
+// encoding.TextMarshaler using the names from the API documentation, while
+// still (un-)marshalling to numbers in JSON. Values this library doesn't know
+// about yet are kept as they are, and are formatted as plain numbers.
+
 type EdgeRuleTriggerPatternMatchingType int32
 
 const (
-	ERTPMTMatchAny   EdgeRuleTriggerPatternMatchingType = 0
-	ERTPMTMatchAll   EdgeRuleTriggerPatternMatchingType = 1
-	ERTPMTMatchANone EdgeRuleTriggerPatternMatchingType = 2
+	ERTPMTMatchAny  EdgeRuleTriggerPatternMatchingType = 0
+	ERTPMTMatchAll  EdgeRuleTriggerPatternMatchingType = 1
+	ERTPMTMatchNone EdgeRuleTriggerPatternMatchingType = 2
+
+	// Deprecated: use ERTPMTMatchNone.
+	ERTPMTMatchANone = ERTPMTMatchNone
 )
 
+var edgeRuleMatchingTypeNames = []string{"MatchAny", "MatchAll", "MatchNone"}
+
 type EdgeRuleTriggerType int32
 
 const (
-	ERTTUrl            EdgeRuleTriggerType = 0
-	ERTTRequestHeader  EdgeRuleTriggerType = 1
-	ERTTResponseHeader EdgeRuleTriggerType = 2
-	ERTTUrlExtension   EdgeRuleTriggerType = 3
-	ERTTCountryCode    EdgeRuleTriggerType = 4
-	ERTTRemoteIP       EdgeRuleTriggerType = 5
-	ERTTUrlQueryString EdgeRuleTriggerType = 6
-	ERTTRandomChance   EdgeRuleTriggerType = 7
+	ERTTUrl              EdgeRuleTriggerType = 0
+	ERTTRequestHeader    EdgeRuleTriggerType = 1
+	ERTTResponseHeader   EdgeRuleTriggerType = 2
+	ERTTUrlExtension     EdgeRuleTriggerType = 3
+	ERTTCountryCode      EdgeRuleTriggerType = 4
+	ERTTRemoteIP         EdgeRuleTriggerType = 5
+	ERTTUrlQueryString   EdgeRuleTriggerType = 6
+	ERTTRandomChance     EdgeRuleTriggerType = 7
+	ERTTStatusCode       EdgeRuleTriggerType = 8
+	ERTTRequestMethod    EdgeRuleTriggerType = 9
+	ERTTCookieValue      EdgeRuleTriggerType = 10
+	ERTTCountryStateCode EdgeRuleTriggerType = 11
 )
 
+var edgeRuleTriggerTypeNames = []string{
+	"Url", "RequestHeader", "ResponseHeader", "UrlExtension", "CountryCode", "RemoteIP",
+	"UrlQueryString", "RandomChance", "StatusCode", "RequestMethod", "CookieValue",
+	"CountryStateCode",
+}
+
 type EdgeRuleTrigger struct {
 	Type                EdgeRuleTriggerType
 	PatternMatches      []string
@@ -68,16 +92,206 @@ const (
 	ERATIgnoreQueryString          EdgeRuleActionType = 11
 	ERATDisableOptimizer           EdgeRuleActionType = 12
 	ERATForceCompression           EdgeRuleActionType = 13
+	ERATSetStatusCode              EdgeRuleActionType = 14
+	ERATBypassPermaCache           EdgeRuleActionType = 15
+	ERATOverrideBrowserCacheTime   EdgeRuleActionType = 16
+	ERATOriginStorage              EdgeRuleActionType = 17
+	ERATSetNetworkRateLimit        EdgeRuleActionType = 18
+	ERATSetConnectionLimit         EdgeRuleActionType = 19
+	ERATSetRequestsPerSecondLimit  EdgeRuleActionType = 20
 )
 
+var edgeRuleActionTypeNames = []string{
+	"ForceSSL", "Redirect", "OriginUrl", "OverrideCacheTime", "BlockRequest",
+	"SetResponseHeader", "SetRequestHeader", "ForceDownload", "DisableTokenAuthentication",
+	"EnableTokenAuthentication", "OverrideCacheTimePublic", "IgnoreQueryString",
+	"DisableOptimizer", "ForceCompression", "SetStatusCode", "BypassPermaCache",
+	"OverrideBrowserCacheTime", "OriginStorage", "SetNetworkRateLimit",
+	"SetConnectionLimit", "SetRequestsPerSecondLimit",
+}
+
 type EdgeRuleTriggerMatchingType int32
 
 const (
-	ERTMTMatchAny   EdgeRuleTriggerMatchingType = 0
-	ERTMTMatchAll   EdgeRuleTriggerMatchingType = 1
-	ERTMTMatchANone EdgeRuleTriggerMatchingType = 2
+	ERTMTMatchAny  EdgeRuleTriggerMatchingType = 0
+	ERTMTMatchAll  EdgeRuleTriggerMatchingType = 1
+	ERTMTMatchNone EdgeRuleTriggerMatchingType = 2
+
+	// Deprecated: use ERTMTMatchNone.
+	ERTMTMatchANone = ERTMTMatchNone
 )
 
+func (t EdgeRuleTriggerPatternMatchingType) String() string {
+	return formatEnum(edgeRuleMatchingTypeNames, "EdgeRuleTriggerPatternMatchingType", int32(t))
+}
+
+func (t EdgeRuleTriggerPatternMatchingType) MarshalText() ([]byte, error) {
+	return marshalEnumText(edgeRuleMatchingTypeNames, int32(t))
+}
+
+func (t *EdgeRuleTriggerPatternMatchingType) UnmarshalText(b []byte) error {
+	v, err := parseEnum(edgeRuleMatchingTypeNames, "EdgeRuleTriggerPatternMatchingType", string(b))
+	*t = EdgeRuleTriggerPatternMatchingType(v)
+	return err
+}
+
+func (t EdgeRuleTriggerPatternMatchingType) MarshalJSON() ([]byte, error) {
+	return json.Marshal(int32(t))
+}
+
+func (t *EdgeRuleTriggerPatternMatchingType) UnmarshalJSON(b []byte) error {
+	v, err := unmarshalEnumJSON(edgeRuleMatchingTypeNames, "EdgeRuleTriggerPatternMatchingType", b)
+	*t = EdgeRuleTriggerPatternMatchingType(v)
+	return err
+}
+
+// ParseEdgeRuleTriggerPatternMatchingType parses the name or number of a
+// pattern matching type, ignoring case.
+func ParseEdgeRuleTriggerPatternMatchingType(s string) (EdgeRuleTriggerPatternMatchingType, error) {
+	v, err := parseEnum(edgeRuleMatchingTypeNames, "EdgeRuleTriggerPatternMatchingType", s)
+	return EdgeRuleTriggerPatternMatchingType(v), err
+}
+
+func (t EdgeRuleTriggerType) String() string {
+	return formatEnum(edgeRuleTriggerTypeNames, "EdgeRuleTriggerType", int32(t))
+}
+
+func (t EdgeRuleTriggerType) MarshalText() ([]byte, error) {
+	return marshalEnumText(edgeRuleTriggerTypeNames, int32(t))
+}
+
+func (t *EdgeRuleTriggerType) UnmarshalText(b []byte) error {
+	v, err := parseEnum(edgeRuleTriggerTypeNames, "EdgeRuleTriggerType", string(b))
+	*t = EdgeRuleTriggerType(v)
+	return err
+}
+
+func (t EdgeRuleTriggerType) MarshalJSON() ([]byte, error) {
+	return json.Marshal(int32(t))
+}
+
+func (t *EdgeRuleTriggerType) UnmarshalJSON(b []byte) error {
+	v, err := unmarshalEnumJSON(edgeRuleTriggerTypeNames, "EdgeRuleTriggerType", b)
+	*t = EdgeRuleTriggerType(v)
+	return err
+}
+
+// ParseEdgeRuleTriggerType parses the name or number of a trigger type,
+// ignoring case.
+func ParseEdgeRuleTriggerType(s string) (EdgeRuleTriggerType, error) {
+	v, err := parseEnum(edgeRuleTriggerTypeNames, "EdgeRuleTriggerType", s)
+	return EdgeRuleTriggerType(v), err
+}
+
+func (t EdgeRuleActionType) String() string {
+	return formatEnum(edgeRuleActionTypeNames, "EdgeRuleActionType", int32(t))
+}
+
+func (t EdgeRuleActionType) MarshalText() ([]byte, error) {
+	return marshalEnumText(edgeRuleActionTypeNames, int32(t))
+}
+
+func (t *EdgeRuleActionType) UnmarshalText(b []byte) error {
+	v, err := parseEnum(edgeRuleActionTypeNames, "EdgeRuleActionType", string(b))
+	*t = EdgeRuleActionType(v)
+	return err
+}
+
+func (t EdgeRuleActionType) MarshalJSON() ([]byte, error) {
+	return json.Marshal(int32(t))
+}
+
+func (t *EdgeRuleActionType) UnmarshalJSON(b []byte) error {
+	v, err := unmarshalEnumJSON(edgeRuleActionTypeNames, "EdgeRuleActionType", b)
+	*t = EdgeRuleActionType(v)
+	return err
+}
+
+// ParseEdgeRuleActionType parses the name or number of an action type,
+// ignoring case.
+func ParseEdgeRuleActionType(s string) (EdgeRuleActionType, error) {
+	v, err := parseEnum(edgeRuleActionTypeNames, "EdgeRuleActionType", s)
+	return EdgeRuleActionType(v), err
+}
+
+func (t EdgeRuleTriggerMatchingType) String() string {
+	return formatEnum(edgeRuleMatchingTypeNames, "EdgeRuleTriggerMatchingType", int32(t))
+}
+
+func (t EdgeRuleTriggerMatchingType) MarshalText() ([]byte, error) {
+	return marshalEnumText(edgeRuleMatchingTypeNames, int32(t))
+}
+
+func (t *EdgeRuleTriggerMatchingType) UnmarshalText(b []byte) error {
+	v, err := parseEnum(edgeRuleMatchingTypeNames, "EdgeRuleTriggerMatchingType", string(b))
+	*t = EdgeRuleTriggerMatchingType(v)
+	return err
+}
+
+func (t EdgeRuleTriggerMatchingType) MarshalJSON() ([]byte, error) {
+	return json.Marshal(int32(t))
+}
+
+func (t *EdgeRuleTriggerMatchingType) UnmarshalJSON(b []byte) error {
+	v, err := unmarshalEnumJSON(edgeRuleMatchingTypeNames, "EdgeRuleTriggerMatchingType", b)
+	*t = EdgeRuleTriggerMatchingType(v)
+	return err
+}
+
+// ParseEdgeRuleTriggerMatchingType parses the name or number of a trigger
+// matching type, ignoring case.
+func ParseEdgeRuleTriggerMatchingType(s string) (EdgeRuleTriggerMatchingType, error) {
+	v, err := parseEnum(edgeRuleMatchingTypeNames, "EdgeRuleTriggerMatchingType", s)
+	return EdgeRuleTriggerMatchingType(v), err
+}
+
+func formatEnum(names []string, typ string, v int32) string {
+	if v >= 0 && int(v) < len(names) {
+		return names[v]
+	}
+	return fmt.Sprintf("%v(%d)", typ, v)
+}
+
+func marshalEnumText(names []string, v int32) ([]byte, error) {
+	if v >= 0 && int(v) < len(names) {
+		return []byte(names[v]), nil
+	}
+	return []byte(strconv.FormatInt(int64(v), 10)), nil
+}
+
+// parseEnum accepts a name, a plain number or the output of formatEnum for
+// unknown values.
+func parseEnum(names []string, typ string, s string) (int32, error) {
+	s = strings.TrimSpace(s)
+	for i, n := range names {
+		if strings.EqualFold(n, s) {
+			return int32(i), nil
+		}
+	}
+
+	num := s
+	if strings.HasPrefix(s, typ+"(") && strings.HasSuffix(s, ")") {
+		num = s[len(typ)+1 : len(s)-1]
+	}
+	if v, err := strconv.ParseInt(num, 10, 32); err == nil {
+		return int32(v), nil
+	}
+
+	return 0, fmt.Errorf("invalid %v %q", typ, s)
+}
+
+func unmarshalEnumJSON(names []string, typ string, b []byte) (int32, error) {
+	var v int32
+	if err := json.Unmarshal(b, &v); err == nil {
+		return v, nil
+	}
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return 0, fmt.Errorf("invalid %v %s", typ, b)
+	}
+	return parseEnum(names, typ, s)
+}
+
 type EdgeRule struct {
 	Guid                string `json:",omitempty"`
 	ActionType          EdgeRuleActionType
@@ -145,19 +359,10 @@ func (c *Client) DeleteEdgeRule(zoneID int64, ruleID string) error {
 	return c.doRequest("DELETE", fmt.Sprintf("/pullzone/%v/edgerules/%v", zoneID, ruleID), "", nil, nil)
 }
 
-// This API call seems to be incomplete. This function is effectively a stub, and doesn't actually do anything.
-// I suggest you update the edge rule and toggle the Enabled field there.
-//func (c *Client) SetEdgeRuleEnabled(zoneID int64, ruleID string, enabled bool) error {
-//	opts := map[string]interface{}{
-//		"Id":    zoneID,
-//		"Value": enabled,
-//	}
-//	req, err := c.newRequest("DELETE", fmt.Sprintf("/pullzone/%v/edgerules/%v/setEdgeRuleEnabled", zoneID, ruleID), "", opts)
-//
-//	if err != nil {
-//		return err
-//	}
-//
-//	_, err = c.do(req, nil)
-//	return err
-//}
+func (c *Client) SetEdgeRuleEnabled(zoneID int64, ruleID string, enabled bool) error {
+	opts := map[string]interface{}{
+		"Id":    zoneID,
+		"Value": enabled,
+	}
+	return c.doRequest("POST", fmt.Sprintf("/pullzone/%v/edgerules/%v/setEdgeRuleEnabled", zoneID, ruleID), "", opts, nil)
+}
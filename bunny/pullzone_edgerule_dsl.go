@@ -0,0 +1,735 @@
+// Copyright (c) 2021 Jan Koppe
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package bunny
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// EdgeRules are hard to review in their JSON form, because the interesting
+// parts are hidden behind numeric codes. This file implements a small text
+// format for them, which can be parsed into []EdgeRule and formatted back
+// without losing anything:
+//
+//	# comments run until the end of the line
+//	rule "No caching for the API" disabled
+//	  when url ~ "*/api/*" and country in [DE, FR]
+//	  then set-response-header Cache-Control no-store
+//
+// A rule starts with an optional header (rule <description>, id <guid>,
+// enabled/disabled) followed by the trigger block and the action.
+//
+// The trigger block is one of:
+//
+//	always                        a single url ~ "*" trigger
+//	when [any|all] t1 or t2 ...   TriggerMatchingType MatchAny
+//	when [any|all] t1 and t2 ...  TriggerMatchingType MatchAll
+//	unless t1 or t2 ...           TriggerMatchingType MatchNone
+//	when <n> t1 or t2 ...         any other TriggerMatchingType n
+//
+// bunny.net never applies a rule without triggers, so a rule matching every
+// request needs the catch-all URL trigger written as always. A rule without
+// triggers is written as "when any" or "when all" with nothing following.
+//
+// A trigger is a subject with an optional parameter (request-header(X-Foo)),
+// an operator and either a single pattern or a list of patterns in brackets.
+// The operators ~ and in match any pattern, &~ matches all patterns, !~ and
+// "not in" match none of the patterns. Pattern matching types this library
+// doesn't know are written as match(<n>) [p1, p2].
+//
+// Values can be written as bare words or quoted with single or double quotes.
+// Keywords and values containing whitespace or special characters have to be
+// quoted. Actions and triggers can also be written with their API names
+// (SetResponseHeader, CountryCode), or as plain numbers for types this
+// library doesn't know about.
+
+var edgeRuleActionNames = map[EdgeRuleActionType]string{
+	ERATForceSSL:                   "force-ssl",
+	ERATRedirect:                   "redirect",
+	ERATOriginURL:                  "origin-url",
+	ERATOverrideCacheTime:          "override-cache-time",
+	ERATBlockRequest:               "block-request",
+	ERATSetResponseHeader:          "set-response-header",
+	ERATSetRequestHeader:           "set-request-header",
+	ERATForceDownload:              "force-download",
+	ERATDisableTokenAuthentication: "disable-token-authentication",
+	ERATEnableTokenAuthentication:  "enable-token-authentication",
+	ERATOverrideCacheTimePublic:    "override-cache-time-public",
+	ERATIgnoreQueryString:          "ignore-query-string",
+	ERATDisableOptimizer:           "disable-optimizer",
+	ERATForceCompression:           "force-compression",
+	ERATSetStatusCode:              "set-status-code",
+	ERATBypassPermaCache:           "bypass-perma-cache",
+	ERATOverrideBrowserCacheTime:   "override-browser-cache-time",
+	ERATOriginStorage:              "origin-storage",
+	ERATSetNetworkRateLimit:        "set-network-rate-limit",
+	ERATSetConnectionLimit:         "set-connection-limit",
+	ERATSetRequestsPerSecondLimit:  "set-requests-per-second-limit",
+}
+
+var edgeRuleTriggerNames = map[EdgeRuleTriggerType]string{
+	ERTTUrl:              "url",
+	ERTTRequestHeader:    "request-header",
+	ERTTResponseHeader:   "response-header",
+	ERTTUrlExtension:     "extension",
+	ERTTCountryCode:      "country",
+	ERTTRemoteIP:         "remote-ip",
+	ERTTUrlQueryString:   "query-string",
+	ERTTRandomChance:     "random-chance",
+	ERTTStatusCode:       "status-code",
+	ERTTRequestMethod:    "method",
+	ERTTCookieValue:      "cookie",
+	ERTTCountryStateCode: "country-state",
+}
+
+var edgeRuleKeywords = map[string]bool{
+	"rule": true, "id": true, "enabled": true, "disabled": true,
+	"always": true, "when": true, "unless": true, "then": true,
+	"any": true, "all": true, "and": true, "or": true,
+	"in": true, "not": true,
+}
+
+// EdgeRuleSyntaxError is returned by ParseEdgeRules for malformed input.
+type EdgeRuleSyntaxError struct {
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e *EdgeRuleSyntaxError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Msg)
+}
+
+// ParseEdgeRules parses EdgeRules written in the text format described above.
+func ParseEdgeRules(src string) ([]EdgeRule, error) {
+	toks, err := lexEdgeRules(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &edgeRuleParser{toks: toks}
+	rules := []EdgeRule{}
+	for {
+		for p.peek().kind == ertSemicolon {
+			p.next()
+		}
+		if p.peek().kind == ertEOF {
+			return rules, nil
+		}
+		r, err := p.parseRule()
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+}
+
+// FormatEdgeRules formats EdgeRules in the text format understood by
+// ParseEdgeRules, separated by blank lines.
+func FormatEdgeRules(rules []EdgeRule) string {
+	parts := make([]string, len(rules))
+	for i, r := range rules {
+		parts[i] = FormatEdgeRule(r)
+	}
+	return strings.Join(parts, "\n")
+}
+
+// FormatEdgeRule formats a single EdgeRule in the text format understood by
+// ParseEdgeRules.
+func FormatEdgeRule(r EdgeRule) string {
+	var b strings.Builder
+
+	header := []string{}
+	if r.Description != "" {
+		header = append(header, "rule "+quoteEdgeRuleValue(r.Description))
+	}
+	if r.Guid != "" {
+		header = append(header, "id "+quoteEdgeRuleValue(r.Guid))
+	}
+	if !r.Enabled {
+		header = append(header, "disabled")
+	}
+	if len(header) > 0 {
+		b.WriteString(strings.Join(header, " "))
+		b.WriteString("\n  ")
+	}
+
+	triggers := make([]string, len(r.Triggers))
+	for i, t := range r.Triggers {
+		triggers[i] = formatEdgeRuleTrigger(t)
+	}
+
+	switch r.TriggerMatchingType {
+	case ERTMTMatchNone:
+		b.WriteString("unless")
+		if len(triggers) > 0 {
+			b.WriteString(" " + strings.Join(triggers, " or "))
+		}
+	case ERTMTMatchAll:
+		b.WriteString("when")
+		if len(triggers) < 2 {
+			b.WriteString(" all")
+		}
+		if len(triggers) > 0 {
+			b.WriteString(" " + strings.Join(triggers, " and "))
+		}
+	case ERTMTMatchAny:
+		switch {
+		case len(triggers) == 0:
+			b.WriteString("when any")
+		case len(r.Triggers) == 1 && isCatchAllTrigger(r.Triggers[0]):
+			b.WriteString("always")
+		default:
+			b.WriteString("when " + strings.Join(triggers, " or "))
+		}
+	default:
+		b.WriteString(fmt.Sprintf("when %d", int32(r.TriggerMatchingType)))
+		if len(triggers) > 0 {
+			b.WriteString(" " + strings.Join(triggers, " or "))
+		}
+	}
+
+	b.WriteString("\n  then ")
+	b.WriteString(formatEdgeRuleName(edgeRuleActionNames[r.ActionType], int32(r.ActionType)))
+	if r.ActionParameter1 != "" || r.ActionParameter2 != "" {
+		b.WriteString(" " + quoteEdgeRuleValue(r.ActionParameter1))
+	}
+	if r.ActionParameter2 != "" {
+		b.WriteString(" " + quoteEdgeRuleValue(r.ActionParameter2))
+	}
+	b.WriteString("\n")
+
+	return b.String()
+}
+
+func formatEdgeRuleTrigger(t EdgeRuleTrigger) string {
+	s := formatEdgeRuleName(edgeRuleTriggerNames[t.Type], int32(t.Type))
+	if t.Parameter1 != "" {
+		s += "(" + quoteEdgeRuleValue(t.Parameter1) + ")"
+	}
+
+	patterns := make([]string, len(t.PatternMatches))
+	for i, p := range t.PatternMatches {
+		patterns[i] = quoteEdgeRuleValue(p)
+	}
+	list := "[" + strings.Join(patterns, ", ") + "]"
+
+	single := len(patterns) == 1
+	switch t.PatternMatchingType {
+	case ERTPMTMatchAll:
+		if single {
+			return s + " &~ " + patterns[0]
+		}
+		return s + " &~ " + list
+	case ERTPMTMatchNone:
+		if single {
+			return s + " !~ " + patterns[0]
+		}
+		return s + " not in " + list
+	case ERTPMTMatchAny:
+		if single {
+			return s + " ~ " + patterns[0]
+		}
+		return s + " in " + list
+	default:
+		return fmt.Sprintf("%v match(%d) %v", s, int32(t.PatternMatchingType), list)
+	}
+}
+
+func formatEdgeRuleName(name string, code int32) string {
+	if name == "" {
+		return strconv.FormatInt(int64(code), 10)
+	}
+	return name
+}
+
+func quoteEdgeRuleValue(v string) string {
+	if v == "" || edgeRuleKeywords[strings.ToLower(v)] || strings.ContainsAny(v, " \t\r\n'\"[](),;~#!&") {
+		return strconv.Quote(v)
+	}
+	return v
+}
+
+type edgeRuleTokenKind int
+
+const (
+	ertEOF edgeRuleTokenKind = iota
+	ertWord
+	ertString
+	ertMatch    // ~
+	ertMatchAll // &~
+	ertNoMatch  // !~
+	ertLBracket
+	ertRBracket
+	ertLParen
+	ertRParen
+	ertComma
+	ertSemicolon
+)
+
+type edgeRuleToken struct {
+	kind edgeRuleTokenKind
+	text string
+	line int
+	col  int
+}
+
+// isKeyword reports whether the token is the unquoted keyword kw.
+func (t edgeRuleToken) isKeyword(kw string) bool {
+	return t.kind == ertWord && strings.EqualFold(t.text, kw)
+}
+
+func (t edgeRuleToken) describe() string {
+	switch t.kind {
+	case ertEOF:
+		return "end of input"
+	case ertString:
+		return strconv.Quote(t.text)
+	default:
+		return fmt.Sprintf("%q", t.text)
+	}
+}
+
+func lexEdgeRules(src string) ([]edgeRuleToken, error) {
+	toks := []edgeRuleToken{}
+	line, col := 1, 1
+	rs := []rune(src)
+
+	for i := 0; i < len(rs); {
+		c := rs[i]
+		start := edgeRuleToken{line: line, col: col}
+
+		advance := func(n int) {
+			for k := 0; k < n; k++ {
+				if rs[i] == '\n' {
+					line++
+					col = 1
+				} else {
+					col++
+				}
+				i++
+			}
+		}
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			advance(1)
+		case c == '#':
+			for i < len(rs) && rs[i] != '\n' {
+				advance(1)
+			}
+		case c == '\'' || c == '"':
+			var b strings.Builder
+			advance(1)
+			closed := false
+			for i < len(rs) {
+				if rs[i] == c {
+					advance(1)
+					closed = true
+					break
+				}
+				if rs[i] == '\\' && i+1 < len(rs) {
+					advance(1)
+					if c == '"' {
+						// double quoted strings use Go escapes, so let strconv handle them
+						b.WriteRune('\\')
+					} else if rs[i] != '\'' && rs[i] != '\\' {
+						b.WriteRune('\\')
+					}
+				}
+				b.WriteRune(rs[i])
+				advance(1)
+			}
+			if !closed {
+				return nil, &EdgeRuleSyntaxError{start.line, start.col, "unterminated string"}
+			}
+			text := b.String()
+			if c == '"' {
+				s, err := strconv.Unquote("\"" + text + "\"")
+				if err != nil {
+					return nil, &EdgeRuleSyntaxError{start.line, start.col, "invalid escape sequence in string"}
+				}
+				text = s
+			}
+			start.kind, start.text = ertString, text
+			toks = append(toks, start)
+		case c == '~':
+			start.kind, start.text = ertMatch, "~"
+			toks = append(toks, start)
+			advance(1)
+		case (c == '&' || c == '!') && i+1 < len(rs) && rs[i+1] == '~':
+			start.kind, start.text = ertMatchAll, "&~"
+			if c == '!' {
+				start.kind, start.text = ertNoMatch, "!~"
+			}
+			toks = append(toks, start)
+			advance(2)
+		case strings.ContainsRune("[](),;", c):
+			start.kind = map[rune]edgeRuleTokenKind{
+				'[': ertLBracket, ']': ertRBracket,
+				'(': ertLParen, ')': ertRParen,
+				',': ertComma, ';': ertSemicolon,
+			}[c]
+			start.text = string(c)
+			toks = append(toks, start)
+			advance(1)
+		default:
+			j := i
+			for j < len(rs) && !strings.ContainsRune(" \t\r\n'\"[](),;~", rs[j]) {
+				j++
+			}
+			start.kind, start.text = ertWord, string(rs[i:j])
+			toks = append(toks, start)
+			advance(j - i)
+		}
+	}
+
+	toks = append(toks, edgeRuleToken{kind: ertEOF, line: line, col: col})
+	return toks, nil
+}
+
+type edgeRuleParser struct {
+	toks []edgeRuleToken
+	pos  int
+}
+
+func (p *edgeRuleParser) peek() edgeRuleToken {
+	return p.toks[p.pos]
+}
+
+// peekMatchingType reports whether the next token is the number of a
+// trigger matching type, rather than a trigger type written as a number. A
+// matching type is followed by a trigger or then, a trigger by its parameter
+// or operator.
+func (p *edgeRuleParser) peekMatchingType() bool {
+	t := p.peek()
+	if t.kind != ertWord || !isEdgeRuleNumber(t.text) || p.pos+1 >= len(p.toks) {
+		return false
+	}
+	n := p.toks[p.pos+1]
+	return n.kind == ertWord && !n.isKeyword("in") && !n.isKeyword("not") && !strings.EqualFold(n.text, "match")
+}
+
+func (p *edgeRuleParser) next() edgeRuleToken {
+	t := p.toks[p.pos]
+	if t.kind != ertEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *edgeRuleParser) errorf(t edgeRuleToken, format string, args ...interface{}) error {
+	return &EdgeRuleSyntaxError{t.line, t.col, fmt.Sprintf(format, args...)}
+}
+
+func startsEdgeRule(t edgeRuleToken) bool {
+	for _, kw := range []string{"rule", "id", "enabled", "disabled", "always", "when", "unless"} {
+		if t.isKeyword(kw) {
+			return true
+		}
+	}
+	return false
+}
+
+// value consumes a bare word or a quoted string.
+func (p *edgeRuleParser) value(what string) (string, error) {
+	t := p.next()
+	if t.kind != ertString && (t.kind != ertWord || edgeRuleKeywords[strings.ToLower(t.text)]) {
+		return "", p.errorf(t, "expected %s, got %s", what, t.describe())
+	}
+	return t.text, nil
+}
+
+func (p *edgeRuleParser) parseRule() (EdgeRule, error) {
+	r := EdgeRule{Triggers: []EdgeRuleTrigger{}, Enabled: true}
+
+	// header
+	for {
+		t := p.peek()
+		switch {
+		case t.isKeyword("rule"):
+			p.next()
+			v, err := p.value("rule description")
+			if err != nil {
+				return r, err
+			}
+			r.Description = v
+			continue
+		case t.isKeyword("id"):
+			p.next()
+			v, err := p.value("rule id")
+			if err != nil {
+				return r, err
+			}
+			r.Guid = v
+			continue
+		case t.isKeyword("enabled"):
+			p.next()
+			r.Enabled = true
+			continue
+		case t.isKeyword("disabled"):
+			p.next()
+			r.Enabled = false
+			continue
+		}
+		break
+	}
+
+	// triggers
+	t := p.next()
+	switch {
+	case t.isKeyword("always"):
+		r.TriggerMatchingType = ERTMTMatchAny
+		r.Triggers = []EdgeRuleTrigger{catchAllTrigger()}
+	case t.isKeyword("unless"):
+		r.TriggerMatchingType = ERTMTMatchNone
+		triggers, _, err := p.parseTriggers(false)
+		if err != nil {
+			return r, err
+		}
+		r.Triggers = triggers
+	case t.isKeyword("when"):
+		r.TriggerMatchingType = ERTMTMatchAny
+		explicit := false
+		if p.peekMatchingType() {
+			// a matching type this library doesn't know
+			n, _ := strconv.ParseInt(p.next().text, 10, 32)
+			r.TriggerMatchingType = EdgeRuleTriggerMatchingType(n)
+			triggers, _, err := p.parseTriggers(true)
+			if err != nil {
+				return r, err
+			}
+			r.Triggers = triggers
+			break
+		}
+		if q := p.peek(); q.isKeyword("all") || q.isKeyword("any") {
+			p.next()
+			explicit = true
+			if q.isKeyword("all") {
+				r.TriggerMatchingType = ERTMTMatchAll
+			}
+		}
+		triggers, conj, err := p.parseTriggers(true)
+		if err != nil {
+			return r, err
+		}
+		if conj != "" {
+			mt := ERTMTMatchAny
+			if conj == "and" {
+				mt = ERTMTMatchAll
+			}
+			if explicit && mt != r.TriggerMatchingType {
+				return r, p.errorf(t, "triggers joined with %q contradict the matching type", conj)
+			}
+			r.TriggerMatchingType = mt
+		}
+		r.Triggers = triggers
+	default:
+		return r, p.errorf(t, "expected always, when or unless, got %s", t.describe())
+	}
+
+	// action
+	if t := p.next(); !t.isKeyword("then") {
+		return r, p.errorf(t, "expected then, got %s", t.describe())
+	}
+	t = p.next()
+	if t.kind != ertWord {
+		return r, p.errorf(t, "expected action, got %s", t.describe())
+	}
+	action, ok := lookupEdgeRuleAction(t.text)
+	if !ok {
+		return r, p.errorf(t, "unknown action %q", t.text)
+	}
+	r.ActionType = action
+
+	params := []string{}
+	for len(params) < 2 {
+		n := p.peek()
+		if n.kind == ertString || (n.kind == ertWord && !edgeRuleKeywords[strings.ToLower(n.text)]) {
+			params = append(params, p.next().text)
+			continue
+		}
+		break
+	}
+	if len(params) > 0 {
+		r.ActionParameter1 = params[0]
+	}
+	if len(params) > 1 {
+		r.ActionParameter2 = params[1]
+	}
+
+	// the action has to be followed by the next rule or the end of input
+	n := p.peek()
+	if n.kind != ertEOF && n.kind != ertSemicolon && !startsEdgeRule(n) {
+		return r, p.errorf(n, "unexpected %s after action", n.describe())
+	}
+
+	return r, nil
+}
+
+// catchAllTrigger returns the URL trigger matching every request.
+func catchAllTrigger() EdgeRuleTrigger {
+	return EdgeRuleTrigger{Type: ERTTUrl, PatternMatches: []string{"*"}, PatternMatchingType: ERTPMTMatchAny}
+}
+
+func isCatchAllTrigger(t EdgeRuleTrigger) bool {
+	return t.Type == ERTTUrl && t.Parameter1 == "" && t.PatternMatchingType == ERTPMTMatchAny && len(t.PatternMatches) == 1 && t.PatternMatches[0] == "*"
+}
+
+// parseTriggers parses a possibly empty list of triggers, joined by "and" or
+// "or". It returns the conjunction used, or "" for less than two triggers.
+func (p *edgeRuleParser) parseTriggers(strict bool) ([]EdgeRuleTrigger, string, error) {
+	triggers := []EdgeRuleTrigger{}
+	conj := ""
+
+	if p.peek().isKeyword("then") {
+		return triggers, conj, nil
+	}
+
+	for {
+		t, err := p.parseTrigger()
+		if err != nil {
+			return nil, "", err
+		}
+		triggers = append(triggers, t)
+
+		n := p.peek()
+		if !n.isKeyword("and") && !n.isKeyword("or") {
+			return triggers, conj, nil
+		}
+		p.next()
+		c := strings.ToLower(n.text)
+		if strict && conj != "" && c != conj {
+			return nil, "", p.errorf(n, "cannot mix and and or within one rule")
+		}
+		conj = c
+	}
+}
+
+func (p *edgeRuleParser) parseTrigger() (EdgeRuleTrigger, error) {
+	tr := EdgeRuleTrigger{}
+
+	t := p.next()
+	if t.kind != ertWord {
+		return tr, p.errorf(t, "expected trigger, got %s", t.describe())
+	}
+	tt, ok := lookupEdgeRuleTrigger(t.text)
+	if !ok {
+		return tr, p.errorf(t, "unknown trigger %q", t.text)
+	}
+	tr.Type = tt
+
+	if p.peek().kind == ertLParen {
+		p.next()
+		v, err := p.value("trigger parameter")
+		if err != nil {
+			return tr, err
+		}
+		tr.Parameter1 = v
+		if t := p.next(); t.kind != ertRParen {
+			return tr, p.errorf(t, "expected ), got %s", t.describe())
+		}
+	}
+
+	op := p.next()
+	switch {
+	case op.kind == ertMatch || op.isKeyword("in"):
+		tr.PatternMatchingType = ERTPMTMatchAny
+	case op.kind == ertMatchAll:
+		tr.PatternMatchingType = ERTPMTMatchAll
+	case op.kind == ertNoMatch:
+		tr.PatternMatchingType = ERTPMTMatchNone
+	case op.isKeyword("not"):
+		if t := p.next(); !t.isKeyword("in") {
+			return tr, p.errorf(t, "expected in, got %s", t.describe())
+		}
+		tr.PatternMatchingType = ERTPMTMatchNone
+	case op.kind == ertWord && strings.EqualFold(op.text, "match"):
+		if t := p.next(); t.kind != ertLParen {
+			return tr, p.errorf(t, "expected (, got %s", t.describe())
+		}
+		t := p.next()
+		if t.kind != ertWord || !isEdgeRuleNumber(t.text) {
+			return tr, p.errorf(t, "expected pattern matching type, got %s", t.describe())
+		}
+		n, _ := strconv.ParseInt(t.text, 10, 32)
+		tr.PatternMatchingType = EdgeRuleTriggerPatternMatchingType(n)
+		if t := p.next(); t.kind != ertRParen {
+			return tr, p.errorf(t, "expected ), got %s", t.describe())
+		}
+	default:
+		return tr, p.errorf(op, "expected ~, &~, !~, in, not in or match, got %s", op.describe())
+	}
+
+	tr.PatternMatches = []string{}
+	if p.peek().kind != ertLBracket {
+		v, err := p.value("pattern")
+		if err != nil {
+			return tr, err
+		}
+		tr.PatternMatches = append(tr.PatternMatches, v)
+		return tr, nil
+	}
+
+	p.next()
+	for p.peek().kind != ertRBracket {
+		v, err := p.value("pattern")
+		if err != nil {
+			return tr, err
+		}
+		tr.PatternMatches = append(tr.PatternMatches, v)
+		if p.peek().kind != ertComma {
+			break
+		}
+		p.next()
+	}
+	if t := p.next(); t.kind != ertRBracket {
+		return tr, p.errorf(t, "expected ], got %s", t.describe())
+	}
+
+	return tr, nil
+}
+
+func isEdgeRuleNumber(s string) bool {
+	_, err := strconv.ParseInt(s, 10, 32)
+	return err == nil
+}
+
+func lookupEdgeRuleAction(name string) (EdgeRuleActionType, bool) {
+	for k, v := range edgeRuleActionNames {
+		if strings.EqualFold(v, name) {
+			return k, true
+		}
+	}
+	t, err := ParseEdgeRuleActionType(name)
+	return t, err == nil
+}
+
+func lookupEdgeRuleTrigger(name string) (EdgeRuleTriggerType, bool) {
+	for k, v := range edgeRuleTriggerNames {
+		if strings.EqualFold(v, name) {
+			return k, true
+		}
+	}
+	t, err := ParseEdgeRuleTriggerType(name)
+	return t, err == nil
+}
@@ -0,0 +1,171 @@
+// Copyright (c) 2021 Jan Koppe
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package bunny
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseEdgeRules(t *testing.T) {
+	src := `
+	# the example from the docs, all on one line
+	when url ~ '*/api/*' and country in [DE,FR] then set-response-header Cache-Control 'no-store'
+
+	rule "Block bots" disabled
+	  unless request-header(User-Agent) !~ "*bot*"
+	  then block-request
+	`
+
+	rules, err := ParseEdgeRules(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []EdgeRule{
+		{
+			ActionType:       ERATSetResponseHeader,
+			ActionParameter1: "Cache-Control",
+			ActionParameter2: "no-store",
+			Triggers: []EdgeRuleTrigger{
+				{Type: ERTTUrl, PatternMatches: []string{"*/api/*"}, PatternMatchingType: ERTPMTMatchAny},
+				{Type: ERTTCountryCode, PatternMatches: []string{"DE", "FR"}, PatternMatchingType: ERTPMTMatchAny},
+			},
+			TriggerMatchingType: ERTMTMatchAll,
+			Enabled:             true,
+		},
+		{
+			ActionType: ERATBlockRequest,
+			Triggers: []EdgeRuleTrigger{
+				{Type: ERTTRequestHeader, Parameter1: "User-Agent", PatternMatches: []string{"*bot*"}, PatternMatchingType: ERTPMTMatchNone},
+			},
+			TriggerMatchingType: ERTMTMatchNone,
+			Description:         "Block bots",
+			Enabled:             false,
+		},
+	}
+
+	if !reflect.DeepEqual(rules, want) {
+		t.Errorf("parsed rules do not match:\n got: %+v\nwant: %+v", rules, want)
+	}
+}
+
+func TestFormatEdgeRulesRoundTrip(t *testing.T) {
+	rules := []EdgeRule{
+		{
+			Guid:             "1a2b3c",
+			ActionType:       ERATRedirect,
+			ActionParameter1: "https://example.com/{{path}}",
+			Triggers: []EdgeRuleTrigger{
+				{Type: ERTTUrl, PatternMatches: []string{"http://*", "*/old path/*"}, PatternMatchingType: ERTPMTMatchAny},
+			},
+			TriggerMatchingType: ERTMTMatchAll,
+			Description:         `say "when", then "and"`,
+			Enabled:             true,
+		},
+		{
+			ActionType:       ERATSetRequestHeader,
+			ActionParameter1: "",
+			ActionParameter2: "and",
+			Triggers: []EdgeRuleTrigger{
+				{Type: ERTTUrlQueryString, PatternMatches: []string{"a=1&b=2", "#x;y"}, PatternMatchingType: ERTPMTMatchAll},
+				{Type: ERTTRandomChance, PatternMatches: []string{}, PatternMatchingType: ERTPMTMatchNone},
+			},
+			TriggerMatchingType: ERTMTMatchNone,
+			Enabled:             false,
+		},
+		{
+			ActionType:          EdgeRuleActionType(99),
+			ActionParameter1:    "x",
+			Triggers:            []EdgeRuleTrigger{},
+			TriggerMatchingType: ERTMTMatchAny,
+			Enabled:             true,
+		},
+		{
+			ActionType: ERATForceSSL,
+			Triggers: []EdgeRuleTrigger{
+				{Type: EdgeRuleTriggerType(42), Parameter1: "p", PatternMatches: []string{"1"}, PatternMatchingType: ERTPMTMatchAll},
+			},
+			TriggerMatchingType: ERTMTMatchAll,
+			Enabled:             true,
+		},
+		{
+			// matching types this library doesn't know yet
+			ActionType: ERATBlockRequest,
+			Triggers: []EdgeRuleTrigger{
+				{Type: ERTTUrl, PatternMatches: []string{"*/admin/*"}, PatternMatchingType: EdgeRuleTriggerPatternMatchingType(5)},
+				{Type: ERTTCountryCode, PatternMatches: []string{"DE"}, PatternMatchingType: ERTPMTMatchAny},
+			},
+			TriggerMatchingType: EdgeRuleTriggerMatchingType(7),
+			Enabled:             true,
+		},
+		{
+			// written as always
+			ActionType:          ERATForceSSL,
+			Triggers:            []EdgeRuleTrigger{{Type: ERTTUrl, PatternMatches: []string{"*"}, PatternMatchingType: ERTPMTMatchAny}},
+			TriggerMatchingType: ERTMTMatchAny,
+			Enabled:             true,
+		},
+		{
+			ActionType:          ERATForceSSL,
+			Triggers:            []EdgeRuleTrigger{},
+			TriggerMatchingType: EdgeRuleTriggerMatchingType(7),
+			Enabled:             true,
+		},
+		{
+			ActionType: ERATForceSSL,
+			Triggers: []EdgeRuleTrigger{
+				{Type: EdgeRuleTriggerType(42), PatternMatches: []string{"x"}, PatternMatchingType: ERTPMTMatchAny},
+				{Type: EdgeRuleTriggerType(43), PatternMatches: []string{"y"}, PatternMatchingType: EdgeRuleTriggerPatternMatchingType(5)},
+			},
+			TriggerMatchingType: ERTMTMatchAny,
+			Enabled:             true,
+		},
+	}
+
+	text := FormatEdgeRules(rules)
+	parsed, err := ParseEdgeRules(text)
+	if err != nil {
+		t.Fatalf("%v\n%s", err, text)
+	}
+
+	if !reflect.DeepEqual(parsed, rules) {
+		t.Errorf("round trip changed rules:\n%s\n got: %+v\nwant: %+v", text, parsed, rules)
+	}
+}
+
+func TestParseEdgeRulesErrors(t *testing.T) {
+	for _, src := range []string{
+		"then force-ssl",
+		"when url ~ x",
+		"when url ~ x and country ~ DE or country ~ FR then force-ssl",
+		"when all url ~ x or country ~ DE then force-ssl",
+		"when teleport ~ x then force-ssl",
+		"when url ~ 'x then force-ssl",
+		"when url ~ [a, b then force-ssl",
+		"always then force-ssl a b c",
+		"always then fly",
+	} {
+		_, err := ParseEdgeRules(src)
+		if _, ok := err.(*EdgeRuleSyntaxError); !ok {
+			t.Errorf("expected syntax error for %q, got %v", src, err)
+		}
+	}
+}
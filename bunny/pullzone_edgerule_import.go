@@ -0,0 +1,1053 @@
+// Copyright (c) 2021 Jan Koppe
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package bunny
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Sites migrating to bunny.net usually carry their caching, header and
+// redirect logic around in web server configuration. The importers in this
+// file translate a useful subset of nginx and Apache .htaccess directives
+// into EdgeRules. Anything they can't translate faithfully is skipped and
+// reported as an EdgeRuleImportWarning instead of being guessed at.
+//
+// Bunny matches URL triggers against the full URL including scheme and
+// hostname, so paths are translated into patterns starting with a wildcard.
+
+// EdgeRuleImportWarning describes a directive that was not translated.
+type EdgeRuleImportWarning struct {
+	Line      int
+	Directive string
+	Reason    string
+}
+
+func (w EdgeRuleImportWarning) String() string {
+	return fmt.Sprintf("line %d: %s: %s", w.Line, w.Directive, w.Reason)
+}
+
+// configDirective is a parsed nginx directive or .htaccess line.
+type configDirective struct {
+	Name     string
+	Args     []string
+	Line     int
+	Block    []configDirective
+	HasBlock bool
+}
+
+func (d configDirective) text() string {
+	return strings.TrimSpace(d.Name + " " + strings.Join(d.Args, " "))
+}
+
+type edgeRuleImporter struct {
+	source   string
+	rules    []EdgeRule
+	warnings []EdgeRuleImportWarning
+}
+
+func (im *edgeRuleImporter) warn(d configDirective, reason string) {
+	im.warnings = append(im.warnings, EdgeRuleImportWarning{d.Line, d.text(), reason})
+}
+
+func (im *edgeRuleImporter) add(d configDirective, triggers []EdgeRuleTrigger, action EdgeRuleActionType, p1, p2 string) {
+	if len(triggers) == 0 {
+		triggers = []EdgeRuleTrigger{urlTrigger("*")}
+	}
+	im.rules = append(im.rules, EdgeRule{
+		ActionType:          action,
+		ActionParameter1:    p1,
+		ActionParameter2:    p2,
+		Triggers:            append([]EdgeRuleTrigger{}, triggers...),
+		TriggerMatchingType: ERTMTMatchAll,
+		Description:         fmt.Sprintf("%s line %d: %s", im.source, d.Line, d.text()),
+		Enabled:             true,
+	})
+}
+
+func urlTrigger(patterns ...string) EdgeRuleTrigger {
+	return EdgeRuleTrigger{Type: ERTTUrl, PatternMatches: patterns, PatternMatchingType: ERTPMTMatchAny}
+}
+
+// ImportNginxEdgeRules translates nginx configuration into EdgeRules. It
+// understands server and location blocks (including nested locations and
+// exact, prefix and simple regex matches) and the directives return, rewrite
+// with the redirect or permanent flag, add_header, expires, allow and deny,
+// proxy_pass, proxy_set_header and gzip. Other directives are reported as
+// warnings.
+//
+// The rules of a server block are limited to its server_name hostnames.
+// add_header directives are not applied to blocks setting headers of their
+// own, as nginx doesn't inherit them there. The addresses allowed before a
+// deny are exempt from its rule. Unlike nginx, which picks a single location
+// per request, the rules of all matching locations apply.
+func ImportNginxEdgeRules(src string) ([]EdgeRule, []EdgeRuleImportWarning, error) {
+	directives, err := parseNginxConfig(src)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	im := &edgeRuleImporter{source: "nginx", rules: []EdgeRule{}, warnings: []EdgeRuleImportWarning{}}
+	im.nginxBlock(directives, nil)
+	return im.rules, im.warnings, nil
+}
+
+func (im *edgeRuleImporter) nginxBlock(directives []configDirective, triggers []EdgeRuleTrigger) {
+	servers := 0
+	for _, d := range directives {
+		if d.Name == "server" {
+			servers++
+		}
+	}
+	headerTriggers := append(append([]EdgeRuleTrigger{}, triggers...), im.nginxHeaderExclusions(directives)...)
+
+	for i, d := range directives {
+		switch d.Name {
+		case "http":
+			im.nginxBlock(d.Block, triggers)
+		case "server":
+			t, regexps, ok := nginxServerTrigger(d)
+			for _, r := range regexps {
+				im.warn(r, "regular expression server names can't be translated")
+			}
+			if !ok {
+				if servers > 1 {
+					im.warn(d, "server without server_name, its rules apply to all hostnames")
+				}
+				im.nginxBlock(d.Block, triggers)
+				continue
+			}
+			im.nginxBlock(d.Block, append(append([]EdgeRuleTrigger{}, triggers...), t))
+		case "server_name":
+			// translated with its server block
+		case "location":
+			t, reason := nginxLocationTrigger(d.Args)
+			if reason != "" {
+				im.warn(d, reason)
+				continue
+			}
+			im.nginxBlock(d.Block, append(append([]EdgeRuleTrigger{}, triggers...), t))
+		case "return":
+			im.nginxReturn(d, triggers)
+		case "rewrite":
+			im.nginxRewrite(d, triggers)
+		case "add_header":
+			if len(d.Args) < 2 || len(d.Args) > 3 || (len(d.Args) == 3 && d.Args[2] != "always") {
+				im.warn(d, "unexpected arguments")
+			} else if strings.Contains(d.Args[1], "$") {
+				im.warn(d, "header values with nginx variables can't be translated")
+			} else {
+				im.add(d, headerTriggers, ERATSetResponseHeader, d.Args[0], d.Args[1])
+			}
+		case "proxy_set_header":
+			if len(d.Args) != 2 {
+				im.warn(d, "unexpected arguments")
+			} else if strings.Contains(d.Args[1], "$") {
+				im.warn(d, "header values with nginx variables can't be translated")
+			} else {
+				im.add(d, triggers, ERATSetRequestHeader, d.Args[0], d.Args[1])
+			}
+		case "expires":
+			if len(d.Args) != 1 {
+				im.warn(d, "unexpected arguments")
+				continue
+			}
+			secs, ok := nginxDuration(d.Args[0])
+			if !ok {
+				im.warn(d, "unsupported expiry time")
+				continue
+			}
+			im.add(d, triggers, ERATOverrideBrowserCacheTime, strconv.FormatInt(secs, 10), "")
+		case "allow":
+			if len(d.Args) != 1 {
+				im.warn(d, "unexpected arguments")
+			} else if !nginxDenies(directives[i+1:]) {
+				im.warn(d, "allow without a later deny in the same block can't be translated")
+			}
+		case "deny":
+			if len(d.Args) != 1 {
+				im.warn(d, "unexpected arguments")
+				continue
+			}
+			// nginx applies the first matching allow or deny, so the
+			// allowed addresses before this deny are exempt from it
+			allowed := []string{}
+			for _, a := range directives[:i] {
+				if a.Name == "allow" && len(a.Args) == 1 {
+					allowed = append(allowed, a.Args[0])
+				}
+			}
+			im.denyRemoteIPs(d, triggers, []string{d.Args[0]}, allowed)
+		case "proxy_pass":
+			if len(d.Args) != 1 || strings.Contains(d.Args[0], "$") || !isEdgeRuleURL(d.Args[0]) {
+				im.warn(d, "only absolute origin URLs without variables can be translated")
+			} else {
+				im.add(d, triggers, ERATOriginURL, d.Args[0], "")
+			}
+		case "gzip":
+			if len(d.Args) == 1 && d.Args[0] == "on" {
+				im.add(d, triggers, ERATForceCompression, "", "")
+			} else {
+				im.warn(d, "only gzip on can be translated")
+			}
+		case "if":
+			im.warn(d, "if blocks can't be translated")
+		default:
+			im.warn(d, "no edge rule equivalent")
+		}
+	}
+}
+
+func nginxDenies(directives []configDirective) bool {
+	for _, d := range directives {
+		if d.Name == "deny" {
+			return true
+		}
+	}
+	return false
+}
+
+// denyRemoteIPs adds a rule blocking the denied addresses, or everyone for
+// "all", except for the allowed addresses. Nothing is added if all
+// addresses are allowed.
+func (im *edgeRuleImporter) denyRemoteIPs(d configDirective, triggers []EdgeRuleTrigger, denied []string, allowed []string) {
+	t := append([]EdgeRuleTrigger{}, triggers...)
+	for _, a := range allowed {
+		if strings.EqualFold(a, "all") {
+			im.warn(d, "all addresses are allowed, so nothing is denied")
+			return
+		}
+	}
+	if len(denied) != 1 || !strings.EqualFold(denied[0], "all") {
+		t = append(t, EdgeRuleTrigger{Type: ERTTRemoteIP, PatternMatches: denied, PatternMatchingType: ERTPMTMatchAny})
+	}
+	if len(allowed) > 0 {
+		t = append(t, EdgeRuleTrigger{Type: ERTTRemoteIP, PatternMatches: allowed, PatternMatchingType: ERTPMTMatchNone})
+	}
+	im.add(d, t, ERATBlockRequest, "", "")
+}
+
+// nginxServerTrigger returns a trigger matching the server_name hostnames of
+// a server block, and the server_name directives with regular expressions,
+// which can't be translated. It returns false for catch-all servers.
+func nginxServerTrigger(server configDirective) (EdgeRuleTrigger, []configDirective, bool) {
+	patterns := []string{}
+	regexps := []configDirective{}
+	for _, d := range server.Block {
+		if d.Name != "server_name" {
+			continue
+		}
+		for _, name := range d.Args {
+			switch {
+			case name == "_" || name == "":
+			case strings.HasPrefix(name, "~"):
+				regexps = append(regexps, d)
+			case strings.HasPrefix(name, "."):
+				patterns = append(patterns, "*://"+name[1:]+"/*", "*://*"+name+"/*")
+			default:
+				patterns = append(patterns, "*://"+name+"/*")
+			}
+		}
+	}
+	if len(patterns) == 0 {
+		return EdgeRuleTrigger{}, regexps, false
+	}
+	return urlTrigger(patterns...), regexps, true
+}
+
+// nginxBlockTrigger returns the trigger of a server or location block.
+func nginxBlockTrigger(d configDirective) (EdgeRuleTrigger, bool) {
+	if d.Name == "server" {
+		t, _, ok := nginxServerTrigger(d)
+		return t, ok
+	}
+	t, reason := nginxLocationTrigger(d.Args)
+	return t, reason == ""
+}
+
+func nginxSetsHeaders(directives []configDirective) bool {
+	for _, d := range directives {
+		if d.Name == "add_header" {
+			return true
+		}
+	}
+	return false
+}
+
+// nginxHeaderExclusions returns triggers keeping the add_header directives
+// of a block out of the nested blocks that set headers of their own. Only
+// direct children can be excluded, deeper blocks are reported.
+func (im *edgeRuleImporter) nginxHeaderExclusions(directives []configDirective) []EdgeRuleTrigger {
+	if !nginxSetsHeaders(directives) {
+		return nil
+	}
+	exclusions := []EdgeRuleTrigger{}
+	var nested func(ds []configDirective)
+	nested = func(ds []configDirective) {
+		for _, d := range ds {
+			if (d.Name == "location" || d.Name == "server") && nginxSetsHeaders(d.Block) {
+				im.warn(d, "nginx doesn't inherit outer add_header directives into this block, but the edge rules for them apply here")
+			} else if d.HasBlock {
+				nested(d.Block)
+			}
+		}
+	}
+	for _, d := range directives {
+		if d.Name != "location" && d.Name != "server" {
+			continue
+		}
+		if !nginxSetsHeaders(d.Block) {
+			nested(d.Block)
+			continue
+		}
+		t, ok := nginxBlockTrigger(d)
+		if !ok {
+			im.warn(d, "nginx doesn't inherit outer add_header directives into this block, but the edge rules for them apply here")
+			continue
+		}
+		t.PatternMatchingType = ERTPMTMatchNone
+		exclusions = append(exclusions, t)
+	}
+	return exclusions
+}
+
+func nginxLocationTrigger(args []string) (EdgeRuleTrigger, string) {
+	switch {
+	case len(args) == 1 && strings.HasPrefix(args[0], "@"):
+		return EdgeRuleTrigger{}, "named locations can't be translated"
+	case len(args) == 1:
+		return urlTrigger(globPrefix(args[0])), ""
+	case len(args) == 2 && args[0] == "^~":
+		return urlTrigger(globPrefix(args[1])), ""
+	case len(args) == 2 && args[0] == "=":
+		return urlTrigger(globExact(args[1])), ""
+	case len(args) == 2 && (args[0] == "~" || args[0] == "~*"):
+		globs, ok := regexToGlobs(args[1], false)
+		if !ok {
+			return EdgeRuleTrigger{}, "regular expression is too complex to translate"
+		}
+		return urlTrigger(globs...), ""
+	}
+	return EdgeRuleTrigger{}, "unexpected arguments"
+}
+
+// nginxForceSSL matches the usual "redirect everything to https" targets.
+var nginxForceSSL = regexp.MustCompile(`^https://\$(host|server_name|http_host)\$request_uri$`)
+
+func (im *edgeRuleImporter) nginxReturn(d configDirective, triggers []EdgeRuleTrigger) {
+	if len(d.Args) == 0 || len(d.Args) > 2 {
+		im.warn(d, "unexpected arguments")
+		return
+	}
+	code, err := strconv.Atoi(d.Args[0])
+	if err != nil {
+		im.warn(d, "returning a URL without status code can't be translated")
+		return
+	}
+
+	switch {
+	case code == 301 || code == 302 || code == 307 || code == 308:
+		if len(d.Args) != 2 {
+			im.warn(d, "redirect without target")
+		} else if nginxForceSSL.MatchString(d.Args[1]) {
+			im.add(d, triggers, ERATForceSSL, "", "")
+		} else if strings.Contains(d.Args[1], "$") || !isEdgeRuleURL(d.Args[1]) {
+			im.warn(d, "only redirects to absolute URLs without variables can be translated")
+		} else {
+			im.add(d, triggers, ERATRedirect, d.Args[1], d.Args[0])
+		}
+	case len(d.Args) == 2:
+		im.warn(d, "responses with a body can't be translated")
+	case code == 403:
+		im.add(d, triggers, ERATBlockRequest, "", "")
+	default:
+		im.add(d, triggers, ERATSetStatusCode, d.Args[0], "")
+	}
+}
+
+func (im *edgeRuleImporter) nginxRewrite(d configDirective, triggers []EdgeRuleTrigger) {
+	if len(d.Args) != 3 || (d.Args[2] != "redirect" && d.Args[2] != "permanent") {
+		im.warn(d, "only rewrites with the redirect or permanent flag can be translated")
+		return
+	}
+	if strings.Contains(d.Args[1], "$") || !isEdgeRuleURL(d.Args[1]) {
+		im.warn(d, "only redirects to absolute URLs without variables can be translated")
+		return
+	}
+	globs, ok := regexToGlobs(d.Args[0], false)
+	if !ok {
+		im.warn(d, "regular expression is too complex to translate")
+		return
+	}
+
+	code := "302"
+	if d.Args[2] == "permanent" {
+		code = "301"
+	}
+	im.add(d, append(append([]EdgeRuleTrigger{}, triggers...), urlTrigger(globs...)), ERATRedirect, d.Args[1], code)
+}
+
+// nginxDuration converts nginx time values like 30d, 1h30m or max into seconds.
+func nginxDuration(s string) (int64, bool) {
+	switch s {
+	case "max":
+		return 315360000, true // what nginx uses, ten years
+	case "epoch":
+		return 0, true
+	case "off":
+		return 0, false
+	}
+
+	units := map[byte]int64{'s': 1, 'm': 60, 'h': 3600, 'd': 86400, 'w': 604800, 'M': 2592000, 'y': 31536000}
+	var total int64
+	num := ""
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= '0' && c <= '9':
+			num += string(c)
+		case num != "" && units[c] != 0:
+			n, _ := strconv.ParseInt(num, 10, 64)
+			total += n * units[c]
+			num = ""
+		default:
+			return 0, false
+		}
+	}
+	if num != "" {
+		n, _ := strconv.ParseInt(num, 10, 64)
+		total += n
+	}
+	return total, s != ""
+}
+
+func parseNginxConfig(src string) ([]configDirective, error) {
+	type token struct {
+		text   string
+		line   int
+		quoted bool
+	}
+
+	toks := []token{}
+	line := 1
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '#':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case c == '{' || c == '}' || c == ';':
+			toks = append(toks, token{string(c), line, false})
+			i++
+		case c == '"' || c == '\'':
+			start := line
+			var b strings.Builder
+			i++
+			for i < len(src) && src[i] != c {
+				if src[i] == '\\' && i+1 < len(src) {
+					i++
+				}
+				if src[i] == '\n' {
+					line++
+				}
+				b.WriteByte(src[i])
+				i++
+			}
+			if i >= len(src) {
+				return nil, fmt.Errorf("line %d: unterminated string", start)
+			}
+			i++
+			toks = append(toks, token{b.String(), start, true})
+		default:
+			j := i
+			for j < len(src) && !strings.ContainsRune(" \t\r\n{};\"'", rune(src[j])) {
+				j++
+			}
+			toks = append(toks, token{src[i:j], line, false})
+			i = j
+		}
+	}
+
+	pos := 0
+	var block func(nested bool, open int) ([]configDirective, error)
+	block = func(nested bool, open int) ([]configDirective, error) {
+		ds := []configDirective{}
+		for {
+			if pos >= len(toks) {
+				if nested {
+					return nil, fmt.Errorf("line %d: unclosed block", open)
+				}
+				return ds, nil
+			}
+			t := toks[pos]
+			if !t.quoted && t.text == "}" {
+				if !nested {
+					return nil, fmt.Errorf("line %d: unexpected }", t.line)
+				}
+				pos++
+				return ds, nil
+			}
+
+			d := configDirective{Name: t.text, Line: t.line, Args: []string{}}
+			pos++
+			for {
+				if pos >= len(toks) {
+					return nil, fmt.Errorf("line %d: missing ; after %v", d.Line, d.Name)
+				}
+				a := toks[pos]
+				if !a.quoted && a.text == ";" {
+					pos++
+					break
+				}
+				if !a.quoted && a.text == "{" {
+					pos++
+					inner, err := block(true, a.line)
+					if err != nil {
+						return nil, err
+					}
+					d.Block, d.HasBlock = inner, true
+					break
+				}
+				if !a.quoted && a.text == "}" {
+					return nil, fmt.Errorf("line %d: missing ; after %v", d.Line, d.Name)
+				}
+				d.Args = append(d.Args, a.text)
+				pos++
+			}
+			ds = append(ds, d)
+		}
+	}
+
+	return block(false, 0)
+}
+
+// ImportHtaccessEdgeRules translates Apache .htaccess configuration into
+// EdgeRules. It understands RewriteRule with the R, F and G flags (and the
+// RewriteCond %{HTTPS} idiom for forcing SSL), Redirect, RedirectMatch,
+// Header set, ExpiresDefault, Order with Allow from and Deny from, and
+// Require all denied, inside <Files>, <FilesMatch>, <Location> and
+// <IfModule> sections. Other directives are reported as warnings.
+func ImportHtaccessEdgeRules(src string) ([]EdgeRule, []EdgeRuleImportWarning, error) {
+	directives, err := parseHtaccess(src)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	im := &edgeRuleImporter{source: "htaccess", rules: []EdgeRule{}, warnings: []EdgeRuleImportWarning{}}
+	im.htaccessBlock(directives, nil)
+	return im.rules, im.warnings, nil
+}
+
+var htaccessHTTPSOff = regexp.MustCompile(`^(?i)(%\{HTTPS\}\s+(off|!on|!=on)|%\{SERVER_PORT\}\s+(80|!443|!=443)|%\{REQUEST_SCHEME\}\s+(http|!https|!=https))$`)
+
+func (im *edgeRuleImporter) htaccessBlock(directives []configDirective, triggers []EdgeRuleTrigger) {
+	conds := []configDirective{}
+
+	// Allow and Deny are evaluated as a whole, in the Order of the section
+	order, allowed, denies, allowAdded := "deny,allow", []string{}, false, false
+	for _, d := range directives {
+		switch strings.ToLower(d.Name) {
+		case "order":
+			order = strings.ToLower(strings.Join(d.Args, ""))
+		case "allow":
+			if len(d.Args) >= 2 && strings.EqualFold(d.Args[0], "from") {
+				allowed = append(allowed, d.Args[1:]...)
+			}
+		case "deny":
+			denies = true
+		}
+	}
+
+	for _, d := range directives {
+		name := strings.ToLower(d.Name)
+
+		if name != "rewritecond" && name != "rewriterule" && len(conds) > 0 {
+			for _, c := range conds {
+				im.warn(c, "RewriteCond without RewriteRule")
+			}
+			conds = conds[:0]
+		}
+
+		switch name {
+		case "<ifmodule", "<ifdefine":
+			im.htaccessBlock(d.Block, triggers)
+		case "<files", "<filesmatch", "<location", "<locationmatch":
+			t, ok := htaccessSectionTrigger(name, d.Args)
+			if !ok {
+				im.warn(d, "section pattern is too complex to translate")
+				continue
+			}
+			im.htaccessBlock(d.Block, append(append([]EdgeRuleTrigger{}, triggers...), t))
+		case "rewriteengine", "rewritebase", "options", "expiresactive":
+			// nothing to translate
+		case "rewritecond":
+			conds = append(conds, d)
+		case "rewriterule":
+			im.htaccessRewriteRule(d, conds, triggers)
+			conds = conds[:0]
+		case "redirect", "redirectpermanent", "redirecttemp", "redirectmatch":
+			im.htaccessRedirect(d, name, triggers)
+		case "header":
+			args := d.Args
+			if len(args) > 0 && strings.EqualFold(args[0], "always") {
+				args = args[1:]
+			}
+			if len(args) != 3 || !strings.EqualFold(args[0], "set") {
+				im.warn(d, "only Header set can be translated")
+			} else if strings.Contains(args[2], "%{") {
+				im.warn(d, "header values with variables can't be translated")
+			} else {
+				im.add(d, triggers, ERATSetResponseHeader, args[1], args[2])
+			}
+		case "requestheader":
+			if len(d.Args) != 3 || !strings.EqualFold(d.Args[0], "set") {
+				im.warn(d, "only RequestHeader set can be translated")
+			} else {
+				im.add(d, triggers, ERATSetRequestHeader, d.Args[1], d.Args[2])
+			}
+		case "expiresdefault":
+			secs, ok := apacheDuration(strings.Join(d.Args, " "))
+			if !ok {
+				im.warn(d, "unsupported expiry time")
+				continue
+			}
+			im.add(d, triggers, ERATOverrideBrowserCacheTime, strconv.FormatInt(secs, 10), "")
+		case "require":
+			if len(d.Args) == 2 && strings.EqualFold(d.Args[0], "all") && strings.EqualFold(d.Args[1], "denied") {
+				im.add(d, triggers, ERATBlockRequest, "", "")
+			} else {
+				im.warn(d, "only Require all denied can be translated")
+			}
+		case "order":
+			if order != "deny,allow" && order != "allow,deny" {
+				im.warn(d, "unexpected arguments")
+			}
+		case "allow":
+			switch {
+			case len(d.Args) < 2 || !strings.EqualFold(d.Args[0], "from"):
+				im.warn(d, "unexpected arguments")
+			case order == "allow,deny":
+				// everyone not allowed is denied
+				if !allowAdded && !htaccessAllowsAll(allowed) {
+					im.denyRemoteIPs(d, triggers, []string{"all"}, allowed)
+				}
+				allowAdded = true
+			case !denies:
+				im.warn(d, "Allow without Deny in the same section can't be translated")
+			}
+		case "deny":
+			switch {
+			case len(d.Args) < 2 || !strings.EqualFold(d.Args[0], "from"):
+				im.warn(d, "unexpected arguments")
+			case order == "allow,deny":
+				// Deny overrides Allow
+				im.denyRemoteIPs(d, triggers, d.Args[1:], nil)
+			default:
+				im.denyRemoteIPs(d, triggers, d.Args[1:], allowed)
+			}
+		default:
+			im.warn(d, "no edge rule equivalent")
+		}
+	}
+
+	for _, c := range conds {
+		im.warn(c, "RewriteCond without RewriteRule")
+	}
+}
+
+func htaccessAllowsAll(allowed []string) bool {
+	for _, a := range allowed {
+		if strings.EqualFold(a, "all") {
+			return true
+		}
+	}
+	return false
+}
+
+func htaccessSectionTrigger(name string, args []string) (EdgeRuleTrigger, bool) {
+	if len(args) == 2 && args[0] == "~" {
+		name, args = name+"match", args[1:]
+	}
+	if len(args) != 1 {
+		return EdgeRuleTrigger{}, false
+	}
+
+	switch name {
+	case "<files":
+		if strings.ContainsAny(args[0], "?[") {
+			return EdgeRuleTrigger{}, false
+		}
+		return urlTrigger(collapseGlob("*/" + args[0])), true
+	case "<location":
+		return urlTrigger(globPrefix(args[0])), true
+	}
+
+	globs, ok := regexToGlobs(args[0], false)
+	if !ok {
+		return EdgeRuleTrigger{}, false
+	}
+	return urlTrigger(globs...), true
+}
+
+func (im *edgeRuleImporter) htaccessRewriteRule(d configDirective, conds []configDirective, triggers []EdgeRuleTrigger) {
+	if len(d.Args) < 2 || len(d.Args) > 3 {
+		im.warn(d, "unexpected arguments")
+		return
+	}
+
+	flags := map[string]string{}
+	if len(d.Args) == 3 {
+		for _, f := range strings.Split(strings.Trim(d.Args[2], "[]"), ",") {
+			kv := strings.SplitN(strings.TrimSpace(f), "=", 2)
+			v := ""
+			if len(kv) == 2 {
+				v = kv[1]
+			}
+			flags[strings.ToUpper(kv[0])] = v
+		}
+	}
+
+	forceSSL := false
+	for _, c := range conds {
+		if htaccessHTTPSOff.MatchString(strings.Join(c.Args, " ")) {
+			forceSSL = true
+			continue
+		}
+		im.warn(d, "RewriteCond "+strings.Join(c.Args, " ")+" can't be translated")
+		return
+	}
+
+	globs, ok := regexToGlobs(d.Args[0], true)
+	if !ok {
+		im.warn(d, "regular expression is too complex to translate")
+		return
+	}
+	t := append(append([]EdgeRuleTrigger{}, triggers...), urlTrigger(globs...))
+
+	_, redirect := flags["R"]
+	if _, ok := flags["REDIRECT"]; ok {
+		redirect = true
+	}
+	_, forbidden := flags["F"]
+	_, gone := flags["G"]
+
+	switch {
+	case forbidden:
+		im.add(d, t, ERATBlockRequest, "", "")
+	case gone:
+		im.add(d, t, ERATSetStatusCode, "410", "")
+	case redirect && forceSSL && strings.HasPrefix(strings.ToLower(d.Args[1]), "https://%{http_host}"):
+		im.add(d, triggers, ERATForceSSL, "", "")
+	case forceSSL:
+		im.warn(d, "RewriteCond on HTTPS is only translated for redirects to https://%{HTTP_HOST}")
+	case !redirect:
+		im.warn(d, "internal rewrites can't be translated")
+	case strings.ContainsAny(d.Args[1], "$%") || !isEdgeRuleURL(d.Args[1]):
+		im.warn(d, "only redirects to absolute URLs without back-references can be translated")
+	default:
+		code := flags["R"]
+		if code == "" {
+			code = flags["REDIRECT"]
+		}
+		switch strings.ToLower(code) {
+		case "", "temp":
+			code = "302"
+		case "permanent":
+			code = "301"
+		}
+		im.add(d, t, ERATRedirect, d.Args[1], code)
+	}
+}
+
+func (im *edgeRuleImporter) htaccessRedirect(d configDirective, name string, triggers []EdgeRuleTrigger) {
+	args := d.Args
+	code := "302"
+	switch name {
+	case "redirectpermanent":
+		code = "301"
+	case "redirect", "redirectmatch":
+		if len(args) == 3 {
+			switch strings.ToLower(args[0]) {
+			case "permanent":
+				code = "301"
+			case "temp":
+				code = "302"
+			case "seeother":
+				code = "303"
+			default:
+				code = args[0]
+			}
+			args = args[1:]
+		}
+	}
+
+	if len(args) != 2 {
+		im.warn(d, "unexpected arguments")
+		return
+	}
+	if strings.Contains(args[1], "$") || !isEdgeRuleURL(args[1]) {
+		im.warn(d, "only redirects to absolute URLs without back-references can be translated")
+		return
+	}
+
+	// Apache appends the rest of the path to the target, which edge rules
+	// can't do, so only the path itself is redirected
+	pattern := []string{globExact(args[0])}
+	if strings.HasSuffix(args[0], "/") {
+		pattern = append(pattern, globExact(strings.TrimSuffix(args[0], "/")))
+	}
+	if name != "redirectmatch" {
+		im.warn(d, "only the path itself is redirected, edge rules can't append the rest of the path to the target")
+	} else {
+		globs, ok := regexToGlobs(args[0], false)
+		if !ok {
+			im.warn(d, "regular expression is too complex to translate")
+			return
+		}
+		pattern = globs
+	}
+
+	im.add(d, append(append([]EdgeRuleTrigger{}, triggers...), urlTrigger(pattern...)), ERATRedirect, args[1], code)
+}
+
+// apacheDuration converts mod_expires times like "access plus 1 month" into
+// seconds. Only times relative to the access time are supported.
+func apacheDuration(s string) (int64, bool) {
+	fields := strings.Fields(strings.ToLower(strings.Trim(s, "\"")))
+	if len(fields) > 0 && (fields[0] == "access" || fields[0] == "now") {
+		fields = fields[1:]
+	} else {
+		return 0, false
+	}
+	if len(fields) > 0 && fields[0] == "plus" {
+		fields = fields[1:]
+	}
+	if len(fields) == 0 || len(fields)%2 != 0 {
+		return 0, false
+	}
+
+	units := map[string]int64{"year": 31536000, "month": 2592000, "week": 604800, "day": 86400, "hour": 3600, "minute": 60, "second": 1}
+	var total int64
+	for i := 0; i < len(fields); i += 2 {
+		n, err := strconv.ParseInt(fields[i], 10, 64)
+		u, ok := units[strings.TrimSuffix(fields[i+1], "s")]
+		if err != nil || !ok {
+			return 0, false
+		}
+		total += n * u
+	}
+	return total, true
+}
+
+// parseHtaccess splits .htaccess content into directives, nesting the
+// contents of <Section> blocks.
+func parseHtaccess(src string) ([]configDirective, error) {
+	type frame struct {
+		d   configDirective
+		out []configDirective
+	}
+	stack := []frame{{out: []configDirective{}}}
+
+	lines := strings.Split(strings.ReplaceAll(src, "\\\n", " "), "\n")
+	for i, raw := range lines {
+		line := strings.TrimSpace(raw)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := splitHtaccessLine(line)
+		top := &stack[len(stack)-1]
+
+		switch {
+		case strings.HasPrefix(line, "</"):
+			name := strings.ToLower(strings.Trim(line, "</>"))
+			if len(stack) == 1 || strings.ToLower(strings.TrimPrefix(stack[len(stack)-1].d.Name, "<")) != name {
+				return nil, fmt.Errorf("line %d: unexpected %v", i+1, line)
+			}
+			f := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			f.d.Block, f.d.HasBlock = f.out, true
+			stack[len(stack)-1].out = append(stack[len(stack)-1].out, f.d)
+		case strings.HasPrefix(line, "<"):
+			fields[len(fields)-1] = strings.TrimSuffix(fields[len(fields)-1], ">")
+			if fields[len(fields)-1] == "" {
+				fields = fields[:len(fields)-1]
+			}
+			d := configDirective{Name: fields[0], Args: fields[1:], Line: i + 1}
+			stack = append(stack, frame{d: d, out: []configDirective{}})
+		default:
+			top.out = append(top.out, configDirective{Name: fields[0], Args: fields[1:], Line: i + 1})
+		}
+	}
+
+	if len(stack) != 1 {
+		f := stack[len(stack)-1]
+		return nil, fmt.Errorf("line %d: unclosed section %v", f.d.Line, f.d.Name)
+	}
+	return stack[0].out, nil
+}
+
+func splitHtaccessLine(line string) []string {
+	fields := []string{}
+	var b strings.Builder
+	inQuote := false
+	has := false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '\\' && inQuote && i+1 < len(line) && line[i+1] == '"':
+			b.WriteByte('"')
+			i++
+		case c == '"':
+			inQuote = !inQuote
+			has = true
+		case (c == ' ' || c == '\t') && !inQuote:
+			if has {
+				fields = append(fields, b.String())
+				b.Reset()
+				has = false
+			}
+		default:
+			b.WriteByte(c)
+			has = true
+		}
+	}
+	if has {
+		fields = append(fields, b.String())
+	}
+	return fields
+}
+
+// globPrefix turns a path prefix into a URL pattern.
+func globPrefix(path string) string {
+	return collapseGlob("*" + path + "*")
+}
+
+// globExact turns an exact path into a URL pattern.
+func globExact(path string) string {
+	return collapseGlob("*" + path)
+}
+
+func collapseGlob(g string) string {
+	for strings.Contains(g, "**") {
+		g = strings.ReplaceAll(g, "**", "*")
+	}
+	return g
+}
+
+// regexToGlobs translates simple regular expressions into wildcard patterns.
+// It supports anchors, escaped literals, .* and .+ and a single level of
+// alternation between literals, like \.(jpg|png)$. Paths in
+// .htaccess rules are relative, so if relative is set an anchored pattern
+// gets a slash in front.
+func regexToGlobs(re string, relative bool) ([]string, bool) {
+	anchored := strings.HasPrefix(re, "^")
+	re = strings.TrimPrefix(re, "^")
+	ended := strings.HasSuffix(re, "$") && !strings.HasSuffix(re, "\\$")
+	re = strings.TrimSuffix(re, "$")
+
+	globs := []string{""}
+	appendAll := func(parts ...string) {
+		next := []string{}
+		for _, g := range globs {
+			for _, p := range parts {
+				next = append(next, g+p)
+			}
+		}
+		globs = next
+	}
+
+	// literal consumes a literal character or escape at position i
+	literal := func(s string, i int) (string, int, bool) {
+		c := s[i]
+		if c == '\\' {
+			if i+1 >= len(s) || strings.ContainsRune("dDwWsSbBnrt0123456789", rune(s[i+1])) {
+				return "", 0, false
+			}
+			return string(s[i+1]), i + 2, true
+		}
+		if strings.ContainsRune(".*+?[](){}|^$", rune(c)) {
+			return "", 0, false
+		}
+		return string(c), i + 1, true
+	}
+
+	for i := 0; i < len(re); {
+		switch {
+		case strings.HasPrefix(re[i:], ".*") || strings.HasPrefix(re[i:], ".+"):
+			appendAll("*")
+			i += 2
+		case strings.HasPrefix(re[i:], "(.*)") || strings.HasPrefix(re[i:], "(.+)"):
+			appendAll("*")
+			i += 4
+		case re[i] == '(':
+			end := strings.IndexByte(re[i:], ')')
+			if end < 0 || len(globs) > 1 {
+				return nil, false
+			}
+			group := strings.TrimPrefix(re[i+1:i+end], "?:")
+			alts := []string{}
+			for _, alt := range strings.Split(group, "|") {
+				lit := ""
+				for j := 0; j < len(alt); {
+					s, n, ok := literal(alt, j)
+					if !ok {
+						return nil, false
+					}
+					lit += s
+					j = n
+				}
+				alts = append(alts, lit)
+			}
+			appendAll(alts...)
+			i += end + 1
+		default:
+			s, n, ok := literal(re, i)
+			if !ok {
+				return nil, false
+			}
+			if n < len(re) && (re[n] == '?' || re[n] == '*' || re[n] == '+' || re[n] == '{') {
+				return nil, false
+			}
+			appendAll(s)
+			i = n
+		}
+	}
+
+	for i, g := range globs {
+		if anchored && relative && !strings.HasPrefix(g, "/") {
+			g = "/" + g
+		}
+		g = "*" + g
+		if !ended {
+			g += "*"
+		}
+		globs[i] = collapseGlob(g)
+	}
+	return globs, true
+}
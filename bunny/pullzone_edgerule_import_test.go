@@ -0,0 +1,215 @@
+// Copyright (c) 2021 Jan Koppe
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package bunny
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestImportNginxEdgeRules(t *testing.T) {
+	src := `
+	server {
+		listen 80;
+		return 301 https://$host$request_uri;
+	}
+	server {
+		server_name example.com .example.org;
+		add_header X-Frame-Options DENY always;
+		location /static/ {
+			expires 30d;
+			location ~* \.(css|js)$ {
+				add_header Cache-Control "public, max-age=31536000";
+			}
+		}
+		location /assets/ {
+			add_header Cache-Control "public, immutable";
+		}
+		location = /old {
+			return 301 https://example.com/new;
+		}
+		location /admin {
+			deny all;
+		}
+		rewrite ^/blog/(.*)$ https://blog.example.com permanent;
+		location @fallback {
+			proxy_pass http://backend;
+		}
+		if ($http_user_agent ~ bot) {
+			return 403;
+		}
+	}
+	`
+
+	rules, warnings, err := ImportNginxEdgeRules(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type rule struct {
+		action   EdgeRuleActionType
+		p1, p2   string
+		patterns [][]string
+	}
+	hosts := []string{"*://example.com/*", "*://example.org/*", "*://*.example.org/*"}
+	want := []rule{
+		{ERATForceSSL, "", "", [][]string{{"*"}}},
+		// nginx doesn't inherit the header into /assets/, which sets its own
+		{ERATSetResponseHeader, "X-Frame-Options", "DENY", [][]string{hosts, {"*/assets/*"}}},
+		{ERATOverrideBrowserCacheTime, "2592000", "", [][]string{hosts, {"*/static/*"}}},
+		{ERATSetResponseHeader, "Cache-Control", "public, max-age=31536000", [][]string{hosts, {"*/static/*"}, {"*.css", "*.js"}}},
+		{ERATSetResponseHeader, "Cache-Control", "public, immutable", [][]string{hosts, {"*/assets/*"}}},
+		{ERATRedirect, "https://example.com/new", "301", [][]string{hosts, {"*/old"}}},
+		{ERATBlockRequest, "", "", [][]string{hosts, {"*/admin*"}}},
+		{ERATRedirect, "https://blog.example.com", "301", [][]string{hosts, {"*/blog/*"}}},
+	}
+
+	got := []rule{}
+	for _, r := range rules {
+		patterns := [][]string{}
+		for _, t := range r.Triggers {
+			patterns = append(patterns, t.PatternMatches)
+		}
+		got = append(got, rule{r.ActionType, r.ActionParameter1, r.ActionParameter2, patterns})
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected rules:\n got: %+v\nwant: %+v", got, want)
+	}
+	if mt := rules[1].Triggers[1].PatternMatchingType; mt != ERTPMTMatchNone {
+		t.Errorf("expected the header to exclude /assets/, got %v", mt)
+	}
+
+	// the server without server_name, listen, the css/js location which
+	// doesn't inherit X-Frame-Options, the named location and the if block
+	if len(warnings) != 5 {
+		t.Errorf("expected 5 warnings, got %v", warnings)
+	}
+
+	if _, _, err := ImportNginxEdgeRules("location / { expires 1d;"); err == nil {
+		t.Error("expected error for unclosed block")
+	}
+}
+
+func TestImportHtaccessEdgeRules(t *testing.T) {
+	src := `
+	RewriteEngine On
+	RewriteCond %{HTTPS} off
+	RewriteRule ^(.*)$ https://%{HTTP_HOST}/$1 [R=301,L]
+
+	RewriteRule ^private/ - [F]
+	RewriteRule ^app/(.*)$ index.php?route=$1 [L,QSA]
+	Redirect permanent /old https://example.com/new
+
+	<IfModule mod_headers.c>
+		<FilesMatch "\.(png|jpe?g)$">
+			Header set Cache-Control "max-age=86400"
+		</FilesMatch>
+		Header always set X-Content-Type-Options nosniff
+	</IfModule>
+
+	ExpiresDefault "access plus 1 week 2 days"
+	`
+
+	rules, warnings, err := ImportHtaccessEdgeRules(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actions := []EdgeRuleActionType{}
+	for _, r := range rules {
+		actions = append(actions, r.ActionType)
+	}
+	want := []EdgeRuleActionType{ERATForceSSL, ERATBlockRequest, ERATRedirect, ERATSetResponseHeader, ERATOverrideBrowserCacheTime}
+	if !reflect.DeepEqual(actions, want) {
+		t.Errorf("unexpected actions %v, want %v", actions, want)
+	}
+
+	if p := rules[1].Triggers[0].PatternMatches; !reflect.DeepEqual(p, []string{"*/private/*"}) {
+		t.Errorf("unexpected patterns for RewriteRule %v", p)
+	}
+	if p := rules[2].Triggers[0].PatternMatches; !reflect.DeepEqual(p, []string{"*/old"}) {
+		t.Errorf("unexpected patterns for Redirect %v", p)
+	}
+	if p := rules[4].ActionParameter1; p != "777600" {
+		t.Errorf("unexpected expiry %v", p)
+	}
+
+	// the internal rewrite, the Redirect not appending the rest of the
+	// path and the FilesMatch with jpe?g
+	if len(warnings) != 3 {
+		t.Errorf("expected 3 warnings, got %v", warnings)
+	}
+}
+
+func TestImportEdgeRulesAccessLists(t *testing.T) {
+	rules, warnings, err := ImportNginxEdgeRules(`
+	location /admin/ {
+		allow 10.0.0.1;
+		allow 192.168.0.0/24;
+		deny all;
+	}
+	location /internal/ {
+		allow all;
+		deny all;
+	}
+	gzip off;
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 1 || len(rules[0].Triggers) != 2 {
+		t.Fatalf("unexpected rules %+v", rules)
+	}
+	allowed := rules[0].Triggers[1]
+	if allowed.Type != ERTTRemoteIP || allowed.PatternMatchingType != ERTPMTMatchNone || !reflect.DeepEqual(allowed.PatternMatches, []string{"10.0.0.1", "192.168.0.0/24"}) {
+		t.Errorf("allowed addresses are not exempt from deny all: %+v", allowed)
+	}
+	// the deny after allow all and gzip off
+	if len(warnings) != 2 {
+		t.Errorf("expected 2 warnings, got %v", warnings)
+	}
+
+	rules, warnings, err = ImportHtaccessEdgeRules(`
+	<Files *.php>
+		Order Deny,Allow
+		Deny from all
+		Allow from 10.0.0.1
+	</Files>
+	<Files secret.txt>
+		Order Allow,Deny
+		Allow from 10.0.0.2
+	</Files>
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 || len(warnings) != 0 {
+		t.Fatalf("unexpected rules %+v, warnings %v", rules, warnings)
+	}
+	if p := rules[0].Triggers[0].PatternMatches; !reflect.DeepEqual(p, []string{"*/*.php"}) {
+		t.Errorf("unexpected patterns for <Files *.php> %v", p)
+	}
+	for _, r := range rules {
+		allowed := r.Triggers[len(r.Triggers)-1]
+		if r.ActionType != ERATBlockRequest || allowed.Type != ERTTRemoteIP || allowed.PatternMatchingType != ERTPMTMatchNone {
+			t.Errorf("allowed addresses are not exempt from the block: %+v", r)
+		}
+	}
+}
@@ -0,0 +1,556 @@
+// Copyright (c) 2021 Jan Koppe
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package bunny
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type EdgeRuleLintSeverity int
+
+const (
+	LintInfo    EdgeRuleLintSeverity = 0
+	LintWarning EdgeRuleLintSeverity = 1
+	LintError   EdgeRuleLintSeverity = 2
+)
+
+func (s EdgeRuleLintSeverity) String() string {
+	switch s {
+	case LintInfo:
+		return "info"
+	case LintWarning:
+		return "warning"
+	case LintError:
+		return "error"
+	}
+	return fmt.Sprintf("EdgeRuleLintSeverity(%d)", int(s))
+}
+
+// names of the checks performed by LintEdgeRules
+const (
+	LintCheckDuplicate        = "duplicate"
+	LintCheckConflict         = "conflict"
+	LintCheckShadowed         = "shadowed"
+	LintCheckUnreachable      = "unreachable"
+	LintCheckEmptyPattern     = "empty-pattern"
+	LintCheckInvalidParameter = "invalid-parameter"
+)
+
+// EdgeRuleLintFinding describes a single problem found by LintEdgeRules.
+// Rules holds the indices of the involved rules in the linted slice, the
+// first one being the rule the finding is about.
+type EdgeRuleLintFinding struct {
+	Severity EdgeRuleLintSeverity
+	Check    string
+	Rules    []int
+	Message  string
+}
+
+func (f EdgeRuleLintFinding) String() string {
+	return fmt.Sprintf("%v: rule %d: %s (%s)", f.Severity, f.Rules[0], f.Message, f.Check)
+}
+
+// LintEdgeRules checks the EdgeRules of a PullZone for duplicates, conflicting
+// or shadowed rules, rules that can never match and invalid parameters.
+//
+// Overlap between rules is decided on the trigger patterns alone, so the
+// results are a best guess: triggers on different subjects are assumed to
+// be able to match the same request, and rules matching none of their
+// triggers are assumed to overlap with everything.
+func LintEdgeRules(rules []EdgeRule) []EdgeRuleLintFinding {
+	findings := []EdgeRuleLintFinding{}
+	add := func(sev EdgeRuleLintSeverity, check string, msg string, idx ...int) {
+		findings = append(findings, EdgeRuleLintFinding{sev, check, idx, msg})
+	}
+
+	for i, r := range rules {
+		for _, f := range lintEdgeRuleParameters(r) {
+			add(LintError, LintCheckInvalidParameter, f, i)
+		}
+
+		empty := false
+		for _, t := range r.Triggers {
+			if len(t.PatternMatches) == 0 {
+				add(LintError, LintCheckEmptyPattern, fmt.Sprintf("%v trigger has no patterns", edgeRuleTriggerLabel(t.Type)), i)
+				empty = true
+				continue
+			}
+			for _, p := range t.PatternMatches {
+				if strings.TrimSpace(p) == "" {
+					add(LintError, LintCheckEmptyPattern, fmt.Sprintf("%v trigger has an empty pattern", edgeRuleTriggerLabel(t.Type)), i)
+					empty = true
+					break
+				}
+			}
+		}
+
+		if len(r.Triggers) == 0 {
+			add(LintWarning, LintCheckUnreachable, "rule has no triggers, bunny never applies it (use always to match every request)", i)
+		} else if !empty && !edgeRuleSatisfiable(r) {
+			add(LintWarning, LintCheckUnreachable, "triggers contradict each other, rule will never match", i)
+		}
+	}
+
+	for i := range rules {
+		for j := i + 1; j < len(rules); j++ {
+			a, b := rules[i], rules[j]
+
+			if edgeRulesEqual(a, b) {
+				switch {
+				case a.Enabled && b.Enabled:
+					add(LintWarning, LintCheckDuplicate, fmt.Sprintf("duplicate of rule %d", i), j, i)
+				case !b.Enabled:
+					add(LintInfo, LintCheckDuplicate, fmt.Sprintf("disabled duplicate of rule %d", i), j, i)
+				default:
+					add(LintInfo, LintCheckDuplicate, fmt.Sprintf("disabled duplicate of rule %d", j), i, j)
+				}
+				continue
+			}
+
+			if !a.Enabled || !b.Enabled || !edgeRulesOverlap(a, b) {
+				continue
+			}
+
+			sev, why := edgeRuleActionConflict(a, b)
+			if why == "" {
+				continue
+			}
+
+			switch {
+			case edgeRuleCovers(a, b):
+				add(sev, LintCheckShadowed, fmt.Sprintf("shadowed by rule %d: %s", i, why), j, i)
+			case edgeRuleCovers(b, a):
+				add(sev, LintCheckShadowed, fmt.Sprintf("shadowed by rule %d: %s", j, why), i, j)
+			default:
+				add(sev, LintCheckConflict, fmt.Sprintf("overlaps with rule %d: %s", j, why), i, j)
+			}
+		}
+	}
+
+	sort.SliceStable(findings, func(x, y int) bool {
+		return findings[x].Rules[0] < findings[y].Rules[0]
+	})
+
+	return findings
+}
+
+func edgeRuleActionLabel(t EdgeRuleActionType) string {
+	return formatEdgeRuleName(edgeRuleActionNames[t], int32(t))
+}
+
+func edgeRuleTriggerLabel(t EdgeRuleTriggerType) string {
+	return formatEdgeRuleName(edgeRuleTriggerNames[t], int32(t))
+}
+
+// edgeRulesEqual compares two rules, ignoring Guid, Description and Enabled.
+func edgeRulesEqual(a, b EdgeRule) bool {
+	if a.ActionType != b.ActionType || a.ActionParameter1 != b.ActionParameter1 ||
+		a.ActionParameter2 != b.ActionParameter2 || a.TriggerMatchingType != b.TriggerMatchingType ||
+		len(a.Triggers) != len(b.Triggers) {
+		return false
+	}
+	for i := range a.Triggers {
+		ta, tb := a.Triggers[i], b.Triggers[i]
+		if ta.Type != tb.Type || ta.PatternMatchingType != tb.PatternMatchingType ||
+			!strings.EqualFold(ta.Parameter1, tb.Parameter1) ||
+			strings.Join(ta.PatternMatches, "\n") != strings.Join(tb.PatternMatches, "\n") {
+			return false
+		}
+	}
+	return true
+}
+
+// edgeRuleActionConflict returns a reason if both actions can't sensibly be
+// applied to the same request.
+func edgeRuleActionConflict(a, b EdgeRule) (EdgeRuleLintSeverity, string) {
+	if a.ActionType == ERATRedirect {
+		a, b = b, a
+	}
+	if a.ActionType == ERATForceSSL && b.ActionType == ERATRedirect &&
+		strings.HasPrefix(strings.ToLower(b.ActionParameter1), "http://") {
+		return LintError, "force-ssl combined with a redirect to http:// causes a redirect loop"
+	}
+
+	if (a.ActionType == ERATEnableTokenAuthentication && b.ActionType == ERATDisableTokenAuthentication) ||
+		(a.ActionType == ERATDisableTokenAuthentication && b.ActionType == ERATEnableTokenAuthentication) {
+		return LintWarning, "token authentication is both enabled and disabled"
+	}
+
+	if a.ActionType != b.ActionType {
+		return LintInfo, ""
+	}
+
+	switch a.ActionType {
+	case ERATOverrideCacheTime, ERATOverrideCacheTimePublic, ERATOriginURL, ERATRedirect:
+		if a.ActionParameter1 != b.ActionParameter1 || a.ActionParameter2 != b.ActionParameter2 {
+			return LintWarning, fmt.Sprintf("both rules %v with different parameters", edgeRuleActionLabel(a.ActionType))
+		}
+	case ERATSetResponseHeader, ERATSetRequestHeader:
+		if strings.EqualFold(a.ActionParameter1, b.ActionParameter1) && a.ActionParameter2 != b.ActionParameter2 {
+			return LintWarning, fmt.Sprintf("both rules set header %v to different values", a.ActionParameter1)
+		}
+	}
+
+	return LintInfo, ""
+}
+
+// edgeRuleConditions returns the alternatives under which a rule matches. Each
+// alternative is a list of triggers that all have to match.
+func edgeRuleConditions(r EdgeRule) [][]EdgeRuleTrigger {
+	if r.TriggerMatchingType == ERTMTMatchAll {
+		return [][]EdgeRuleTrigger{r.Triggers}
+	}
+	alts := make([][]EdgeRuleTrigger, len(r.Triggers))
+	for i, t := range r.Triggers {
+		alts[i] = []EdgeRuleTrigger{t}
+	}
+	return alts
+}
+
+func sameTriggerSubject(a, b EdgeRuleTrigger) bool {
+	return a.Type == b.Type && strings.EqualFold(a.Parameter1, b.Parameter1)
+}
+
+func edgeRuleSatisfiable(r EdgeRule) bool {
+	if r.TriggerMatchingType == ERTMTMatchNone {
+		return true
+	}
+	for _, alt := range edgeRuleConditions(r) {
+		if triggersCompatible(alt, alt) {
+			return true
+		}
+	}
+	return false
+}
+
+func edgeRulesOverlap(a, b EdgeRule) bool {
+	if len(a.Triggers) == 0 || len(b.Triggers) == 0 {
+		return false
+	}
+	if a.TriggerMatchingType == ERTMTMatchNone || b.TriggerMatchingType == ERTMTMatchNone {
+		return true
+	}
+	for _, altA := range edgeRuleConditions(a) {
+		for _, altB := range edgeRuleConditions(b) {
+			if triggersCompatible(altA, altB) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// triggersCompatible reports whether a request could satisfy all triggers of
+// both lists at once.
+func triggersCompatible(as, bs []EdgeRuleTrigger) bool {
+	for _, ta := range as {
+		for _, tb := range bs {
+			if sameTriggerSubject(ta, tb) && !triggerPatternsCompatible(ta, tb) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func triggerPatternsCompatible(a, b EdgeRuleTrigger) bool {
+	if a.PatternMatchingType == ERTPMTMatchNone || b.PatternMatchingType == ERTPMTMatchNone {
+		return true
+	}
+	// a single value has to match one pattern of an "any" trigger and every
+	// pattern of an "all" trigger, so collect the patterns it has to match
+	// and check whether any combination of them can overlap.
+	required := []string{}
+	options := [][]string{}
+	for _, t := range []EdgeRuleTrigger{a, b} {
+		if t.PatternMatchingType == ERTPMTMatchAll {
+			required = append(required, t.PatternMatches...)
+		} else {
+			options = append(options, t.PatternMatches)
+		}
+	}
+	for i := range required {
+		for j := i + 1; j < len(required); j++ {
+			if !globsOverlap(required[i], required[j]) {
+				return false
+			}
+		}
+	}
+
+	var try func(n int, chosen []string) bool
+	try = func(n int, chosen []string) bool {
+		if n == len(options) {
+			return true
+		}
+		for _, p := range options[n] {
+			ok := true
+			for _, c := range chosen {
+				if !globsOverlap(p, c) {
+					ok = false
+					break
+				}
+			}
+			if ok && try(n+1, append(chosen, p)) {
+				return true
+			}
+		}
+		return false
+	}
+	return try(0, required)
+}
+
+// edgeRuleCovers reports whether every request matched by b is matched by a.
+// It only handles simple cases and errs on the side of returning false.
+func edgeRuleCovers(a, b EdgeRule) bool {
+	if len(a.Triggers) == 0 || len(b.Triggers) == 0 ||
+		a.TriggerMatchingType == ERTMTMatchNone || b.TriggerMatchingType == ERTMTMatchNone {
+		return false
+	}
+	// every alternative of b has to be covered by some alternative of a
+	for _, altB := range edgeRuleConditions(b) {
+		covered := false
+		for _, altA := range edgeRuleConditions(a) {
+			if alternativeCovers(altA, altB) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			return false
+		}
+	}
+	return true
+}
+
+func alternativeCovers(as, bs []EdgeRuleTrigger) bool {
+	for _, ta := range as {
+		if ta.PatternMatchingType == ERTPMTMatchNone {
+			return false
+		}
+		found := false
+		for _, tb := range bs {
+			if sameTriggerSubject(ta, tb) && tb.PatternMatchingType != ERTPMTMatchNone && triggerCovers(ta, tb) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func triggerCovers(a, b EdgeRuleTrigger) bool {
+	if a.PatternMatchingType == ERTPMTMatchAll {
+		// every pattern of a has to cover some pattern b matches
+		for _, pa := range a.PatternMatches {
+			for _, pb := range b.PatternMatches {
+				if !globCovers(pa, pb) {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	if b.PatternMatchingType == ERTPMTMatchAll {
+		for _, pa := range a.PatternMatches {
+			for _, pb := range b.PatternMatches {
+				if globCovers(pa, pb) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	for _, pb := range b.PatternMatches {
+		ok := false
+		for _, pa := range a.PatternMatches {
+			if globCovers(pa, pb) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// globsOverlap reports whether some string is matched by both wildcard
+// patterns. Bunny patterns only know * and match case insensitively.
+func globsOverlap(p, q string) bool {
+	p, q = strings.ToLower(p), strings.ToLower(q)
+	memo := map[[2]int]bool{}
+	var f func(i, j int) bool
+	f = func(i, j int) bool {
+		key := [2]int{i, j}
+		if v, ok := memo[key]; ok {
+			return v
+		}
+		res := false
+		switch {
+		case i == len(p) && j == len(q):
+			res = true
+		case i < len(p) && p[i] == '*':
+			res = f(i+1, j) || (j < len(q) && f(i, j+1))
+		case j < len(q) && q[j] == '*':
+			res = f(i, j+1) || (i < len(p) && f(i+1, j))
+		case i < len(p) && j < len(q):
+			res = p[i] == q[j] && f(i+1, j+1)
+		}
+		memo[key] = res
+		return res
+	}
+	return f(0, 0)
+}
+
+// globCovers reports whether every string matched by q is matched by p.
+// Wildcards in q have to be matched by wildcards in p.
+func globCovers(p, q string) bool {
+	p, q = strings.ToLower(p), strings.ToLower(q)
+	memo := map[[2]int]bool{}
+	var f func(i, j int) bool
+	f = func(i, j int) bool {
+		key := [2]int{i, j}
+		if v, ok := memo[key]; ok {
+			return v
+		}
+		res := false
+		switch {
+		case i == len(p):
+			res = j == len(q)
+		case p[i] == '*':
+			res = f(i+1, j) || (j < len(q) && f(i, j+1))
+		case j < len(q) && q[j] != '*':
+			res = p[i] == q[j] && f(i+1, j+1)
+		}
+		memo[key] = res
+		return res
+	}
+	return f(0, 0)
+}
+
+func lintEdgeRuleParameters(r EdgeRule) []string {
+	problems := []string{}
+	name := edgeRuleActionLabel(r.ActionType)
+
+	switch r.ActionType {
+	case ERATOverrideCacheTime, ERATOverrideCacheTimePublic:
+		if n, err := strconv.Atoi(r.ActionParameter1); err != nil || n < 0 {
+			problems = append(problems, fmt.Sprintf("%v needs a non-negative number of seconds, got %q", name, r.ActionParameter1))
+		}
+	case ERATRedirect:
+		if !isEdgeRuleURL(r.ActionParameter1) {
+			problems = append(problems, fmt.Sprintf("%v needs an absolute http(s) URL, got %q", name, r.ActionParameter1))
+		}
+		switch r.ActionParameter2 {
+		case "", "301", "302", "307", "308":
+		default:
+			problems = append(problems, fmt.Sprintf("%v status code must be 301, 302, 307 or 308, got %q", name, r.ActionParameter2))
+		}
+	case ERATOriginURL:
+		if !isEdgeRuleURL(r.ActionParameter1) {
+			problems = append(problems, fmt.Sprintf("%v needs an absolute http(s) URL, got %q", name, r.ActionParameter1))
+		}
+	case ERATSetResponseHeader, ERATSetRequestHeader:
+		if !isHeaderName(r.ActionParameter1) {
+			problems = append(problems, fmt.Sprintf("%v needs a valid header name, got %q", name, r.ActionParameter1))
+		}
+	case ERATSetStatusCode:
+		if n, err := strconv.Atoi(r.ActionParameter1); err != nil || n < 100 || n > 599 {
+			problems = append(problems, fmt.Sprintf("%v needs a HTTP status code, got %q", name, r.ActionParameter1))
+		}
+	}
+
+	for _, t := range r.Triggers {
+		tname := edgeRuleTriggerLabel(t.Type)
+		switch t.Type {
+		case ERTTRequestHeader, ERTTResponseHeader:
+			if !isHeaderName(t.Parameter1) {
+				problems = append(problems, fmt.Sprintf("%v trigger needs a valid header name, got %q", tname, t.Parameter1))
+			}
+		case ERTTCookieValue:
+			if t.Parameter1 == "" {
+				problems = append(problems, fmt.Sprintf("%v trigger needs a cookie name", tname))
+			}
+		case ERTTStatusCode:
+			for _, p := range t.PatternMatches {
+				if n, err := strconv.Atoi(p); err != nil || n < 100 || n > 599 {
+					problems = append(problems, fmt.Sprintf("%v trigger needs HTTP status codes, got %q", tname, p))
+				}
+			}
+		case ERTTRequestMethod:
+			for _, p := range t.PatternMatches {
+				switch strings.ToUpper(p) {
+				case "GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS", "CONNECT", "TRACE":
+				default:
+					problems = append(problems, fmt.Sprintf("%v trigger needs HTTP methods, got %q", tname, p))
+				}
+			}
+		case ERTTRandomChance:
+			for _, p := range t.PatternMatches {
+				if n, err := strconv.Atoi(p); err != nil || n < 0 || n > 100 {
+					problems = append(problems, fmt.Sprintf("%v trigger needs a percentage between 0 and 100, got %q", tname, p))
+				}
+			}
+		case ERTTCountryCode:
+			for _, p := range t.PatternMatches {
+				if len(p) != 2 || strings.Trim(strings.ToUpper(p), "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+					problems = append(problems, fmt.Sprintf("%v trigger needs two letter country codes, got %q", tname, p))
+				}
+			}
+		case ERTTRemoteIP:
+			for _, p := range t.PatternMatches {
+				if strings.Contains(p, "*") {
+					continue
+				}
+				if _, _, err := net.ParseCIDR(p); err != nil && net.ParseIP(p) == nil {
+					problems = append(problems, fmt.Sprintf("%v trigger needs IP addresses or CIDR ranges, got %q", tname, p))
+				}
+			}
+		}
+	}
+
+	return problems
+}
+
+func isEdgeRuleURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func isHeaderName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c <= ' ' || c >= 0x7f || strings.ContainsRune("\"(),/:;<=>?@[\\]{}", c) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,127 @@
+// Copyright (c) 2021 Jan Koppe
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package bunny
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLintEdgeRules(t *testing.T) {
+	rules, err := ParseEdgeRules(`
+	rule "cache images"
+	  when url ~ "/images/*" then override-cache-time 3600
+	rule "cache pngs shorter"
+	  when url ~ "/images/*.png" then override-cache-time 60
+	rule "copy of cache images" disabled
+	  when url ~ "/images/*" then override-cache-time 3600
+	rule "force ssl"
+	  when url ~ "http://*" then force-ssl
+	rule "back to http"
+	  when url ~ "*/legacy/*" then redirect "http://legacy.example.com"
+	rule "never"
+	  when url ~ "/a/*" and url ~ "/b/*" then block-request
+	rule "no patterns"
+	  when url in [] then block-request
+	rule "bad parameters"
+	  when random-chance ~ 150 then set-response-header "bad header" x
+	rule "separate paths"
+	  when url ~ "/static/*" then override-cache-time 60
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	findings := LintEdgeRules(rules)
+
+	expect := []struct {
+		check string
+		rule  int
+	}{
+		{LintCheckShadowed, 1},
+		{LintCheckDuplicate, 2},
+		{LintCheckConflict, 3},
+		{LintCheckUnreachable, 5},
+		{LintCheckEmptyPattern, 6},
+		{LintCheckInvalidParameter, 7},
+	}
+
+	for _, e := range expect {
+		found := false
+		for _, f := range findings {
+			if f.Check == e.check && f.Rules[0] == e.rule {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("expected %v finding for rule %d, got %v", e.check, e.rule, findings)
+		}
+	}
+
+	for _, f := range findings {
+		if f.Rules[0] == 8 || (f.Rules[0] == 0 && f.Check != LintCheckShadowed) {
+			t.Errorf("unexpected finding %v", f)
+		}
+	}
+}
+
+func TestGlobsOverlap(t *testing.T) {
+	for _, c := range []struct {
+		p, q string
+		want bool
+	}{
+		{"*/api/*", "*/API/v1", true},
+		{"*.png", "*.jpg", false},
+		{"/a/*", "*/b", true},
+		{"/a/*", "/b/*", false},
+		{"*", "", true},
+	} {
+		if got := globsOverlap(c.p, c.q); got != c.want {
+			t.Errorf("globsOverlap(%q, %q) = %v, want %v", c.p, c.q, got, c.want)
+		}
+	}
+}
+
+func TestLintEdgeRulesAlways(t *testing.T) {
+	text := FormatEdgeRules([]EdgeRule{
+		{ActionType: ERATForceSSL, Triggers: []EdgeRuleTrigger{{Type: ERTTUrl, PatternMatches: []string{"*"}, PatternMatchingType: ERTPMTMatchAny}}, TriggerMatchingType: ERTMTMatchAny, Enabled: true},
+		{ActionType: ERATSetResponseHeader, ActionParameter1: "X-Test", ActionParameter2: "1", Triggers: []EdgeRuleTrigger{}, TriggerMatchingType: ERTMTMatchAny, Enabled: true},
+	})
+	if !strings.Contains(text, "always") || !strings.Contains(text, "when any") {
+		t.Fatalf("unexpected formatting\n%s", text)
+	}
+	rules, err := ParseEdgeRules(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// always matches every request, only the rule without triggers is
+	// never applied
+	unreachable := []int{}
+	for _, f := range LintEdgeRules(rules) {
+		if f.Check == LintCheckUnreachable {
+			unreachable = append(unreachable, f.Rules[0])
+		}
+	}
+	if !reflect.DeepEqual(unreachable, []int{1}) {
+		t.Errorf("got unreachable rules %v, want [1]", unreachable)
+	}
+}
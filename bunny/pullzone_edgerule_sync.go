@@ -0,0 +1,226 @@
+// Copyright (c) 2021 Jan Koppe
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package bunny
+
+import (
+	"fmt"
+	"strings"
+)
+
+type EdgeRuleChangeType int32
+
+const (
+	ERCTCreate EdgeRuleChangeType = 0
+	ERCTUpdate EdgeRuleChangeType = 1
+	ERCTDelete EdgeRuleChangeType = 2
+)
+
+func (t EdgeRuleChangeType) String() string {
+	switch t {
+	case ERCTCreate:
+		return "create"
+	case ERCTUpdate:
+		return "update"
+	case ERCTDelete:
+		return "delete"
+	}
+	return fmt.Sprintf("EdgeRuleChangeType(%d)", int32(t))
+}
+
+// EdgeRuleChange is a single step of an EdgeRuleSyncPlan. Current is the live
+// rule (nil for creates), Desired the rule it should become (nil for deletes).
+// Applied is set once the change has been sent to the API.
+type EdgeRuleChange struct {
+	Type    EdgeRuleChangeType
+	Current *EdgeRule
+	Desired *EdgeRule
+	Applied bool
+}
+
+// EdgeRuleSyncPlan holds the changes needed to bring the EdgeRules of a
+// PullZone into the desired state. Rules that are already up to date are
+// not part of the plan.
+type EdgeRuleSyncPlan struct {
+	ZoneID  int64
+	Changes []EdgeRuleChange
+}
+
+// Empty reports whether the PullZone is already in the desired state.
+func (p *EdgeRuleSyncPlan) Empty() bool {
+	return len(p.Changes) == 0
+}
+
+func (p *EdgeRuleSyncPlan) String() string {
+	if p.Empty() {
+		return "no changes\n"
+	}
+
+	var b strings.Builder
+	for _, ch := range p.Changes {
+		switch ch.Type {
+		case ERCTCreate:
+			b.WriteString("+ create\n")
+			b.WriteString(indentEdgeRule(FormatEdgeRule(*ch.Desired)))
+		case ERCTUpdate:
+			b.WriteString(fmt.Sprintf("~ update %v\n", ch.Current.Guid))
+			b.WriteString(indentEdgeRule(FormatEdgeRule(*ch.Desired)))
+		case ERCTDelete:
+			b.WriteString(fmt.Sprintf("- delete %v\n", ch.Current.Guid))
+			b.WriteString(indentEdgeRule(FormatEdgeRule(*ch.Current)))
+		}
+	}
+	return b.String()
+}
+
+func indentEdgeRule(s string) string {
+	return "    " + strings.ReplaceAll(strings.TrimSuffix(s, "\n"), "\n", "\n    ") + "\n"
+}
+
+// DiffEdgeRules computes the changes needed to turn the current EdgeRules into
+// the desired ones.
+//
+// Desired rules are matched to current rules by Guid if they have one, then
+// by Description, and finally by identical content. Descriptions are used as
+// keys, so desired rules without a Guid must not share a Description.
+// Current rules that aren't matched by any desired rule are deleted.
+func DiffEdgeRules(zoneID int64, current []EdgeRule, desired []EdgeRule) (*EdgeRuleSyncPlan, error) {
+	plan := &EdgeRuleSyncPlan{ZoneID: zoneID, Changes: []EdgeRuleChange{}}
+
+	descriptions := map[string]bool{}
+	for _, d := range desired {
+		if d.Guid != "" || d.Description == "" {
+			continue
+		}
+		if descriptions[d.Description] {
+			return nil, fmt.Errorf("desired edge rules share the description %q", d.Description)
+		}
+		descriptions[d.Description] = true
+	}
+
+	matched := make([]bool, len(current))
+	match := make([]int, len(desired))
+
+	find := func(pred func(EdgeRule) bool) int {
+		for i, r := range current {
+			if !matched[i] && pred(r) {
+				matched[i] = true
+				return i
+			}
+		}
+		return -1
+	}
+
+	for i, d := range desired {
+		match[i] = -1
+		if d.Guid != "" {
+			match[i] = find(func(r EdgeRule) bool { return r.Guid == d.Guid })
+		}
+	}
+	for i, d := range desired {
+		if match[i] == -1 && d.Guid == "" && d.Description != "" {
+			match[i] = find(func(r EdgeRule) bool { return r.Description == d.Description })
+		}
+	}
+	for i, d := range desired {
+		if match[i] == -1 && d.Guid == "" {
+			match[i] = find(func(r EdgeRule) bool { return edgeRuleUpToDate(r, d) })
+		}
+	}
+
+	for i := range desired {
+		d := desired[i]
+		if match[i] == -1 {
+			// a guid that doesn't exist in the zone can't be updated
+			d.Guid = ""
+			plan.Changes = append(plan.Changes, EdgeRuleChange{Type: ERCTCreate, Desired: &d})
+			continue
+		}
+
+		cur := current[match[i]]
+		if edgeRuleUpToDate(cur, d) {
+			continue
+		}
+		d.Guid = cur.Guid
+		plan.Changes = append(plan.Changes, EdgeRuleChange{Type: ERCTUpdate, Current: &cur, Desired: &d})
+	}
+
+	for i := range current {
+		if !matched[i] {
+			cur := current[i]
+			plan.Changes = append(plan.Changes, EdgeRuleChange{Type: ERCTDelete, Current: &cur})
+		}
+	}
+
+	return plan, nil
+}
+
+func edgeRuleUpToDate(cur, d EdgeRule) bool {
+	return edgeRulesEqual(cur, d) && cur.Enabled == d.Enabled && cur.Description == d.Description
+}
+
+// PlanEdgeRuleSync fetches the PullZone and computes the changes SyncEdgeRules
+// would make, without applying them.
+func (c *Client) PlanEdgeRuleSync(zoneID int64, desired []EdgeRule) (*EdgeRuleSyncPlan, error) {
+	pz, err := c.GetPullZone(zoneID)
+	if err != nil {
+		return nil, err
+	}
+	return DiffEdgeRules(zoneID, pz.EdgeRules, desired)
+}
+
+// ApplyEdgeRuleSync applies a plan created by PlanEdgeRuleSync. Updates and
+// creates are applied before deletes, so the zone never has fewer rules than
+// necessary. It stops at the first error; the Applied field of each change
+// tells how far it got. The Guid of created rules is set in Desired.
+func (c *Client) ApplyEdgeRuleSync(plan *EdgeRuleSyncPlan) error {
+	for _, t := range []EdgeRuleChangeType{ERCTUpdate, ERCTCreate, ERCTDelete} {
+		for i := range plan.Changes {
+			ch := &plan.Changes[i]
+			if ch.Type != t || ch.Applied {
+				continue
+			}
+
+			switch ch.Type {
+			case ERCTUpdate, ERCTCreate:
+				guid, err := c.UpsertEdgeRule(plan.ZoneID, *ch.Desired)
+				if err != nil {
+					return err
+				}
+				ch.Desired.Guid = guid
+			case ERCTDelete:
+				if err := c.DeleteEdgeRule(plan.ZoneID, ch.Current.Guid); err != nil {
+					return err
+				}
+			}
+			ch.Applied = true
+		}
+	}
+	return nil
+}
+
+// SyncEdgeRules makes the EdgeRules of a PullZone match the desired rules
+// exactly, and returns the plan that was applied.
+func (c *Client) SyncEdgeRules(zoneID int64, desired []EdgeRule) (*EdgeRuleSyncPlan, error) {
+	plan, err := c.PlanEdgeRuleSync(zoneID, desired)
+	if err != nil {
+		return nil, err
+	}
+	return plan, c.ApplyEdgeRuleSync(plan)
+}
@@ -0,0 +1,192 @@
+// Copyright (c) 2021 Jan Koppe
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package bunny
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestDiffEdgeRules(t *testing.T) {
+	current := []EdgeRule{
+		{Guid: "a", ActionType: ERATForceSSL, Description: "ssl", Enabled: true},
+		{Guid: "b", ActionType: ERATOverrideCacheTime, ActionParameter1: "60", Description: "cache", Enabled: true},
+		{Guid: "c", ActionType: ERATBlockRequest, Enabled: true},
+		{Guid: "d", ActionType: ERATForceDownload, Enabled: true},
+	}
+
+	desired := []EdgeRule{
+		// unchanged, matched by description
+		{ActionType: ERATForceSSL, Description: "ssl", Enabled: true},
+		// changed, matched by guid
+		{Guid: "b", ActionType: ERATOverrideCacheTime, ActionParameter1: "3600", Description: "cache", Enabled: true},
+		// unchanged, matched by content
+		{ActionType: ERATForceDownload, Enabled: true},
+		// new
+		{ActionType: ERATDisableOptimizer, Description: "no optimizer", Enabled: true},
+	}
+
+	plan, err := DiffEdgeRules(1, current, desired)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(plan.Changes) != 3 {
+		t.Fatalf("expected 3 changes, got:\n%v", plan)
+	}
+
+	update, create, del := plan.Changes[0], plan.Changes[1], plan.Changes[2]
+	if update.Type != ERCTUpdate || update.Current.Guid != "b" || update.Desired.ActionParameter1 != "3600" {
+		t.Errorf("expected update of rule b, got %v %+v", update.Type, update.Current)
+	}
+	if create.Type != ERCTCreate || create.Desired.Description != "no optimizer" {
+		t.Errorf("expected create of new rule, got %v %+v", create.Type, create.Desired)
+	}
+	if del.Type != ERCTDelete || del.Current.Guid != "c" {
+		t.Errorf("expected delete of rule c, got %v %+v", del.Type, del.Current)
+	}
+
+	_, err = DiffEdgeRules(1, current, []EdgeRule{{Description: "x"}, {Description: "x"}})
+	if err == nil {
+		t.Error("expected error for duplicate descriptions")
+	}
+}
+
+// fakeEdgeRules serves the EdgeRules of a single PullZone and records the
+// changes made to them.
+type fakeEdgeRules struct {
+	mu         sync.Mutex
+	rules      []EdgeRule
+	calls      []string
+	created    int
+	failDelete string
+}
+
+func (f *fakeEdgeRules) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/pullzone/1":
+		_ = json.NewEncoder(w).Encode(&PullZone{ID: 1, EdgeRules: f.rules})
+	case r.Method == "POST" && r.URL.Path == "/pullzone/1/edgerules/addOrUpdate":
+		var rule EdgeRule
+		if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.calls = append(f.calls, "upsert "+rule.Description)
+		for i := range f.rules {
+			if rule.Guid != "" && f.rules[i].Guid == rule.Guid {
+				f.rules[i] = rule
+				return
+			}
+		}
+		f.created++
+		rule.Guid = fmt.Sprintf("new-%d", f.created)
+		f.rules = append(f.rules, rule)
+	case r.Method == "DELETE" && strings.HasPrefix(r.URL.Path, "/pullzone/1/edgerules/"):
+		guid := strings.TrimPrefix(r.URL.Path, "/pullzone/1/edgerules/")
+		f.calls = append(f.calls, "delete "+guid)
+		if guid == f.failDelete {
+			w.WriteHeader(http.StatusInternalServerError)
+			_ = json.NewEncoder(w).Encode(ErrorResponse{ErrorKey: "edgerule.failed", Message: "failed"})
+			return
+		}
+		for i := range f.rules {
+			if f.rules[i].Guid == guid {
+				f.rules = append(f.rules[:i], f.rules[i+1:]...)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestSyncEdgeRules(t *testing.T) {
+	api := &fakeEdgeRules{rules: []EdgeRule{
+		{Guid: "a", ActionType: ERATBlockRequest, Description: "old", Enabled: true},
+		{Guid: "b", ActionType: ERATOverrideCacheTime, ActionParameter1: "60", Description: "cache", Enabled: true},
+		{Guid: "c", ActionType: ERATForceDownload, Description: "gone", Enabled: true},
+	}}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	c, err := NewClient("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.BaseURL, _ = url.Parse(srv.URL)
+
+	desired := []EdgeRule{
+		{ActionType: ERATDisableOptimizer, Description: "new", Enabled: true},
+		{ActionType: ERATOverrideCacheTime, ActionParameter1: "3600", Description: "cache", Enabled: true},
+	}
+
+	// deletes fail after the updates and creates were applied
+	api.failDelete = "c"
+	plan, err := c.SyncEdgeRules(1, desired)
+	if _, ok := err.(*ErrorResponse); !ok {
+		t.Fatalf("expected the failed delete to be returned, got %v", err)
+	}
+	want := []string{"upsert cache", "upsert new", "delete a", "delete c"}
+	if !reflect.DeepEqual(api.calls, want) {
+		t.Errorf("got calls %v, want %v", api.calls, want)
+	}
+	applied := 0
+	for _, ch := range plan.Changes {
+		if ch.Applied {
+			applied++
+		}
+		if ch.Type == ERCTCreate && ch.Desired.Guid != "new-1" {
+			t.Errorf("guid of the created rule was not recorded: %+v", ch.Desired)
+		}
+	}
+	if applied != 3 {
+		t.Errorf("expected 3 applied changes, got %v", plan)
+	}
+
+	// applying the plan again only retries what's left
+	api.failDelete = ""
+	api.calls = nil
+	if err := c.ApplyEdgeRuleSync(plan); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(api.calls, []string{"delete c"}) {
+		t.Errorf("unexpected calls on retry %v", api.calls)
+	}
+
+	plan, err = c.PlanEdgeRuleSync(1, desired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plan.Empty() {
+		t.Errorf("rules are not in sync:\n%v", plan)
+	}
+}
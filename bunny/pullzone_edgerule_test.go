@@ -20,6 +20,7 @@
 package bunny
 
 import (
+	"encoding/json"
 	"testing"
 )
 
@@ -55,6 +56,12 @@ func TestPullZoneEdgeRules(t *testing.T) {
 		t.Errorf(err.Error())
 	}
 
+	// Disable the EdgeRule
+	err = c.SetEdgeRuleEnabled(pullZone.ID, ruleID, false)
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+
 	// Delete the EdgeRule again
 	err = c.DeleteEdgeRule(pullZone.ID, ruleID)
 	if err != nil {
@@ -79,3 +86,55 @@ func TestPullZoneEdgeRules(t *testing.T) {
 	}
 
 }
+
+func TestEdgeRuleEnums(t *testing.T) {
+	if s := ERATSetResponseHeader.String(); s != "SetResponseHeader" {
+		t.Errorf("unexpected name %v", s)
+	}
+	if s := EdgeRuleTriggerType(99).String(); s != "EdgeRuleTriggerType(99)" {
+		t.Errorf("unexpected name for unknown value %v", s)
+	}
+
+	for _, s := range []string{"countrycode", "4", "EdgeRuleTriggerType(4)"} {
+		tt, err := ParseEdgeRuleTriggerType(s)
+		if err != nil || tt != ERTTCountryCode {
+			t.Errorf("parsing %q gave %v, %v", s, tt, err)
+		}
+	}
+	if _, err := ParseEdgeRuleActionType("Teleport"); err == nil {
+		t.Error("expected error for unknown name")
+	}
+
+	// JSON stays numeric for the API, but accepts names as well
+	rule := EdgeRule{
+		ActionType:          EdgeRuleActionType(42),
+		Triggers:            []EdgeRuleTrigger{{Type: ERTTRequestMethod, PatternMatchingType: ERTPMTMatchNone}},
+		TriggerMatchingType: ERTMTMatchAll,
+	}
+	b, err := json.Marshal(rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		t.Fatal(err)
+	}
+	if raw["ActionType"] != float64(42) || raw["TriggerMatchingType"] != float64(1) {
+		t.Errorf("enums should marshal to numbers, got %s", b)
+	}
+
+	var decoded EdgeRule
+	err = json.Unmarshal([]byte(`{"ActionType":"Redirect","Triggers":[{"Type":9}],"TriggerMatchingType":"MatchNone"}`), &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.ActionType != ERATRedirect || decoded.Triggers[0].Type != ERTTRequestMethod || decoded.TriggerMatchingType != ERTMTMatchNone {
+		t.Errorf("unexpected decoded rule %+v", decoded)
+	}
+
+	// text marshalling uses names, and numbers for unknown values
+	text, _ := json.Marshal(map[EdgeRuleActionType]int{ERATForceSSL: 1, EdgeRuleActionType(42): 2})
+	if string(text) != `{"42":2,"ForceSSL":1}` {
+		t.Errorf("unexpected text marshalling %s", text)
+	}
+}
@@ -0,0 +1,324 @@
+// Copyright (c) 2021 Jan Koppe
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package bunny
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMobileUserAgent is used to warm the mobile variant of PullZones with
+// EnableMobileVary set.
+const DefaultMobileUserAgent = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1"
+
+// WarmupOptions configures WarmPullZone. The zero value fetches every URL
+// once, without purging first.
+type WarmupOptions struct {
+	// purge every URL on all hostnames before fetching it
+	Purge        bool
+	PurgeOptions PurgeURLsOptions
+	// only purge, don't fetch
+	SkipFetch bool
+	// number of fetches running at the same time, defaults to 10
+	Concurrency int
+	// headers sent with every fetch
+	Headers http.Header
+	// every URL is fetched once per variant, with the variant's headers
+	// added. Useful for Accept-Encoding variants. Defaults to a single
+	// variant without extra headers.
+	Variants []http.Header
+	// user agent for the additional mobile variant of PullZones with
+	// EnableMobileVary set, defaults to DefaultMobileUserAgent
+	MobileUserAgent string
+	// client used for fetching, defaults to one with a 60 second timeout
+	HTTPClient *http.Client
+}
+
+// WarmupResult is the outcome of fetching one URL in one variant.
+// CacheStatus holds the CDN-Cache header (HIT, MISS, ...) of the response.
+type WarmupResult struct {
+	URL         string
+	Variant     http.Header
+	StatusCode  int
+	CacheStatus string
+	Header      http.Header
+	Duration    time.Duration
+	Err         error
+}
+
+// WarmupReport holds the results of WarmPullZone. Purge is nil if nothing
+// was purged.
+type WarmupReport struct {
+	Purge   *PurgeURLsReport
+	Results []WarmupResult
+}
+
+// CacheStatusCounts counts the fetches by CacheStatus. Failed fetches are
+// counted as "error".
+func (r *WarmupReport) CacheStatusCounts() map[string]int {
+	counts := map[string]int{}
+	for _, res := range r.Results {
+		switch {
+		case res.Err != nil:
+			counts["error"]++
+		case res.CacheStatus == "":
+			counts["unknown"]++
+		default:
+			counts[res.CacheStatus]++
+		}
+	}
+	return counts
+}
+
+// WarmPullZoneFromSitemap reads the sitemap (or sitemap index) at sitemapURL
+// and warms the PullZone with all URLs in it, see WarmPullZone.
+func (c *Client) WarmPullZoneFromSitemap(ctx context.Context, pz *PullZone, sitemapURL string, opts *WarmupOptions) (*WarmupReport, error) {
+	hc := http.DefaultClient
+	if opts != nil && opts.HTTPClient != nil {
+		hc = opts.HTTPClient
+	}
+
+	urls, err := ReadSitemap(ctx, hc, sitemapURL)
+	if err != nil {
+		return nil, err
+	}
+	return c.WarmPullZone(ctx, pz, urls, opts)
+}
+
+// WarmPullZone purges and/or fetches URLs through all hostnames of the
+// PullZone, so that they are in the cache before real visitors arrive. Only
+// path and query of the URLs are used, so they can point at the origin.
+func (c *Client) WarmPullZone(ctx context.Context, pz *PullZone, urls []string, opts *WarmupOptions) (*WarmupReport, error) {
+	o := WarmupOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.Concurrency <= 0 {
+		o.Concurrency = 10
+	}
+	if o.HTTPClient == nil {
+		o.HTTPClient = &http.Client{Timeout: 60 * time.Second}
+	}
+	if len(o.Variants) == 0 {
+		o.Variants = []http.Header{{}}
+	}
+	if pz.EnableMobileVary {
+		ua := o.MobileUserAgent
+		if ua == "" {
+			ua = DefaultMobileUserAgent
+		}
+		mobile := []http.Header{}
+		for _, v := range o.Variants {
+			m := v.Clone()
+			if m == nil {
+				m = http.Header{}
+			}
+			m.Set("User-Agent", ua)
+			mobile = append(mobile, m)
+		}
+		o.Variants = append(o.Variants, mobile...)
+	}
+
+	targets := []string{}
+	for _, raw := range urls {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, err
+		}
+		path := u.EscapedPath()
+		if u.RawQuery != "" {
+			path += "?" + u.RawQuery
+		}
+		targets = append(targets, PullZoneURLs(pz, path)...)
+	}
+
+	report := &WarmupReport{Results: []WarmupResult{}}
+
+	if o.Purge {
+		purge, err := c.PurgeURLs(ctx, targets, &o.PurgeOptions)
+		report.Purge = purge
+		if err != nil {
+			return report, err
+		}
+	}
+
+	if o.SkipFetch {
+		return report, nil
+	}
+
+	for _, t := range targets {
+		for _, v := range o.Variants {
+			report.Results = append(report.Results, WarmupResult{URL: t, Variant: v})
+		}
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < o.Concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				warmURL(ctx, o, &report.Results[i])
+			}
+		}()
+	}
+	for i := range report.Results {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return report, ctx.Err()
+}
+
+func warmURL(ctx context.Context, o WarmupOptions, res *WarmupResult) {
+	start := time.Now()
+	defer func() { res.Duration = time.Since(start) }()
+
+	req, err := http.NewRequest("GET", res.URL, nil)
+	if err != nil {
+		res.Err = err
+		return
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("User-Agent", "go-bunnynet/dev")
+	for _, h := range []http.Header{o.Headers, res.Variant} {
+		for k, vs := range h {
+			req.Header.Del(k)
+			for _, v := range vs {
+				req.Header.Add(k, v)
+			}
+		}
+	}
+
+	resp, err := o.HTTPClient.Do(req)
+	if err != nil {
+		res.Err = err
+		return
+	}
+	defer resp.Body.Close()
+
+	// read the whole body, so the CDN caches the complete response
+	_, err = io.Copy(ioutil.Discard, resp.Body)
+	res.StatusCode = resp.StatusCode
+	res.Header = resp.Header
+	res.CacheStatus = resp.Header.Get("CDN-Cache")
+	if err == nil && resp.StatusCode >= 400 {
+		err = fmt.Errorf("%v returned %v", res.URL, resp.Status)
+	}
+	res.Err = err
+}
+
+type sitemapDocument struct {
+	XMLName  xml.Name
+	URLs     []string `xml:"url>loc"`
+	Sitemaps []string `xml:"sitemap>loc"`
+}
+
+// ParseSitemap parses a sitemap or sitemap index. It returns the page URLs of
+// a sitemap, or the sitemap URLs of a sitemap index.
+func ParseSitemap(r io.Reader) (urls []string, sitemaps []string, err error) {
+	var doc sitemapDocument
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, nil, err
+	}
+	if doc.XMLName.Local != "urlset" && doc.XMLName.Local != "sitemapindex" {
+		return nil, nil, fmt.Errorf("not a sitemap, root element is %v", doc.XMLName.Local)
+	}
+
+	trim := func(in []string) []string {
+		out := []string{}
+		for _, s := range in {
+			if s = strings.TrimSpace(s); s != "" {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return trim(doc.URLs), trim(doc.Sitemaps), nil
+}
+
+// ReadSitemap fetches a sitemap and returns all page URLs in it, following
+// sitemap indexes. Gzip compressed sitemaps are supported.
+func ReadSitemap(ctx context.Context, hc *http.Client, sitemapURL string) ([]string, error) {
+	urls := []string{}
+	seen := map[string]bool{}
+
+	var read func(u string, depth int) error
+	read = func(u string, depth int) error {
+		if seen[u] {
+			return nil
+		}
+		seen[u] = true
+		if depth > 3 {
+			return fmt.Errorf("sitemap indexes nested too deeply at %v", u)
+		}
+
+		req, err := http.NewRequest("GET", u, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := hc.Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("fetching sitemap %v: %v", u, resp.Status)
+		}
+
+		var body io.Reader = resp.Body
+		if strings.HasSuffix(req.URL.Path, ".gz") || resp.Header.Get("Content-Type") == "application/gzip" ||
+			resp.Header.Get("Content-Type") == "application/x-gzip" {
+			gz, err := gzip.NewReader(resp.Body)
+			if err != nil {
+				return err
+			}
+			defer gz.Close()
+			body = gz
+		}
+
+		pages, sitemaps, err := ParseSitemap(body)
+		if err != nil {
+			return fmt.Errorf("parsing sitemap %v: %v", u, err)
+		}
+		urls = append(urls, pages...)
+		for _, s := range sitemaps {
+			if err := read(s, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// read appends to urls, so it has to run before urls is returned
+	err := read(sitemapURL, 0)
+	return urls, err
+}
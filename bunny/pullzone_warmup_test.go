@@ -0,0 +1,102 @@
+// Copyright (c) 2021 Jan Koppe
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package bunny
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestWarmPullZoneFromSitemap(t *testing.T) {
+	var mu sync.Mutex
+	fetched := []string{}
+
+	// serves the sitemaps as origin, and the pages as CDN
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sitemap.xml":
+			fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>https://example.com/pages.xml</loc></sitemap>
+</sitemapindex>`)
+		case "/pages.xml":
+			fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>https://origin.example.org/</loc></url>
+  <url><loc> https://origin.example.org/about?lang=de </loc></url>
+</urlset>`)
+		default:
+			mu.Lock()
+			fetched = append(fetched, r.Host+r.URL.RequestURI()+" "+r.Header.Get("Accept-Encoding")+" "+r.Header.Get("User-Agent"))
+			mu.Unlock()
+			w.Header().Set("CDN-Cache", "MISS")
+		}
+	}))
+	defer srv.Close()
+
+	// send every request to the test server, whatever the hostname
+	hc := srv.Client()
+	hc.Transport.(*http.Transport).DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return net.Dial(network, srv.Listener.Addr().String())
+	}
+
+	c, err := NewClient("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.BaseURL, _ = url.Parse(srv.URL)
+
+	pz := &PullZone{
+		Name:             "example",
+		EnableMobileVary: true,
+		Hostnames:        []PullZoneHostname{{Value: "example.com", IsSystemHostname: true}},
+	}
+	opts := &WarmupOptions{
+		HTTPClient:      hc,
+		Variants:        []http.Header{{"Accept-Encoding": {"br"}}},
+		MobileUserAgent: "mobile",
+	}
+
+	report, err := c.WarmPullZoneFromSitemap(context.Background(), pz, "https://example.com/sitemap.xml", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.Results) != 4 {
+		t.Fatalf("expected 2 urls in 2 variants, got %v", report.Results)
+	}
+	if n := report.CacheStatusCounts()["MISS"]; n != 4 {
+		t.Errorf("expected 4 cache misses, got %v", report.CacheStatusCounts())
+	}
+
+	all := strings.Join(fetched, "\n")
+	for _, want := range []string{"example.com/about?lang=de br mobile", "example.com/ br go-bunnynet/dev"} {
+		if !strings.Contains(all, want) {
+			t.Errorf("expected fetch %q, got:\n%v", want, all)
+		}
+	}
+}
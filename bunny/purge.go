@@ -20,24 +20,95 @@
 package bunny
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 	"net/url"
+	"strings"
 )
 
 func (c *Client) PurgePullZoneCache(zoneID int64) error {
 	return c.doRequest("POST", fmt.Sprintf("/pullzone/%v/purgeCache", zoneID), "", nil, nil)
 }
 
+// PurgeOptions holds the optional parameters of a URL purge.
+type PurgeOptions struct {
+	// only purge the cached variation with this header value
+	HeaderName  string
+	HeaderValue string
+	// return right away instead of waiting for the purge to finish
+	Async bool
+}
+
+// PurgeURL purges a single URL. A URL ending in * is a wildcard purge of
+// everything below it, e.g. https://example.b-cdn.net/images/*.
 func (c *Client) PurgeURL(purgeURL string, headerName string, headerValue string) error {
+	return c.PurgeURLWithOptions(purgeURL, PurgeOptions{HeaderName: headerName, HeaderValue: headerValue})
+}
+
+func (c *Client) PurgeURLWithOptions(purgeURL string, opts PurgeOptions) error {
+	_, err := c.purgeURL(context.Background(), purgeURL, opts)
+	return err
+}
+
+func (c *Client) purgeURL(ctx context.Context, purgeURL string, opts PurgeOptions) (*http.Response, error) {
 	// why is this a GET, bunny?
 
 	v := url.Values{}
 	v.Set("url", purgeURL)
 
-	if headerName != "" && headerValue != "" {
-		v.Set("headerName", headerName)
-		v.Set("headerValue", headerValue)
+	if opts.HeaderName != "" && opts.HeaderValue != "" {
+		v.Set("headerName", opts.HeaderName)
+		v.Set("headerValue", opts.HeaderValue)
 	}
 
-	return c.doRequest("GET", "/purge", v.Encode(), nil, nil)
+	if opts.Async {
+		v.Set("async", "true")
+	}
+
+	req, err := c.newRequest("GET", "/purge", v.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.do(req.WithContext(ctx), nil)
+}
+
+// PullZoneURLs expands a path into URLs for every hostname of the PullZone,
+// including the b-cdn.net system hostname. The path may end in * for a
+// wildcard purge.
+func PullZoneURLs(pz *PullZone, path string) []string {
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	hostnames := []string{}
+	seen := map[string]bool{}
+	add := func(h string) {
+		h = strings.ToLower(h)
+		if h != "" && !seen[h] {
+			seen[h] = true
+			hostnames = append(hostnames, h)
+		}
+	}
+
+	hasSystem := false
+	for _, h := range pz.Hostnames {
+		add(h.Value)
+		hasSystem = hasSystem || h.IsSystemHostname
+	}
+	if !hasSystem && pz.Name != "" {
+		add(pz.Name + ".b-cdn.net")
+	}
+
+	urls := make([]string, len(hostnames))
+	for i, h := range hostnames {
+		urls[i] = "https://" + h + path
+	}
+	return urls
+}
+
+// PurgePullZonePath purges a path, or a wildcard path ending in *, under all
+// hostnames of the PullZone.
+func (c *Client) PurgePullZonePath(ctx context.Context, pz *PullZone, path string, opts *PurgeURLsOptions) (*PurgeURLsReport, error) {
+	return c.PurgeURLs(ctx, PullZoneURLs(pz, path), opts)
 }
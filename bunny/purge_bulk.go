@@ -0,0 +1,235 @@
+// Copyright (c) 2021 Jan Koppe
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package bunny
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PurgeURLsOptions configures PurgeURLs. The zero value is usable.
+type PurgeURLsOptions struct {
+	// number of purges running at the same time, defaults to 10
+	Concurrency int
+	// retries after a transient failure (network errors, 429 and 5xx
+	// responses), defaults to 3. Use a negative value to disable retries.
+	Retries int
+	// delay before the first retry, doubled for every further one.
+	// Defaults to one second.
+	RetryDelay time.Duration
+	// header pair and async flag used for every purge
+	PurgeOptions
+}
+
+// PurgeURLResult is the outcome of purging a single URL. URL is the
+// normalized URL, Inputs the original URLs that were normalized to it.
+type PurgeURLResult struct {
+	URL      string
+	Inputs   []string
+	Attempts int
+	Err      error
+}
+
+// PurgeURLsReport summarizes a PurgeURLs run.
+type PurgeURLsReport struct {
+	Results    []PurgeURLResult
+	Total      int
+	Duplicates int
+	Succeeded  int
+	Failed     int
+}
+
+// Failures returns the results of all URLs that could not be purged.
+func (r *PurgeURLsReport) Failures() []PurgeURLResult {
+	failed := []PurgeURLResult{}
+	for _, res := range r.Results {
+		if res.Err != nil {
+			failed = append(failed, res)
+		}
+	}
+	return failed
+}
+
+func (r *PurgeURLsReport) String() string {
+	return fmt.Sprintf("%d urls, %d duplicates, %d purged, %d failed", r.Total, r.Duplicates, r.Succeeded, r.Failed)
+}
+
+// PurgeURLs purges many URLs at once. URLs are normalized and deduplicated
+// before purging, and purged in parallel, respecting the rate limit set with
+// SetRateLimit. Transient failures are retried.
+//
+// Every unique URL gets a result in the report, in the order of their first
+// appearance. An error is only returned if the context was cancelled, in
+// which case the report holds the results up to that point.
+func (c *Client) PurgeURLs(ctx context.Context, urls []string, opts *PurgeURLsOptions) (*PurgeURLsReport, error) {
+	o := PurgeURLsOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.Concurrency <= 0 {
+		o.Concurrency = 10
+	}
+	if o.Retries == 0 {
+		o.Retries = 3
+	} else if o.Retries < 0 {
+		o.Retries = 0
+	}
+	if o.RetryDelay <= 0 {
+		o.RetryDelay = time.Second
+	}
+
+	report := &PurgeURLsReport{Results: []PurgeURLResult{}, Total: len(urls)}
+	index := map[string]int{}
+	for _, raw := range urls {
+		norm, err := NormalizePurgeURL(raw)
+		if err != nil {
+			report.Results = append(report.Results, PurgeURLResult{URL: raw, Inputs: []string{raw}, Err: err})
+			continue
+		}
+		if i, ok := index[norm]; ok {
+			report.Results[i].Inputs = append(report.Results[i].Inputs, raw)
+			report.Duplicates++
+			continue
+		}
+		index[norm] = len(report.Results)
+		report.Results = append(report.Results, PurgeURLResult{URL: norm, Inputs: []string{raw}})
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < o.Concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				res := &report.Results[i]
+				res.Attempts, res.Err = c.purgeWithRetry(ctx, res.URL, o)
+			}
+		}()
+	}
+
+	var ctxErr error
+	for i := range report.Results {
+		if report.Results[i].Err != nil {
+			continue
+		}
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			ctxErr = ctx.Err()
+		}
+		if ctxErr != nil {
+			for j := i; j < len(report.Results); j++ {
+				if report.Results[j].Err == nil {
+					report.Results[j].Err = ctxErr
+				}
+			}
+			break
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, res := range report.Results {
+		if res.Err != nil {
+			report.Failed++
+		} else {
+			report.Succeeded++
+		}
+	}
+
+	return report, ctxErr
+}
+
+func (c *Client) purgeWithRetry(ctx context.Context, purgeURL string, o PurgeURLsOptions) (int, error) {
+	delay := o.RetryDelay
+	attempts := 0
+	for {
+		attempts++
+		resp, err := c.purgeURL(ctx, purgeURL, o.PurgeOptions)
+		if err == nil || attempts > o.Retries || !isTransient(resp, err) {
+			return attempts, err
+		}
+
+		t := time.NewTimer(delay)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return attempts, ctx.Err()
+		}
+		delay *= 2
+	}
+}
+
+// isTransient reports whether a failed request might succeed when retried.
+func isTransient(resp *http.Response, err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if resp == nil {
+		// network error, no response at all
+		return true
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+// NormalizePurgeURL brings a URL into the canonical form used by PurgeURLs,
+// so that different spellings of the same URL are only purged once. URLs
+// without a scheme are assumed to be https. Wildcards are only allowed as
+// the last character.
+func NormalizePurgeURL(raw string) (string, error) {
+	s := strings.TrimSpace(raw)
+	if !strings.Contains(s, "://") {
+		s = "https://" + s
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", err
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("url %q has no hostname", raw)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("url %q is not a http(s) url", raw)
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	if (u.Scheme == "https" && strings.HasSuffix(u.Host, ":443")) || (u.Scheme == "http" && strings.HasSuffix(u.Host, ":80")) {
+		u.Host = u.Host[:strings.LastIndex(u.Host, ":")]
+	}
+	u.Fragment = ""
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	if i := strings.Index(u.String(), "*"); i >= 0 && i != len(u.String())-1 {
+		return "", fmt.Errorf("url %q may only contain a wildcard at the end", raw)
+	}
+
+	return u.String(), nil
+}
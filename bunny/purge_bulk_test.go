@@ -0,0 +1,108 @@
+// Copyright (c) 2021 Jan Koppe
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package bunny
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPurgeURLs(t *testing.T) {
+	var mu sync.Mutex
+	calls := map[string]int{}
+	var inFlight, maxInFlight int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			m := atomic.LoadInt32(&maxInFlight)
+			if n <= m || atomic.CompareAndSwapInt32(&maxInFlight, m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		u := r.URL.Query().Get("url")
+		mu.Lock()
+		calls[u]++
+		count := calls[u]
+		mu.Unlock()
+
+		switch {
+		case u == "https://example.com/flaky" && count == 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case u == "https://example.com/bad":
+			w.WriteHeader(http.StatusBadRequest)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer srv.Close()
+
+	c, err := NewClient("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.BaseURL, _ = url.Parse(srv.URL)
+
+	urls := []string{
+		"https://example.com/a",
+		"HTTPS://Example.com:443/a#top",
+		"example.com/b",
+		"https://example.com/flaky",
+		"https://example.com/bad",
+		"ftp://example.com/c",
+		"https://example.com/d",
+		"https://example.com/e",
+	}
+
+	report, err := c.PurgeURLs(context.Background(), urls, &PurgeURLsOptions{Concurrency: 2, RetryDelay: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Total != 8 || report.Duplicates != 1 || report.Succeeded != 5 || report.Failed != 2 {
+		t.Errorf("unexpected report: %v", report)
+	}
+	if calls["https://example.com/a"] != 1 {
+		t.Errorf("duplicate url was purged %d times", calls["https://example.com/a"])
+	}
+	if calls["https://example.com/bad"] != 1 {
+		t.Errorf("permanent failure was retried")
+	}
+	for _, res := range report.Results {
+		if res.URL == "https://example.com/flaky" && (res.Err != nil || res.Attempts != 2) {
+			t.Errorf("flaky url should succeed on second attempt, got %+v", res)
+		}
+	}
+	if maxInFlight > 2 {
+		t.Errorf("concurrency limit exceeded: %d purges in flight", maxInFlight)
+	}
+	if len(report.Failures()) != 2 {
+		t.Errorf("expected 2 failures, got %v", report.Failures())
+	}
+}
@@ -0,0 +1,268 @@
+// Copyright (c) 2021 Jan Koppe
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package bunny
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrPurgeQueueClosed = errors.New("purge queue is closed")
+
+// PurgeQueueOptions configures a PurgeQueue.
+type PurgeQueueOptions struct {
+	// PullZone to purge completely when too many URLs are pending. If not
+	// set, the queue never escalates to a full purge.
+	ZoneID int64
+	// time between automatic flushes, defaults to 5 seconds
+	FlushInterval time.Duration
+	// number of pending URLs above which the whole PullZone is purged
+	// instead, defaults to 1000
+	MaxPending int
+	// options used for purging the URLs of a flush
+	Purge PurgeURLsOptions
+	// called after every flush that purged something, from the goroutine
+	// that flushed
+	OnFlush func(*PurgeQueueFlush)
+}
+
+// PurgeQueueFlush describes what a flush of the PurgeQueue did. If FullPurge
+// is set, the whole PullZone was purged instead of the single URLs, and
+// Report is nil.
+type PurgeQueueFlush struct {
+	URLs      []string
+	FullPurge bool
+	Report    *PurgeURLsReport
+	Err       error
+	// number of failed URLs put back into the queue for the next flush
+	Requeued int
+}
+
+// PurgeQueue collects purge requests and sends them in batches. Duplicate
+// URLs and URLs covered by a pending wildcard purge are dropped, and once
+// too many URLs are pending, the whole PullZone is purged instead.
+//
+// The queue flushes on an interval, when it overflows and when Flush is
+// called. It has to be closed with Close, which flushes the remaining URLs.
+type PurgeQueue struct {
+	c    *Client
+	opts PurgeQueueOptions
+
+	mu      sync.Mutex
+	pending map[string]bool
+	closed  bool
+
+	// only one flush at a time
+	flushMu sync.Mutex
+
+	kick    chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// NewPurgeQueue creates a PurgeQueue and starts flushing it in the background.
+func (c *Client) NewPurgeQueue(opts PurgeQueueOptions) *PurgeQueue {
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = 5 * time.Second
+	}
+	if opts.MaxPending <= 0 {
+		opts.MaxPending = 1000
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &PurgeQueue{
+		c:       c,
+		opts:    opts,
+		pending: map[string]bool{},
+		kick:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	go q.loop()
+	return q
+}
+
+func (q *PurgeQueue) loop() {
+	defer close(q.stopped)
+
+	t := time.NewTicker(q.opts.FlushInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+		case <-q.kick:
+		case <-q.done:
+			return
+		}
+		// errors are reported through OnFlush
+		_, _ = q.Flush(q.ctx)
+	}
+}
+
+// Add queues URLs for purging. URLs ending in * are wildcard purges, which
+// replace all pending URLs below them.
+func (q *PurgeQueue) Add(urls ...string) error {
+	normalized := make([]string, len(urls))
+	for i, u := range urls {
+		n, err := NormalizePurgeURL(u)
+		if err != nil {
+			return err
+		}
+		normalized[i] = n
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return ErrPurgeQueueClosed
+	}
+
+	for _, u := range normalized {
+		q.add(u)
+	}
+
+	if q.opts.ZoneID > 0 && len(q.pending) > q.opts.MaxPending {
+		select {
+		case q.kick <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (q *PurgeQueue) add(u string) {
+	if q.pending[u] {
+		return
+	}
+	for p := range q.pending {
+		if strings.HasSuffix(p, "*") && strings.HasPrefix(u, strings.TrimSuffix(p, "*")) {
+			return
+		}
+	}
+	if strings.HasSuffix(u, "*") {
+		prefix := strings.TrimSuffix(u, "*")
+		for p := range q.pending {
+			if strings.HasPrefix(p, prefix) {
+				delete(q.pending, p)
+			}
+		}
+	}
+	q.pending[u] = true
+}
+
+// Pending returns the number of URLs waiting for the next flush.
+func (q *PurgeQueue) Pending() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
+
+// Flush purges all pending URLs right away. It returns nil if nothing was
+// pending. URLs that could not be purged are put back into the queue and
+// retried with the next flush, unless the queue was closed.
+func (q *PurgeQueue) Flush(ctx context.Context) (*PurgeQueueFlush, error) {
+	q.flushMu.Lock()
+	defer q.flushMu.Unlock()
+
+	q.mu.Lock()
+	urls := make([]string, 0, len(q.pending))
+	for u := range q.pending {
+		urls = append(urls, u)
+	}
+	q.pending = map[string]bool{}
+	q.mu.Unlock()
+
+	if len(urls) == 0 {
+		return nil, nil
+	}
+	sort.Strings(urls)
+
+	f := &PurgeQueueFlush{URLs: urls}
+	if q.opts.ZoneID > 0 && len(urls) > q.opts.MaxPending {
+		f.FullPurge = true
+		f.Err = q.c.PurgePullZoneCache(q.opts.ZoneID)
+	} else {
+		f.Report, f.Err = q.c.PurgeURLs(ctx, urls, &q.opts.Purge)
+		if f.Err == nil && f.Report.Failed > 0 {
+			f.Err = fmt.Errorf("failed to purge %d of %d urls", f.Report.Failed, len(urls))
+		}
+	}
+
+	if f.Err != nil {
+		failed := urls
+		if f.Report != nil {
+			failed = []string{}
+			for _, res := range f.Report.Failures() {
+				failed = append(failed, res.URL)
+			}
+		}
+		q.mu.Lock()
+		if !q.closed {
+			for _, u := range failed {
+				q.add(u)
+			}
+			f.Requeued = len(failed)
+		}
+		q.mu.Unlock()
+	}
+
+	if q.opts.OnFlush != nil {
+		q.opts.OnFlush(f)
+	}
+	return f, f.Err
+}
+
+// Close stops the queue and flushes the remaining URLs. If the context ends
+// before that is done, running purges are cancelled and the context's error
+// is returned.
+func (q *PurgeQueue) Close(ctx context.Context) error {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return ErrPurgeQueueClosed
+	}
+	q.closed = true
+	q.mu.Unlock()
+
+	defer q.cancel()
+	close(q.done)
+
+	select {
+	case <-q.stopped:
+	case <-ctx.Done():
+		q.cancel()
+		<-q.stopped
+		return ctx.Err()
+	}
+
+	_, err := q.Flush(ctx)
+	return err
+}
@@ -0,0 +1,164 @@
+// Copyright (c) 2021 Jan Koppe
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package bunny
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPurgeQueue(t *testing.T) {
+	var mu sync.Mutex
+	purged := []string{}
+	fullPurges := 0
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path == "/pullzone/7/purgeCache" {
+			fullPurges++
+			return
+		}
+		purged = append(purged, r.URL.Query().Get("url"))
+	}))
+	defer srv.Close()
+
+	c, err := NewClient("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.BaseURL, _ = url.Parse(srv.URL)
+
+	q := c.NewPurgeQueue(PurgeQueueOptions{ZoneID: 7, FlushInterval: time.Hour, MaxPending: 3})
+
+	err = q.Add(
+		"https://example.com/img/a.png",
+		"https://example.com/img/a.png",
+		"https://example.com/index.html",
+		"https://example.com/img/*",
+		"https://example.com/img/b.png",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Pending() != 2 {
+		t.Errorf("expected 2 pending urls after coalescing, got %d", q.Pending())
+	}
+
+	f, err := q.Flush(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"https://example.com/img/*", "https://example.com/index.html"}
+	if f.FullPurge || !reflect.DeepEqual(f.URLs, want) {
+		t.Errorf("unexpected flush %+v", f)
+	}
+
+	// more than MaxPending urls escalate to a full purge right away
+	_ = q.Add("https://example.com/1", "https://example.com/2", "https://example.com/3", "https://example.com/4")
+	deadline := time.Now().Add(5 * time.Second)
+	for q.Pending() > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	_ = q.Add("https://example.com/last")
+	if err := q.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Add("https://example.com/late"); err != ErrPurgeQueueClosed {
+		t.Errorf("expected ErrPurgeQueueClosed, got %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if fullPurges != 1 {
+		t.Errorf("expected one full purge, got %d", fullPurges)
+	}
+	if len(purged) != 3 || purged[2] != "https://example.com/last" {
+		t.Errorf("unexpected purged urls %v", purged)
+	}
+}
+
+func TestPurgeQueueRequeue(t *testing.T) {
+	var mu sync.Mutex
+	failures := map[string]int{"https://example.com/flaky": 1, "full": 1}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		key := r.URL.Query().Get("url")
+		if r.URL.Path == "/pullzone/7/purgeCache" {
+			key = "full"
+		}
+		if failures[key] > 0 {
+			failures[key]--
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	c, err := NewClient("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.BaseURL, _ = url.Parse(srv.URL)
+
+	flushes := make(chan *PurgeQueueFlush, 10)
+	q := c.NewPurgeQueue(PurgeQueueOptions{ZoneID: 7, FlushInterval: time.Hour, MaxPending: 2, OnFlush: func(f *PurgeQueueFlush) {
+		flushes <- f
+	}})
+
+	_ = q.Add("https://example.com/ok", "https://example.com/flaky")
+	f, err := q.Flush(context.Background())
+	if err == nil || f.Requeued != 1 || q.Pending() != 1 {
+		t.Errorf("expected the failed url to be requeued, got %+v", f)
+	}
+	<-flushes
+	if f, err := q.Flush(context.Background()); err != nil || !reflect.DeepEqual(f.URLs, []string{"https://example.com/flaky"}) {
+		t.Errorf("unexpected retry %+v, %v", f, err)
+	}
+	<-flushes
+
+	// a failed full purge keeps all urls
+	_ = q.Add("https://example.com/1", "https://example.com/2", "https://example.com/3")
+	select {
+	case f := <-flushes:
+		if !f.FullPurge || f.Err == nil || f.Requeued != 3 {
+			t.Errorf("unexpected flush %+v", f)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("queue didn't escalate to a full purge")
+	}
+	if q.Pending() != 3 {
+		t.Errorf("expected 3 pending urls, got %d", q.Pending())
+	}
+
+	if err := q.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if q.Pending() != 0 {
+		t.Errorf("expected no pending urls after close, got %d", q.Pending())
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2021 Jan Koppe
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package bunny
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestPullZoneURLs(t *testing.T) {
+	pz := &PullZone{
+		Name: "mysite",
+		Hostnames: []PullZoneHostname{
+			{Value: "mysite.b-cdn.net", IsSystemHostname: true},
+			{Value: "CDN.example.com"},
+		},
+	}
+
+	urls := PullZoneURLs(pz, "images/*")
+	want := []string{"https://mysite.b-cdn.net/images/*", "https://cdn.example.com/images/*"}
+	if !reflect.DeepEqual(urls, want) {
+		t.Errorf("got %v, want %v", urls, want)
+	}
+
+	// the system hostname is added if the zone details don't include it
+	pz.Hostnames = pz.Hostnames[1:]
+	urls = PullZoneURLs(pz, "/index.html")
+	want = []string{"https://cdn.example.com/index.html", "https://mysite.b-cdn.net/index.html"}
+	if !reflect.DeepEqual(urls, want) {
+		t.Errorf("got %v, want %v", urls, want)
+	}
+}
+
+func TestPurgePullZonePath(t *testing.T) {
+	queries := []url.Values{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.Query())
+	}))
+	defer srv.Close()
+
+	c, err := NewClient("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.BaseURL, _ = url.Parse(srv.URL)
+
+	pz := &PullZone{Name: "mysite"}
+	opts := &PurgeURLsOptions{Concurrency: 1, PurgeOptions: PurgeOptions{Async: true}}
+	report, err := c.PurgePullZonePath(context.Background(), pz, "/assets/*", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Succeeded != 1 || len(queries) != 1 {
+		t.Fatalf("expected a single purge, got %v", report)
+	}
+	if queries[0].Get("url") != "https://mysite.b-cdn.net/assets/*" || queries[0].Get("async") != "true" {
+		t.Errorf("unexpected purge query %v", queries[0])
+	}
+
+	if _, err := NormalizePurgeURL("https://example.com/*/index.html"); err == nil {
+		t.Error("expected error for wildcard in the middle of the url")
+	}
+}
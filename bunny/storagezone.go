@@ -20,11 +20,58 @@
 package bunny
 
 import (
+	"errors"
 	"fmt"
 	"net/url"
 	"strconv"
 )
 
+// StorageRegion is the code of a storage region, used for the primary region
+// of a StorageZone and its replication regions.
+type StorageRegion string
+
+const (
+	SRFalkenstein  StorageRegion = "DE"
+	SRLondon       StorageRegion = "UK"
+	SRStockholm    StorageRegion = "SE"
+	SRNewYork      StorageRegion = "NY"
+	SRLosAngeles   StorageRegion = "LA"
+	SRSingapore    StorageRegion = "SG"
+	SRSydney       StorageRegion = "SYD"
+	SRSaoPaulo     StorageRegion = "BR"
+	SRJohannesburg StorageRegion = "JH"
+)
+
+// StorageRegions lists all known storage regions.
+var StorageRegions = []StorageRegion{
+	SRFalkenstein, SRLondon, SRStockholm, SRNewYork, SRLosAngeles,
+	SRSingapore, SRSydney, SRSaoPaulo, SRJohannesburg,
+}
+
+// Valid reports whether r is a known storage region.
+func (r StorageRegion) Valid() bool {
+	for _, known := range StorageRegions {
+		if r == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Endpoint returns the hostname of the Edge Storage API in the region.
+func (r StorageRegion) Endpoint() string {
+	return StorageEndpoint(string(r))
+}
+
+func validateStorageRegions(regions []StorageRegion) error {
+	for _, r := range regions {
+		if !r.Valid() {
+			return fmt.Errorf("unknown storage region %q", string(r))
+		}
+	}
+	return nil
+}
+
 type StorageZone struct {
 	ID                 int64  `json:"Id"`
 	UserID             string `json:"UserId"`
@@ -34,10 +81,12 @@ type StorageZone struct {
 	Deleted            bool
 	StorageUsed        int64
 	FilesStored        int64
-	Region             string
-	ReplicationRegions []string
+	Region             StorageRegion
+	ReplicationRegions []StorageRegion
 	PullZones          []PullZone
 	ReadOnlyPassword   string
+	Custom404FilePath  string
+	Rewrite404To200    bool
 }
 
 func (c *Client) ListStorageZones() (*[]StorageZone, error) {
@@ -50,7 +99,16 @@ func (c *Client) GetStorageZone(zoneID int64) (*StorageZone, error) {
 	return &storageZone, c.doRequest("GET", fmt.Sprintf("/storagezone/%v", zoneID), "", nil, &storageZone)
 }
 
-func (c *Client) AddStorageZone(originURL string, name string, region string, replicationRegions []string) (*StorageZone, error) {
+func (c *Client) AddStorageZone(originURL string, name string, region StorageRegion, replicationRegions []StorageRegion) (*StorageZone, error) {
+	if err := validateStorageRegions(append([]StorageRegion{region}, replicationRegions...)); err != nil {
+		return nil, err
+	}
+	for _, r := range replicationRegions {
+		if r == region {
+			return nil, fmt.Errorf("primary region %v can't also be a replication region", region)
+		}
+	}
+
 	opts := map[string]interface{}{
 		"OriginUrl":          originURL,
 		"Name":               name,
@@ -62,13 +120,73 @@ func (c *Client) AddStorageZone(originURL string, name string, region string, re
 	return &storageZone, c.doRequest("POST", "/storagezone", "", opts, &storageZone)
 }
 
-func (c *Client) UpdateStorageZone(zoneID int64, originURL string, replicationRegions []string) error {
-	opts := map[string]interface{}{
-		"OriginUrl":        originURL,
-		"ReplicationZones": replicationRegions, // sic
+// ErrReplicationRegionRemoval is returned by UpdateStorageZone for updates
+// that would remove a replication region, which bunny doesn't allow.
+var ErrReplicationRegionRemoval = errors.New("replication regions can't be removed from a storage zone")
+
+// StorageZoneUpdate holds the settable fields of a StorageZone. Nil fields
+// are left unchanged.
+type StorageZoneUpdate struct {
+	OriginURL *string `json:"OriginUrl,omitempty"`
+	// has to include all current replication regions, they can only be
+	// added
+	ReplicationRegions []StorageRegion `json:"ReplicationZones,omitempty"` // sic, the API uses a different name than for AddStorageZone
+	// path of the file served instead of 404 errors, relative to the
+	// root of the StorageZone
+	Custom404FilePath *string `json:",omitempty"`
+	// serve the custom 404 file with status 200, for single page apps
+	Rewrite404To200 *bool `json:",omitempty"`
+}
+
+// UpdateStorageZone changes the settings of a StorageZone. If replication
+// regions are updated, the current ones are fetched first, and the update is
+// refused with ErrReplicationRegionRemoval if any of them is missing.
+func (c *Client) UpdateStorageZone(zoneID int64, update StorageZoneUpdate) error {
+	if update.ReplicationRegions != nil {
+		if err := validateStorageRegions(update.ReplicationRegions); err != nil {
+			return err
+		}
+		sz, err := c.GetStorageZone(zoneID)
+		if err != nil {
+			return err
+		}
+		for _, current := range sz.ReplicationRegions {
+			found := false
+			for _, r := range update.ReplicationRegions {
+				found = found || r == current
+			}
+			if !found {
+				return fmt.Errorf("%w: %v is missing", ErrReplicationRegionRemoval, current)
+			}
+		}
+		for _, r := range update.ReplicationRegions {
+			if r == sz.Region {
+				return fmt.Errorf("primary region %v can't also be a replication region", r)
+			}
+		}
 	}
 
-	return c.doRequest("POST", fmt.Sprintf("/storagezone/%v", zoneID), "", opts, nil)
+	return c.doRequest("POST", fmt.Sprintf("/storagezone/%v", zoneID), "", update, nil)
+}
+
+// AddStorageZoneReplicationRegions adds replication regions to a
+// StorageZone, keeping the existing ones.
+func (c *Client) AddStorageZoneReplicationRegions(zoneID int64, regions ...StorageRegion) error {
+	sz, err := c.GetStorageZone(zoneID)
+	if err != nil {
+		return err
+	}
+	merged := append([]StorageRegion{}, sz.ReplicationRegions...)
+	for _, r := range regions {
+		found := false
+		for _, m := range merged {
+			found = found || r == m
+		}
+		if !found {
+			merged = append(merged, r)
+		}
+	}
+	return c.UpdateStorageZone(zoneID, StorageZoneUpdate{ReplicationRegions: merged})
 }
 
 func (c *Client) DeleteStorageZone(zoneID int64) error {
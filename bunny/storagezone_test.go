@@ -20,6 +20,12 @@
 package bunny
 
 import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
 	"testing"
 )
 
@@ -50,3 +56,63 @@ func TestReadStorageZones(t *testing.T) {
 	//	t.Errorf(err.Error())
 	//}
 }
+
+func TestUpdateStorageZone(t *testing.T) {
+	updates := []map[string]interface{}{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			_ = json.NewEncoder(w).Encode(StorageZone{ID: 3, Region: SRFalkenstein, ReplicationRegions: []StorageRegion{SRNewYork}})
+		case "POST":
+			u := map[string]interface{}{}
+			_ = json.NewDecoder(r.Body).Decode(&u)
+			updates = append(updates, u)
+		}
+	}))
+	defer srv.Close()
+
+	c, err := NewClient("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.BaseURL, _ = url.Parse(srv.URL)
+
+	path, rewrite := "/index.html", true
+	if err := c.UpdateStorageZone(3, StorageZoneUpdate{Custom404FilePath: &path, Rewrite404To200: &rewrite}); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"Custom404FilePath": "/index.html", "Rewrite404To200": true}
+	if len(updates) != 1 || !reflect.DeepEqual(updates[0], want) {
+		t.Errorf("got updates %v, want %v", updates, want)
+	}
+
+	err = c.UpdateStorageZone(3, StorageZoneUpdate{ReplicationRegions: []StorageRegion{SRSingapore}})
+	if !errors.Is(err, ErrReplicationRegionRemoval) {
+		t.Errorf("expected replication region removal error, got %v", err)
+	}
+	if err := c.UpdateStorageZone(3, StorageZoneUpdate{ReplicationRegions: []StorageRegion{"XX"}}); err == nil {
+		t.Error("unknown regions should be refused")
+	}
+
+	if err := c.AddStorageZoneReplicationRegions(3, SRSingapore, SRNewYork); err != nil {
+		t.Fatal(err)
+	}
+	if got := updates[len(updates)-1]["ReplicationZones"]; !reflect.DeepEqual(got, []interface{}{"NY", "SG"}) {
+		t.Errorf("unexpected replication regions %v", got)
+	}
+	if len(updates) != 2 {
+		t.Errorf("refused updates were sent: %v", updates)
+	}
+}
+
+func TestStorageRegion(t *testing.T) {
+	if !SRSydney.Valid() || StorageRegion("XX").Valid() {
+		t.Error("unexpected region validation")
+	}
+	if got := SRSydney.Endpoint(); got != "syd.storage.bunnycdn.com" {
+		t.Errorf("unexpected endpoint %v", got)
+	}
+	if got := SRFalkenstein.Endpoint(); got != "storage.bunnycdn.com" {
+		t.Errorf("unexpected endpoint %v", got)
+	}
+}
@@ -0,0 +1,458 @@
+// Copyright (c) 2021 Jan Koppe
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package bunny
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// The Stream API manages the videos of a VideoLibrary. Like the Edge Storage
+// API it is separate from the main API, and is authenticated with the API
+// key of the library.
+
+type StreamClient struct {
+	BaseURL    *url.URL
+	LibraryID  int64
+	AccessKey  string
+	httpClient *http.Client
+}
+
+// StreamErrorResponse is returned by the Stream API for failed requests. It
+// matches os.ErrNotExist for missing videos.
+type StreamErrorResponse struct {
+	Success    bool   `json:"success"`
+	Message    string `json:"message"`
+	StatusCode int    `json:"statusCode"`
+}
+
+func (r *StreamErrorResponse) Error() string {
+	return fmt.Sprintf("%v, StatusCode: %v", r.Message, r.StatusCode)
+}
+
+func (r *StreamErrorResponse) Is(target error) bool {
+	return target == os.ErrNotExist && r.StatusCode == http.StatusNotFound
+}
+
+type VideoStatus int32
+
+const (
+	VSCreated      VideoStatus = 0
+	VSUploaded     VideoStatus = 1
+	VSProcessing   VideoStatus = 2
+	VSTranscoding  VideoStatus = 3
+	VSFinished     VideoStatus = 4
+	VSError        VideoStatus = 5
+	VSUploadFailed VideoStatus = 6
+)
+
+var videoStatusNames = []string{"Created", "Uploaded", "Processing", "Transcoding", "Finished", "Error", "UploadFailed"}
+
+func (s VideoStatus) String() string {
+	return formatEnum(videoStatusNames, "VideoStatus", int32(s))
+}
+
+// Done reports whether the video finished processing, successfully or not.
+func (s VideoStatus) Done() bool {
+	return s == VSFinished || s == VSError || s == VSUploadFailed
+}
+
+// VideoChapter is a named section of a video. Start and End are in seconds.
+type VideoChapter struct {
+	Title string `json:"title"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+// VideoMoment is a labeled point in a video, in seconds.
+type VideoMoment struct {
+	Label     string `json:"label"`
+	Timestamp int    `json:"timestamp"`
+}
+
+type VideoMetaTag struct {
+	Property string `json:"property"`
+	Value    string `json:"value"`
+}
+
+type VideoCaption struct {
+	SrcLang string `json:"srclang"`
+	Label   string `json:"label"`
+}
+
+type VideoTranscodingMessage struct {
+	TimeStamp BunnyTime `json:"timeStamp"`
+	Level     int       `json:"level"`
+	IssueCode int       `json:"issueCode"`
+	Message   string    `json:"message"`
+	Value     string    `json:"value"`
+}
+
+type Video struct {
+	VideoLibraryID       int64                     `json:"videoLibraryId"`
+	Guid                 string                    `json:"guid"`
+	Title                string                    `json:"title"`
+	DateUploaded         BunnyTime                 `json:"dateUploaded"`
+	Views                int64                     `json:"views"`
+	IsPublic             bool                      `json:"isPublic"`
+	Length               int                       `json:"length"`
+	Status               VideoStatus               `json:"status"`
+	Framerate            float64                   `json:"framerate"`
+	Rotation             int                       `json:"rotation"`
+	Width                int                       `json:"width"`
+	Height               int                       `json:"height"`
+	AvailableResolutions string                    `json:"availableResolutions"`
+	ThumbnailCount       int                       `json:"thumbnailCount"`
+	EncodeProgress       int                       `json:"encodeProgress"`
+	StorageSize          int64                     `json:"storageSize"`
+	Captions             []VideoCaption            `json:"captions"`
+	HasMP4Fallback       bool                      `json:"hasMP4Fallback"`
+	CollectionID         string                    `json:"collectionId"`
+	ThumbnailFileName    string                    `json:"thumbnailFileName"`
+	AverageWatchTime     int64                     `json:"averageWatchTime"`
+	TotalWatchTime       int64                     `json:"totalWatchTime"`
+	Category             string                    `json:"category"`
+	Chapters             []VideoChapter            `json:"chapters"`
+	Moments              []VideoMoment             `json:"moments"`
+	MetaTags             []VideoMetaTag            `json:"metaTags"`
+	TranscodingMessages  []VideoTranscodingMessage `json:"transcodingMessages"`
+}
+
+// Resolutions returns the resolutions the video is available in, e.g.
+// "720p".
+func (v *Video) Resolutions() []string {
+	res := []string{}
+	for _, r := range strings.Split(v.AvailableResolutions, ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			res = append(res, r)
+		}
+	}
+	return res
+}
+
+// NewStreamClient creates a client for the Stream API of a VideoLibrary. The
+// endpoint can be overridden with the BUNNYCDN_STREAM_URL environment
+// variable.
+func NewStreamClient(libraryID int64, apiKey string) (*StreamClient, error) {
+	if libraryID == 0 {
+		return nil, errors.New("required video library id not provided")
+	}
+	if apiKey == "" {
+		return nil, errors.New("required video library api key not provided")
+	}
+
+	baseurl := "https://video.bunnycdn.com/"
+
+	if envurl := os.Getenv("BUNNYCDN_STREAM_URL"); envurl != "" {
+		baseurl = envurl
+	}
+
+	u, err := url.Parse(baseurl)
+	if err != nil {
+		return nil, err
+	}
+
+	// no overall timeout, as for the Edge Storage API
+	h := &http.Client{}
+
+	s := &StreamClient{
+		BaseURL:    u,
+		LibraryID:  libraryID,
+		AccessKey:  apiKey,
+		httpClient: h,
+	}
+
+	return s, nil
+}
+
+// NewStreamClientForLibrary creates a client for the Stream API of a
+// VideoLibrary as returned by GetVideoLibrary.
+func NewStreamClientForLibrary(lib *VideoLibrary) (*StreamClient, error) {
+	return NewStreamClient(lib.ID, lib.APIKey)
+}
+
+// newRequest creates a request for a path relative to the library, e.g.
+// "videos". body is sent as JSON, unless it is an io.Reader.
+func (s *StreamClient) newRequest(ctx context.Context, method string, p string, query url.Values, body interface{}) (*http.Request, error) {
+	rel := &url.URL{Path: fmt.Sprintf("library/%d/%v", s.LibraryID, p)}
+	u := s.BaseURL.ResolveReference(rel)
+	if query != nil {
+		u.RawQuery = query.Encode()
+	}
+
+	var r io.Reader
+	contentType := ""
+	switch b := body.(type) {
+	case nil:
+	case io.Reader:
+		r = b
+		contentType = "application/octet-stream"
+	default:
+		buf := new(bytes.Buffer)
+		if err := json.NewEncoder(buf).Encode(body); err != nil {
+			return nil, err
+		}
+		r = buf
+		contentType = "application/json"
+	}
+
+	req, err := http.NewRequest(method, u.String(), r)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("User-Agent", "go-bunnynet/dev")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("AccessKey", s.AccessKey)
+
+	return req, nil
+}
+
+// send sends the request and returns the response for 2xx status codes. The
+// caller has to close the body.
+func (s *StreamClient) send(req *http.Request) (*http.Response, error) {
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+
+	defer resp.Body.Close()
+	msg := StreamErrorResponse{}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err := json.Unmarshal(body, &msg); err != nil || msg.Message == "" {
+		msg.Message = strings.TrimSpace(string(body))
+		if msg.Message == "" {
+			msg.Message = http.StatusText(resp.StatusCode)
+		}
+	}
+	msg.StatusCode = resp.StatusCode
+	return resp, &msg
+}
+
+// do sends the request and decodes a JSON response into v, if v is not nil.
+func (s *StreamClient) do(req *http.Request, v interface{}) error {
+	resp, err := s.send(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if v == nil {
+		return nil
+	}
+	err = json.NewDecoder(resp.Body).Decode(v)
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+func (s *StreamClient) doRequest(ctx context.Context, method string, p string, query url.Values, body interface{}, v interface{}) error {
+	req, err := s.newRequest(ctx, method, p, query, body)
+	if err != nil {
+		return err
+	}
+	return s.do(req, v)
+}
+
+// CreateVideoOptions holds the optional parameters of CreateVideo.
+type CreateVideoOptions struct {
+	CollectionID string `json:"collectionId,omitempty"`
+	// time in milliseconds to extract the main thumbnail from
+	ThumbnailTime int `json:"thumbnailTime,omitempty"`
+}
+
+// CreateVideo creates an empty video, which the content is uploaded into
+// afterwards.
+func (s *StreamClient) CreateVideo(ctx context.Context, title string, opts *CreateVideoOptions) (*Video, error) {
+	o := CreateVideoOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	body := struct {
+		Title string `json:"title"`
+		CreateVideoOptions
+	}{title, o}
+
+	var video Video
+	return &video, s.doRequest(ctx, "POST", "videos", nil, body, &video)
+}
+
+func (s *StreamClient) GetVideo(ctx context.Context, videoID string) (*Video, error) {
+	var video Video
+	return &video, s.doRequest(ctx, "GET", "videos/"+url.PathEscape(videoID), nil, nil, &video)
+}
+
+// VideoUpdate holds the settable fields of a Video. Nil fields are left
+// unchanged, a non-nil empty slice removes all chapters, moments or meta
+// tags.
+type VideoUpdate struct {
+	Title        *string
+	CollectionID *string
+	Chapters     []VideoChapter
+	Moments      []VideoMoment
+	MetaTags     []VideoMetaTag
+}
+
+// MarshalJSON omits nil fields, but keeps empty slices.
+func (u VideoUpdate) MarshalJSON() ([]byte, error) {
+	m := map[string]interface{}{}
+	if u.Title != nil {
+		m["title"] = *u.Title
+	}
+	if u.CollectionID != nil {
+		m["collectionId"] = *u.CollectionID
+	}
+	if u.Chapters != nil {
+		m["chapters"] = u.Chapters
+	}
+	if u.Moments != nil {
+		m["moments"] = u.Moments
+	}
+	if u.MetaTags != nil {
+		m["metaTags"] = u.MetaTags
+	}
+	return json.Marshal(m)
+}
+
+func (s *StreamClient) UpdateVideo(ctx context.Context, videoID string, update VideoUpdate) error {
+	return s.doRequest(ctx, "POST", "videos/"+url.PathEscape(videoID), nil, update, nil)
+}
+
+func (s *StreamClient) DeleteVideo(ctx context.Context, videoID string) error {
+	return s.doRequest(ctx, "DELETE", "videos/"+url.PathEscape(videoID), nil, nil, nil)
+}
+
+// UploadVideo uploads the content of a video created with CreateVideo in a
+// single request. Encoding starts once the upload is complete.
+func (s *StreamClient) UploadVideo(ctx context.Context, videoID string, r io.Reader) error {
+	return s.doRequest(ctx, "PUT", "videos/"+url.PathEscape(videoID), nil, r, nil)
+}
+
+type VideoOrder string
+
+const (
+	VODate  VideoOrder = "date"
+	VOTitle VideoOrder = "title"
+)
+
+// ListVideosOptions filters and pages the videos returned by ListVideos.
+type ListVideosOptions struct {
+	// page to return, starting at 1
+	Page int
+	// defaults to 100, the maximum allowed by the API
+	ItemsPerPage int
+	// only videos whose title contains Search
+	Search string
+	// only videos in the collection with this id
+	Collection string
+	// defaults to VODate, newest first
+	OrderBy VideoOrder
+}
+
+// VideoList is a page of videos.
+type VideoList struct {
+	TotalItems   int     `json:"totalItems"`
+	CurrentPage  int     `json:"currentPage"`
+	ItemsPerPage int     `json:"itemsPerPage"`
+	Items        []Video `json:"items"`
+}
+
+// HasMore reports whether there are pages after this one.
+func (l *VideoList) HasMore() bool {
+	return l.CurrentPage*l.ItemsPerPage < l.TotalItems
+}
+
+// ListVideos returns a single page of videos of the library.
+func (s *StreamClient) ListVideos(ctx context.Context, opts *ListVideosOptions) (*VideoList, error) {
+	o := ListVideosOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.Page <= 0 {
+		o.Page = 1
+	}
+	if o.ItemsPerPage <= 0 {
+		o.ItemsPerPage = 100
+	}
+
+	q := url.Values{}
+	q.Set("page", strconv.Itoa(o.Page))
+	q.Set("itemsPerPage", strconv.Itoa(o.ItemsPerPage))
+	if o.Search != "" {
+		q.Set("search", o.Search)
+	}
+	if o.Collection != "" {
+		q.Set("collection", o.Collection)
+	}
+	if o.OrderBy != "" {
+		q.Set("orderBy", string(o.OrderBy))
+	}
+
+	var list VideoList
+	return &list, s.doRequest(ctx, "GET", "videos", q, nil, &list)
+}
+
+// WalkVideos calls fn for every video matching the options, fetching the
+// pages as needed, starting at opts.Page. Iteration stops at the first error
+// returned by fn, which is returned as is.
+func (s *StreamClient) WalkVideos(ctx context.Context, opts *ListVideosOptions, fn func(Video) error) error {
+	o := ListVideosOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.Page <= 0 {
+		o.Page = 1
+	}
+
+	for {
+		list, err := s.ListVideos(ctx, &o)
+		if err != nil {
+			return err
+		}
+		for _, v := range list.Items {
+			if err := fn(v); err != nil {
+				return err
+			}
+		}
+		if !list.HasMore() || len(list.Items) == 0 {
+			return nil
+		}
+		o.Page++
+	}
+}
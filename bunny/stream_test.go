@@ -0,0 +1,241 @@
+// Copyright (c) 2021 Jan Koppe
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package bunny
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeStream implements the video endpoints of the Stream API for library
+// 42 with api key "apikey".
+type fakeStream struct {
+	mu      sync.Mutex
+	videos  map[string]*Video
+	content map[string][]byte
+	updates []map[string]interface{}
+	nextID  int
+}
+
+func newFakeStream() *fakeStream {
+	return &fakeStream{videos: map[string]*Video{}, content: map[string][]byte{}}
+}
+
+func (f *fakeStream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fail := func(code int) {
+		w.WriteHeader(code)
+		_ = json.NewEncoder(w).Encode(StreamErrorResponse{Message: http.StatusText(code), StatusCode: code})
+	}
+	if r.Header.Get("AccessKey") != "apikey" {
+		fail(http.StatusUnauthorized)
+		return
+	}
+	p := strings.TrimPrefix(r.URL.Path, "/library/42/videos")
+	if p == r.URL.Path {
+		fail(http.StatusNotFound)
+		return
+	}
+
+	if p == "" {
+		switch r.Method {
+		case "GET":
+			f.list(w, r)
+		case "POST":
+			body := struct {
+				Title        string
+				CollectionID string `json:"collectionId"`
+			}{}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			f.nextID++
+			v := &Video{VideoLibraryID: 42, Guid: fmt.Sprintf("video-%d", f.nextID), Title: body.Title, CollectionID: body.CollectionID}
+			f.videos[v.Guid] = v
+			_ = json.NewEncoder(w).Encode(v)
+		}
+		return
+	}
+
+	v := f.videos[strings.TrimPrefix(p, "/")]
+	if v == nil {
+		fail(http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		_ = json.NewEncoder(w).Encode(v)
+	case "POST":
+		u := map[string]interface{}{}
+		b, _ := ioutil.ReadAll(r.Body)
+		_ = json.Unmarshal(b, &u)
+		f.updates = append(f.updates, u)
+		_ = json.Unmarshal(b, v)
+	case "PUT":
+		f.content[v.Guid], _ = ioutil.ReadAll(r.Body)
+		v.Status = VSUploaded
+	case "DELETE":
+		delete(f.videos, v.Guid)
+	}
+}
+
+func (f *fakeStream) list(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	page, _ := strconv.Atoi(q.Get("page"))
+	perPage, _ := strconv.Atoi(q.Get("itemsPerPage"))
+
+	matching := []Video{}
+	for _, v := range f.videos {
+		if strings.Contains(v.Title, q.Get("search")) && (q.Get("collection") == "" || v.CollectionID == q.Get("collection")) {
+			matching = append(matching, *v)
+		}
+	}
+	sort.Slice(matching, func(i, j int) bool { return matching[i].Title < matching[j].Title })
+
+	list := VideoList{TotalItems: len(matching), CurrentPage: page, ItemsPerPage: perPage, Items: []Video{}}
+	for i := (page - 1) * perPage; i < len(matching) && i < page*perPage; i++ {
+		list.Items = append(list.Items, matching[i])
+	}
+	_ = json.NewEncoder(w).Encode(list)
+}
+
+func newFakeStreamClient(t *testing.T, f http.Handler) (*StreamClient, func()) {
+	srv := httptest.NewServer(f)
+	os.Setenv("BUNNYCDN_STREAM_URL", srv.URL)
+	defer os.Unsetenv("BUNNYCDN_STREAM_URL")
+
+	s, err := NewStreamClientForLibrary(&VideoLibrary{ID: 42, APIKey: "apikey"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, srv.Close
+}
+
+func TestStreamVideos(t *testing.T) {
+	f := newFakeStream()
+	s, done := newFakeStreamClient(t, f)
+	defer done()
+	ctx := context.Background()
+
+	v, err := s.CreateVideo(ctx, "intro", &CreateVideoOptions{CollectionID: "talks"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Guid == "" || v.Title != "intro" || v.CollectionID != "talks" || v.Status != VSCreated {
+		t.Errorf("unexpected video %+v", v)
+	}
+	if err := s.UploadVideo(ctx, v.Guid, strings.NewReader("mp4 data")); err != nil {
+		t.Fatal(err)
+	}
+	if string(f.content[v.Guid]) != "mp4 data" {
+		t.Errorf("unexpected content %q", f.content[v.Guid])
+	}
+
+	title := "Introduction"
+	err = s.UpdateVideo(ctx, v.Guid, VideoUpdate{
+		Title:    &title,
+		Chapters: []VideoChapter{{"Welcome", 0, 30}},
+		Moments:  []VideoMoment{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"title":    "Introduction",
+		"chapters": []interface{}{map[string]interface{}{"title": "Welcome", "start": 0.0, "end": 30.0}},
+		"moments":  []interface{}{},
+	}
+	if !reflect.DeepEqual(f.updates[0], want) {
+		t.Errorf("got update %v, want %v", f.updates[0], want)
+	}
+
+	v, err = s.GetVideo(ctx, v.Guid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Title != "Introduction" || len(v.Chapters) != 1 || v.Status != VSUploaded || v.Status.Done() {
+		t.Errorf("unexpected video %+v", v)
+	}
+
+	if err := s.DeleteVideo(ctx, v.Guid); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetVideo(ctx, v.Guid); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected a not exist error, got %v", err)
+	}
+}
+
+func TestStreamListVideos(t *testing.T) {
+	f := newFakeStream()
+	for i := 0; i < 7; i++ {
+		id := fmt.Sprintf("v%d", i)
+		f.videos[id] = &Video{Guid: id, Title: fmt.Sprintf("episode %d", i)}
+	}
+	f.videos["trailer"] = &Video{Guid: "trailer", Title: "trailer", AvailableResolutions: "360p,720p"}
+	s, done := newFakeStreamClient(t, f)
+	defer done()
+	ctx := context.Background()
+
+	list, err := s.ListVideos(ctx, &ListVideosOptions{Page: 2, ItemsPerPage: 3, Search: "episode"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.TotalItems != 7 || len(list.Items) != 3 || list.Items[0].Title != "episode 3" || !list.HasMore() {
+		t.Errorf("unexpected page %+v", list)
+	}
+
+	titles := []string{}
+	err = s.WalkVideos(ctx, &ListVideosOptions{ItemsPerPage: 3}, func(v Video) error {
+		titles = append(titles, v.Title)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(titles) != 8 || titles[7] != "trailer" {
+		t.Errorf("unexpected videos %v", titles)
+	}
+
+	list, err = s.ListVideos(ctx, &ListVideosOptions{Search: "trailer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := list.Items[0].Resolutions(); !reflect.DeepEqual(res, []string{"360p", "720p"}) {
+		t.Errorf("unexpected resolutions %v", res)
+	}
+
+	wrong, _ := NewStreamClient(42, "wrong")
+	wrong.BaseURL = s.BaseURL
+	if _, err := wrong.ListVideos(ctx, nil); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("expected an authorization error, got %v", err)
+	}
+}
@@ -0,0 +1,582 @@
+// Copyright (c) 2021 Jan Koppe
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package bunny
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Large videos are uploaded with the TUS resumable upload protocol, see
+// https://tus.io/protocols/resumable-upload.html. Every chunk is a separate
+// request, and an interrupted upload continues from the last chunk the
+// server acknowledged.
+
+const tusVersion = "1.0.0"
+
+// UploadSignature returns the signature authorizing TUS uploads into a video
+// until expires. It can be handed to browsers, so they can upload directly
+// without knowing the API key of the library.
+func (s *StreamClient) UploadSignature(videoID string, expires time.Time) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d%v%d%v", s.LibraryID, s.AccessKey, expires.Unix(), videoID)))
+	return hex.EncodeToString(sum[:])
+}
+
+// VideoUpload is a local video file to upload.
+type VideoUpload struct {
+	LocalPath string
+	// upload into an existing video, e.g. one created with CreateVideo.
+	// If empty, a video is created.
+	VideoID string
+	// title and collection of a created video. The title defaults to the
+	// file name without extension.
+	Title        string
+	CollectionID string
+}
+
+func (u VideoUpload) title() string {
+	if u.Title != "" {
+		return u.Title
+	}
+	return strings.TrimSuffix(filepath.Base(u.LocalPath), filepath.Ext(u.LocalPath))
+}
+
+// VideoUploadProgress is passed to VideoUploadOptions.OnProgress while a
+// video is uploaded.
+type VideoUploadProgress struct {
+	VideoUpload
+	VideoID string
+	// bytes of the file uploaded so far, including resumed ones
+	Bytes   int64
+	Total   int64
+	Attempt int
+	Done    bool
+}
+
+func (p VideoUploadProgress) String() string {
+	if p.Total <= 0 {
+		return fmt.Sprintf("upload %v: %v", p.LocalPath, formatBytes(p.Bytes))
+	}
+	return fmt.Sprintf("upload %v: %v / %v (%.1f%%)", p.LocalPath, formatBytes(p.Bytes), formatBytes(p.Total), float64(p.Bytes)*100/float64(p.Total))
+}
+
+// VideoUploadOptions configures UploadVideos. The zero value is usable.
+type VideoUploadOptions struct {
+	// number of videos uploaded at the same time, defaults to 2
+	Concurrency int
+	// size of the chunks sent in a single request, defaults to 16 MiB. An
+	// interrupted chunk is sent again completely.
+	ChunkSize int64
+	// retries of a failed video, defaults to 3. Use a negative value to
+	// disable retries. Retries continue where the failed attempt stopped.
+	Retries int
+	// delay before the first retry, doubled for every further one.
+	// Defaults to one second.
+	RetryDelay time.Duration
+	// called from the goroutine uploading the video, at most every
+	// ProgressInterval and once when the video is done
+	OnProgress func(VideoUploadProgress)
+	// defaults to 500ms
+	ProgressInterval time.Duration
+	// file recording the upload URLs and offsets of the videos, so uploads
+	// interrupted in an earlier run continue where they stopped. Videos
+	// that were uploaded completely are skipped, unless the local file
+	// changed.
+	StateFile string
+	// validity of the signature sent with every request, defaults to an
+	// hour
+	SignatureExpiry time.Duration
+}
+
+// VideoUploadResult is the outcome of a single VideoUpload. Resumed is the
+// number of bytes that were uploaded in an earlier run already.
+type VideoUploadResult struct {
+	VideoUpload
+	VideoID  string
+	Bytes    int64
+	Resumed  int64
+	Attempts int
+	Err      error
+}
+
+// VideoUploadReport summarizes an UploadVideos run.
+type VideoUploadReport struct {
+	Results   []VideoUploadResult
+	Succeeded int
+	Failed    int
+	Bytes     int64
+}
+
+// Failures returns the results of all videos that could not be uploaded.
+func (r *VideoUploadReport) Failures() []VideoUploadResult {
+	failed := []VideoUploadResult{}
+	for _, res := range r.Results {
+		if res.Err != nil {
+			failed = append(failed, res)
+		}
+	}
+	return failed
+}
+
+func (r *VideoUploadReport) String() string {
+	return fmt.Sprintf("%d videos, %d uploaded, %d failed, %v", len(r.Results), r.Succeeded, r.Failed, formatBytes(r.Bytes))
+}
+
+// UploadVideos uploads video files in parallel with the TUS protocol. Failed
+// uploads are retried from the last acknowledged chunk, and with a
+// StateFile they can also be continued in a later run.
+//
+// Every upload gets a result in the report, in the given order. An error is
+// only returned if the context was cancelled, or the state file can't be
+// opened.
+func (s *StreamClient) UploadVideos(ctx context.Context, uploads []VideoUpload, opts *VideoUploadOptions) (*VideoUploadReport, error) {
+	o := VideoUploadOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.Concurrency <= 0 {
+		o.Concurrency = 2
+	}
+	if o.ChunkSize <= 0 {
+		o.ChunkSize = 16 << 20
+	}
+	if o.Retries == 0 {
+		o.Retries = 3
+	} else if o.Retries < 0 {
+		o.Retries = 0
+	}
+	if o.RetryDelay <= 0 {
+		o.RetryDelay = time.Second
+	}
+	if o.ProgressInterval <= 0 {
+		o.ProgressInterval = 500 * time.Millisecond
+	}
+	if o.SignatureExpiry <= 0 {
+		o.SignatureExpiry = time.Hour
+	}
+
+	state := &tusState{uploads: map[string]tusStateEntry{}}
+	if o.StateFile != "" {
+		var err error
+		state, err = openTUSState(o.StateFile)
+		if err != nil {
+			return nil, err
+		}
+		defer state.Close()
+	}
+
+	report := &VideoUploadReport{Results: make([]VideoUploadResult, len(uploads))}
+	for i, u := range uploads {
+		report.Results[i].VideoUpload = u
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < o.Concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				s.uploadWithRetry(ctx, &report.Results[i], o, state)
+			}
+		}()
+	}
+
+	var ctxErr error
+	for i := range report.Results {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			ctxErr = ctx.Err()
+		}
+		if ctxErr != nil {
+			for j := i; j < len(report.Results); j++ {
+				report.Results[j].Err = ctxErr
+			}
+			break
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, res := range report.Results {
+		if res.Err != nil {
+			report.Failed++
+		} else {
+			report.Succeeded++
+			report.Bytes += res.Bytes
+		}
+	}
+
+	return report, ctxErr
+}
+
+func (s *StreamClient) uploadWithRetry(ctx context.Context, res *VideoUploadResult, o VideoUploadOptions, state *tusState) {
+	info, err := os.Stat(res.LocalPath)
+	if err != nil {
+		res.Err = err
+		return
+	}
+	abs, err := filepath.Abs(res.LocalPath)
+	if err != nil {
+		res.Err = err
+		return
+	}
+	entry, ok := state.lookup(abs, info)
+	if !ok || (res.VideoUpload.VideoID != "" && entry.VideoID != res.VideoUpload.VideoID) {
+		entry = tusStateEntry{Path: abs, Size: info.Size(), ModTime: info.ModTime(), VideoID: res.VideoUpload.VideoID}
+	}
+	if entry.Done {
+		res.VideoID = entry.VideoID
+		res.Resumed = entry.Size
+		return
+	}
+
+	delay := o.RetryDelay
+	for {
+		res.Attempts++
+		var transient bool
+		transient, res.Err = s.tusUpload(ctx, res, &entry, o, state)
+		if res.Err == nil || res.Attempts > o.Retries || !transient || ctx.Err() != nil {
+			return
+		}
+
+		t := time.NewTimer(delay)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			res.Err = ctx.Err()
+			return
+		}
+		delay *= 2
+	}
+}
+
+// tusUpload makes a single attempt to upload a video, continuing the upload
+// of entry if it has one. The returned bool reports whether the error might
+// go away when retried.
+func (s *StreamClient) tusUpload(ctx context.Context, res *VideoUploadResult, entry *tusStateEntry, o VideoUploadOptions, state *tusState) (bool, error) {
+	f, err := os.Open(res.LocalPath)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	if entry.VideoID == "" {
+		v, err := s.CreateVideo(ctx, res.title(), &CreateVideoOptions{CollectionID: res.CollectionID})
+		if err != nil {
+			return isTransientStreamError(err), err
+		}
+		entry.VideoID = v.Guid
+		if err := state.record(*entry); err != nil {
+			return false, err
+		}
+	}
+	res.VideoID = entry.VideoID
+
+	offset := int64(0)
+	if entry.UploadURL != "" {
+		offset, err = s.tusOffset(ctx, entry, o)
+		var se *StreamErrorResponse
+		if errors.As(err, &se) && (se.StatusCode == http.StatusNotFound || se.StatusCode == http.StatusGone) {
+			// the upload expired, start over
+			entry.UploadURL = ""
+			offset, err = 0, nil
+		}
+		if err != nil {
+			return isTransientStreamError(err), err
+		}
+	}
+	if entry.UploadURL == "" {
+		if err := s.tusCreate(ctx, res, entry, o); err != nil {
+			return isTransientStreamError(err), err
+		}
+	}
+	if res.Attempts == 1 {
+		res.Resumed = offset
+	}
+	entry.Offset = offset
+	if err := state.record(*entry); err != nil {
+		return false, err
+	}
+
+	p := &videoProgress{res: res, o: o, n: offset, total: entry.Size}
+	for entry.Offset < entry.Size {
+		n := entry.Size - entry.Offset
+		if n > o.ChunkSize {
+			n = o.ChunkSize
+		}
+		p.n = entry.Offset
+		body := &videoProgressReader{r: io.NewSectionReader(f, entry.Offset, n), p: p}
+		req, err := s.newTUSRequest(ctx, "PATCH", entry.UploadURL, entry.VideoID, body, o)
+		if err != nil {
+			return false, err
+		}
+		req.ContentLength = n
+		req.Header.Set("Content-Type", "application/offset+octet-stream")
+		req.Header.Set("Upload-Offset", strconv.FormatInt(entry.Offset, 10))
+
+		resp, err := s.send(req)
+		var se *StreamErrorResponse
+		if errors.As(err, &se) && (se.StatusCode == http.StatusNotFound || se.StatusCode == http.StatusGone) {
+			// the upload expired, the next attempt starts over
+			entry.UploadURL = ""
+			return true, err
+		}
+		if err != nil {
+			return isTransientStreamError(err), err
+		}
+		resp.Body.Close()
+		next, err := strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+		if err != nil || next <= entry.Offset || next > entry.Size {
+			return true, fmt.Errorf("unexpected Upload-Offset %q after a chunk at %d", resp.Header.Get("Upload-Offset"), entry.Offset)
+		}
+
+		res.Bytes += next - entry.Offset
+		entry.Offset = next
+		if err := state.record(*entry); err != nil {
+			return false, err
+		}
+	}
+
+	entry.Done = true
+	if err := state.record(*entry); err != nil {
+		return false, err
+	}
+	p.n = entry.Offset
+	p.finish()
+	return false, nil
+}
+
+func (s *StreamClient) tusEndpoint() *url.URL {
+	return s.BaseURL.ResolveReference(&url.URL{Path: "tusupload"})
+}
+
+func (s *StreamClient) newTUSRequest(ctx context.Context, method string, u string, videoID string, body io.Reader, o VideoUploadOptions) (*http.Request, error) {
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+
+	expires := time.Now().Add(o.SignatureExpiry)
+	req.Header.Set("User-Agent", "go-bunnynet/dev")
+	req.Header.Set("Tus-Resumable", tusVersion)
+	req.Header.Set("AuthorizationSignature", s.UploadSignature(videoID, expires))
+	req.Header.Set("AuthorizationExpire", strconv.FormatInt(expires.Unix(), 10))
+	req.Header.Set("VideoId", videoID)
+	req.Header.Set("LibraryId", strconv.FormatInt(s.LibraryID, 10))
+
+	return req, nil
+}
+
+// tusCreate creates the TUS upload for entry and sets its UploadURL.
+func (s *StreamClient) tusCreate(ctx context.Context, res *VideoUploadResult, entry *tusStateEntry, o VideoUploadOptions) error {
+	filetype := mime.TypeByExtension(filepath.Ext(res.LocalPath))
+	if filetype == "" {
+		filetype = "application/octet-stream"
+	}
+	meta := []string{
+		"filetype " + base64.StdEncoding.EncodeToString([]byte(filetype)),
+		"title " + base64.StdEncoding.EncodeToString([]byte(res.title())),
+	}
+	if res.CollectionID != "" {
+		meta = append(meta, "collection "+base64.StdEncoding.EncodeToString([]byte(res.CollectionID)))
+	}
+
+	endpoint := s.tusEndpoint()
+	req, err := s.newTUSRequest(ctx, "POST", endpoint.String(), entry.VideoID, nil, o)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Upload-Length", strconv.FormatInt(entry.Size, 10))
+	req.Header.Set("Upload-Metadata", strings.Join(meta, ","))
+
+	resp, err := s.send(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	loc, err := endpoint.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.Header.Get("Location") == "" {
+		return fmt.Errorf("invalid upload location %q", resp.Header.Get("Location"))
+	}
+	entry.UploadURL = loc.String()
+	return nil
+}
+
+// tusOffset asks the server how much of an upload it received.
+func (s *StreamClient) tusOffset(ctx context.Context, entry *tusStateEntry, o VideoUploadOptions) (int64, error) {
+	req, err := s.newTUSRequest(ctx, "HEAD", entry.UploadURL, entry.VideoID, nil, o)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := s.send(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	offset, err := strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 || offset > entry.Size {
+		return 0, fmt.Errorf("invalid Upload-Offset %q", resp.Header.Get("Upload-Offset"))
+	}
+	return offset, nil
+}
+
+// isTransientStreamError reports whether a failed Stream API request might
+// succeed when retried. Conflicting offsets are resolved by the next attempt
+// asking for the current one.
+func isTransientStreamError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var se *StreamErrorResponse
+	if errors.As(err, &se) {
+		switch se.StatusCode {
+		case http.StatusConflict, http.StatusLocked, http.StatusTooManyRequests:
+			return true
+		}
+		return se.StatusCode >= 500
+	}
+	// network errors
+	var ue *url.Error
+	return errors.As(err, &ue)
+}
+
+// videoProgress reports the progress of a video upload to
+// VideoUploadOptions.OnProgress.
+type videoProgress struct {
+	res   *VideoUploadResult
+	o     VideoUploadOptions
+	n     int64
+	total int64
+	last  time.Time
+}
+
+func (p *videoProgress) report(done bool) {
+	if p.o.OnProgress != nil {
+		p.o.OnProgress(VideoUploadProgress{p.res.VideoUpload, p.res.VideoID, p.n, p.total, p.res.Attempts, done})
+	}
+}
+
+func (p *videoProgress) finish() {
+	p.report(true)
+}
+
+type videoProgressReader struct {
+	r io.Reader
+	p *videoProgress
+}
+
+func (r *videoProgressReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.p.n += int64(n)
+	if time.Since(r.p.last) >= r.p.o.ProgressInterval {
+		r.p.last = time.Now()
+		r.p.report(false)
+	}
+	return n, err
+}
+
+// tusState is an append-only file of JSON lines, one for every change of an
+// upload. The last line of a file wins.
+type tusState struct {
+	mu      sync.Mutex
+	f       *os.File
+	uploads map[string]tusStateEntry
+}
+
+type tusStateEntry struct {
+	Path      string    `json:"path"`
+	Size      int64     `json:"size"`
+	ModTime   time.Time `json:"modTime"`
+	VideoID   string    `json:"videoId"`
+	UploadURL string    `json:"uploadUrl,omitempty"`
+	Offset    int64     `json:"offset"`
+	Done      bool      `json:"done,omitempty"`
+}
+
+func openTUSState(p string) (*tusState, error) {
+	f, err := os.OpenFile(p, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	st := &tusState{f: f, uploads: map[string]tusStateEntry{}}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		e := tusStateEntry{}
+		// a line cut off by a crash is ignored
+		if err := json.Unmarshal(scanner.Bytes(), &e); err == nil && e.Path != "" {
+			st.uploads[e.Path] = e
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return st, nil
+}
+
+// lookup returns the recorded upload of a file, if the file didn't change
+// since.
+func (st *tusState) lookup(abs string, info os.FileInfo) (tusStateEntry, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	e, ok := st.uploads[abs]
+	return e, ok && e.Size == info.Size() && e.ModTime.Equal(info.ModTime())
+}
+
+func (st *tusState) record(e tusStateEntry) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.uploads[e.Path] = e
+	if st.f == nil {
+		return nil
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = st.f.Write(append(b, '\n'))
+	return err
+}
+
+func (st *tusState) Close() error {
+	return st.f.Close()
+}
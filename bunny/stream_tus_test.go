@@ -0,0 +1,239 @@
+// Copyright (c) 2021 Jan Koppe
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package bunny
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeTUS is a TUS server in front of a fakeStream, checking the upload
+// signatures like the Stream API does.
+type fakeTUS struct {
+	*fakeStream
+	mu      sync.Mutex
+	uploads map[string]*fakeTUSUpload
+	// PATCH requests at or after this offset fail with the status code
+	failAt     int64
+	failStatus int
+	failCount  int
+	patches    int
+}
+
+type fakeTUSUpload struct {
+	videoID string
+	length  int64
+	data    []byte
+}
+
+func newFakeTUS() *fakeTUS {
+	return &fakeTUS{fakeStream: newFakeStream(), uploads: map[string]*fakeTUSUpload{}, failAt: -1}
+}
+
+func (f *fakeTUS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, "/tusupload") {
+		f.fakeStream.ServeHTTP(w, r)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	videoID := r.Header.Get("VideoId")
+	expire, _ := strconv.ParseInt(r.Header.Get("AuthorizationExpire"), 10, 64)
+	sum := sha256.Sum256([]byte(r.Header.Get("LibraryId") + "apikey" + r.Header.Get("AuthorizationExpire") + videoID))
+	if r.Header.Get("Tus-Resumable") != "1.0.0" || r.Header.Get("LibraryId") != "42" || expire < time.Now().Unix() ||
+		r.Header.Get("AuthorizationSignature") != hex.EncodeToString(sum[:]) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method == "POST" {
+		f.fakeStream.mu.Lock()
+		v := f.videos[videoID]
+		f.fakeStream.mu.Unlock()
+		length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+		if v == nil || err != nil || !strings.Contains(r.Header.Get("Upload-Metadata"), "filetype ") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		id := fmt.Sprintf("%d", len(f.uploads)+1)
+		f.uploads[id] = &fakeTUSUpload{videoID: videoID, length: length}
+		w.Header().Set("Location", "/tusupload/"+id)
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+
+	up := f.uploads[strings.TrimPrefix(r.URL.Path, "/tusupload/")]
+	if up == nil || up.videoID != videoID {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Upload-Length", strconv.Itoa(int(up.length)))
+	switch r.Method {
+	case "HEAD":
+		w.Header().Set("Upload-Offset", strconv.Itoa(len(up.data)))
+	case "PATCH":
+		f.patches++
+		offset, _ := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+		if offset != int64(len(up.data)) {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		if f.failAt >= 0 && offset >= f.failAt && f.failCount != 0 {
+			f.failCount--
+			w.WriteHeader(f.failStatus)
+			return
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		up.data = append(up.data, b...)
+		w.Header().Set("Upload-Offset", strconv.Itoa(len(up.data)))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// content returns the uploaded data of a video.
+func (f *fakeTUS) content(videoID string) []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, up := range f.uploads {
+		if up.videoID == videoID && int64(len(up.data)) == up.length {
+			return up.data
+		}
+	}
+	return nil
+}
+
+func writeRandomFile(t *testing.T, p string, size int) []byte {
+	b := make([]byte, size)
+	rand.Read(b)
+	if err := ioutil.WriteFile(p, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestUploadVideos(t *testing.T) {
+	f := newFakeTUS()
+	// the second chunk of one file fails once
+	f.failAt, f.failStatus, f.failCount = 1000, http.StatusServiceUnavailable, 1
+	s, done := newFakeStreamClient(t, f)
+	defer done()
+
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"intro.mp4": writeRandomFile(t, filepath.Join(dir, "intro.mp4"), 2500),
+		"empty.mp4": writeRandomFile(t, filepath.Join(dir, "empty.mp4"), 0),
+	}
+	existing, err := s.CreateVideo(context.Background(), "existing", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	finished := map[string]int64{}
+	report, err := s.UploadVideos(context.Background(), []VideoUpload{
+		{LocalPath: filepath.Join(dir, "intro.mp4"), CollectionID: "talks"},
+		{LocalPath: filepath.Join(dir, "empty.mp4"), VideoID: existing.Guid},
+		{LocalPath: filepath.Join(dir, "missing.mp4")},
+	}, &VideoUploadOptions{ChunkSize: 1000, RetryDelay: time.Millisecond, OnProgress: func(p VideoUploadProgress) {
+		if p.Done {
+			mu.Lock()
+			finished[filepath.Base(p.LocalPath)] = p.Bytes
+			mu.Unlock()
+		}
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Succeeded != 2 || report.Failed != 1 || report.Bytes != 2500 {
+		t.Errorf("unexpected report %v", report)
+	}
+
+	intro := report.Results[0]
+	if intro.Err != nil || intro.Attempts != 2 || intro.Bytes != 2500 {
+		t.Errorf("unexpected result %+v", intro)
+	}
+	if v := f.videos[intro.VideoID]; v == nil || v.Title != "intro" || v.CollectionID != "talks" {
+		t.Errorf("unexpected video %+v", v)
+	}
+	if !bytes.Equal(f.content(intro.VideoID), files["intro.mp4"]) {
+		t.Errorf("uploaded content differs")
+	}
+	if report.Results[1].VideoID != existing.Guid || report.Results[1].Err != nil {
+		t.Errorf("unexpected result %+v", report.Results[1])
+	}
+	if len(finished) != 2 || finished["intro.mp4"] != 2500 {
+		t.Errorf("unexpected progress %v", finished)
+	}
+}
+
+func TestUploadVideosResume(t *testing.T) {
+	f := newFakeTUS()
+	f.failAt, f.failStatus, f.failCount = 2000, http.StatusBadRequest, 1
+	s, done := newFakeStreamClient(t, f)
+	defer done()
+
+	dir := t.TempDir()
+	content := writeRandomFile(t, filepath.Join(dir, "master.mov"), 3500)
+	uploads := []VideoUpload{{LocalPath: filepath.Join(dir, "master.mov")}}
+	opts := &VideoUploadOptions{ChunkSize: 1000, StateFile: filepath.Join(dir, "uploads.state")}
+
+	report, err := s.UploadVideos(context.Background(), uploads, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := report.Results[0]; res.Err == nil || res.Bytes != 2000 || res.Attempts != 1 {
+		t.Fatalf("expected the upload to fail after two chunks, got %+v", res)
+	}
+
+	report, err = s.UploadVideos(context.Background(), uploads, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := report.Results[0]
+	if res.Err != nil || res.Resumed != 2000 || res.Bytes != 1500 {
+		t.Errorf("expected the upload to resume, got %+v", res)
+	}
+	if len(f.videos) != 1 || !bytes.Equal(f.content(res.VideoID), content) {
+		t.Errorf("uploaded content differs")
+	}
+
+	// the finished upload is skipped
+	patches := f.patches
+	report, err = s.UploadVideos(context.Background(), uploads, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := report.Results[0]; res.Err != nil || res.Attempts != 0 || res.Resumed != 3500 || f.patches != patches {
+		t.Errorf("expected the upload to be skipped, got %+v", res)
+	}
+}
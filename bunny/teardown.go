@@ -0,0 +1,418 @@
+// Copyright (c) 2021 Jan Koppe
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package bunny
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrStorageZoneInUse is returned by TeardownStorageZone if PullZones still
+// serve the StorageZone and neither deleting nor detaching them was
+// requested.
+var ErrStorageZoneInUse = errors.New("storage zone is still used by pull zones")
+
+// TeardownOptions decides what happens to the PullZones serving a
+// StorageZone that is torn down.
+type TeardownOptions struct {
+	// delete the PullZones together with the StorageZone
+	DeletePullZones bool
+	// keep the PullZones, pulling from this origin URL instead. Ignored
+	// if DeletePullZones is set.
+	DetachOrigin string
+	// only report what would be done
+	DryRun bool
+}
+
+// TeardownReport lists what TeardownStorageZone did, or would do in a dry
+// run.
+type TeardownReport struct {
+	StorageZone       *StorageZone
+	DeletedPullZones  []PullZone
+	DetachedPullZones []PullZone
+	DryRun            bool
+}
+
+// TeardownStorageZone deletes a StorageZone after deleting or detaching the
+// PullZones that serve it, which would otherwise break or block the delete.
+// The linked zones are looked up in the PullZone list, as the PullZones of a
+// StorageZone are not always up to date.
+func (c *Client) TeardownStorageZone(zoneID int64, opts *TeardownOptions) (*TeardownReport, error) {
+	o := TeardownOptions{}
+	if opts != nil {
+		o = *opts
+	}
+
+	sz, err := c.GetStorageZone(zoneID)
+	if err != nil {
+		return nil, err
+	}
+	pullZones, err := c.ListPullZones()
+	if err != nil {
+		return nil, err
+	}
+	linked := []PullZone{}
+	for _, pz := range *pullZones {
+		if pz.StorageZoneID == zoneID {
+			linked = append(linked, pz)
+		}
+	}
+	if len(linked) > 0 && !o.DeletePullZones && o.DetachOrigin == "" {
+		names := []string{}
+		for _, pz := range linked {
+			names = append(names, pz.Name)
+		}
+		return nil, fmt.Errorf("%w: %v", ErrStorageZoneInUse, strings.Join(names, ", "))
+	}
+
+	report := &TeardownReport{StorageZone: sz, DryRun: o.DryRun}
+	for _, pz := range linked {
+		if o.DeletePullZones {
+			if !o.DryRun {
+				if err := c.DeletePullZone(pz.ID); err != nil {
+					return report, fmt.Errorf("deleting pull zone %v: %w", pz.Name, err)
+				}
+			}
+			report.DeletedPullZones = append(report.DeletedPullZones, pz)
+			continue
+		}
+
+		if !o.DryRun {
+			// UpdatePullZone omits a zero StorageZoneID, so it can't
+			// unlink the zone
+			update := map[string]interface{}{
+				"OriginUrl":     o.DetachOrigin,
+				"StorageZoneId": 0,
+			}
+			if err := c.doRequest("POST", fmt.Sprintf("/pullzone/%v", pz.ID), "", update, nil); err != nil {
+				return report, fmt.Errorf("detaching pull zone %v: %w", pz.Name, err)
+			}
+		}
+		report.DetachedPullZones = append(report.DetachedPullZones, pz)
+	}
+
+	if o.DryRun {
+		return report, nil
+	}
+	return report, c.DeleteStorageZone(zoneID)
+}
+
+type OrphanKind int32
+
+const (
+	// a StorageZone that no PullZone serves
+	OKUnusedStorageZone OrphanKind = 0
+	// a VideoLibrary without videos
+	OKUnusedVideoLibrary OrphanKind = 1
+	// a PullZone whose origin doesn't respond, or only with server errors
+	OKUnreachableOrigin OrphanKind = 2
+	// a zone or library named with one of the temporary prefixes
+	OKTemporary OrphanKind = 3
+)
+
+func (k OrphanKind) String() string {
+	switch k {
+	case OKUnusedStorageZone:
+		return "unused storage zone"
+	case OKUnusedVideoLibrary:
+		return "unused video library"
+	case OKUnreachableOrigin:
+		return "unreachable origin"
+	case OKTemporary:
+		return "temporary"
+	}
+	return fmt.Sprintf("OrphanKind(%d)", int32(k))
+}
+
+// OrphanResource is the type of resource an Orphan refers to.
+type OrphanResource string
+
+const (
+	ORStorageZone  OrphanResource = "storage zone"
+	ORPullZone     OrphanResource = "pull zone"
+	ORVideoLibrary OrphanResource = "video library"
+)
+
+// Orphan is a resource that is likely no longer needed.
+type Orphan struct {
+	Resource OrphanResource
+	ID       int64
+	Name     string
+	Kind     OrphanKind
+	Reason   string
+	// whether DeleteOrphans may delete it. Unused StorageZones and
+	// VideoLibraries and PullZones with unreachable origins are only
+	// reported, unless OrphanOptions.DeleteUnused or DeleteUnreachable is
+	// set. Temporary StorageZones are only deletable if no other PullZone
+	// serves them.
+	Deletable bool
+	// set by DeleteOrphans
+	Deleted time.Time
+	Err     error
+}
+
+func (o Orphan) String() string {
+	return fmt.Sprintf("%v %v (%d): %v, %v", o.Resource, o.Name, o.ID, o.Kind, o.Reason)
+}
+
+// OrphanOptions configures FindOrphans.
+type OrphanOptions struct {
+	// name prefixes of temporary resources, defaults to "go-bunnynet-test",
+	// the prefix used by the tests of this library
+	TemporaryPrefixes []string
+	// request the origin of every PullZone with a URL origin
+	CheckOrigins bool
+	// used for the origin checks, defaults to a client with a 10 second
+	// timeout
+	HTTPClient *http.Client
+	// number of origins checked at the same time, defaults to 8
+	Concurrency int
+	// allow DeleteOrphans to delete unused StorageZones and VideoLibraries.
+	// They might just not be in use yet, so they are only reported by
+	// default.
+	DeleteUnused bool
+	// allow DeleteOrphans to delete PullZones with an unreachable origin.
+	// A single failed request might just be a temporary outage, so they are
+	// only reported by default.
+	DeleteUnreachable bool
+}
+
+// OrphanReport lists the orphans found by FindOrphans. Resources are listed
+// at most once, with the first matching kind in the order temporary, unused,
+// unreachable origin.
+type OrphanReport struct {
+	Orphans []Orphan
+	Deleted int
+	Failed  int
+}
+
+func (r *OrphanReport) String() string {
+	return fmt.Sprintf("%d orphans, %d deleted, %d failed", len(r.Orphans), r.Deleted, r.Failed)
+}
+
+// FindOrphans looks for resources of the account that are likely left over:
+// zones and libraries with a temporary name prefix, StorageZones without
+// PullZones, VideoLibraries without videos and, if requested, PullZones
+// whose origin is unreachable. Nothing is deleted. Only temporary resources
+// are marked as Deletable by default, see OrphanOptions.
+func (c *Client) FindOrphans(ctx context.Context, opts *OrphanOptions) (*OrphanReport, error) {
+	o := OrphanOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.TemporaryPrefixes == nil {
+		o.TemporaryPrefixes = []string{"go-bunnynet-test"}
+	}
+	if o.HTTPClient == nil {
+		o.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	if o.Concurrency <= 0 {
+		o.Concurrency = 8
+	}
+	temporary := func(name string) (string, bool) {
+		for _, p := range o.TemporaryPrefixes {
+			if p != "" && strings.HasPrefix(name, p) {
+				return fmt.Sprintf("name starts with %q", p), true
+			}
+		}
+		return "", false
+	}
+
+	storageZones, err := c.ListStorageZones()
+	if err != nil {
+		return nil, err
+	}
+	pullZones, err := c.ListPullZones()
+	if err != nil {
+		return nil, err
+	}
+	libraries, err := c.ListVideoLibraries()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &OrphanReport{}
+	served := map[int64]bool{}
+	// the first PullZone serving a StorageZone that isn't temporary itself
+	liveServer := map[int64]string{}
+	check := []PullZone{}
+	for _, pz := range *pullZones {
+		served[pz.StorageZoneID] = true
+		served[pz.LoggingStorageZoneID] = true
+		if _, ok := temporary(pz.Name); !ok {
+			for _, id := range []int64{pz.StorageZoneID, pz.LoggingStorageZoneID} {
+				if _, ok := liveServer[id]; !ok {
+					liveServer[id] = pz.Name
+				}
+			}
+		}
+		if reason, ok := temporary(pz.Name); ok {
+			report.Orphans = append(report.Orphans, Orphan{ORPullZone, pz.ID, pz.Name, OKTemporary, reason, true, time.Time{}, nil})
+		} else if o.CheckOrigins && pz.StorageZoneID == 0 && pz.VideoLibraryID == 0 && pz.OriginURL != "" {
+			check = append(check, pz)
+		}
+	}
+	for _, sz := range *storageZones {
+		if sz.Deleted {
+			continue
+		}
+		for _, pz := range sz.PullZones {
+			if _, ok := temporary(pz.Name); !ok && liveServer[sz.ID] == "" {
+				liveServer[sz.ID] = pz.Name
+			}
+		}
+		if reason, ok := temporary(sz.Name); ok {
+			// deleting it would break the PullZones serving it, unless
+			// they are temporary as well
+			deletable := liveServer[sz.ID] == ""
+			if !deletable {
+				reason += fmt.Sprintf(", but pull zone %v serves it", liveServer[sz.ID])
+			}
+			report.Orphans = append(report.Orphans, Orphan{ORStorageZone, sz.ID, sz.Name, OKTemporary, reason, deletable, time.Time{}, nil})
+		} else if !served[sz.ID] && len(sz.PullZones) == 0 {
+			report.Orphans = append(report.Orphans, Orphan{ORStorageZone, sz.ID, sz.Name, OKUnusedStorageZone, "no pull zone serves it", o.DeleteUnused, time.Time{}, nil})
+		}
+	}
+	for _, lib := range *libraries {
+		if reason, ok := temporary(lib.Name); ok {
+			report.Orphans = append(report.Orphans, Orphan{ORVideoLibrary, lib.ID, lib.Name, OKTemporary, reason, true, time.Time{}, nil})
+		} else if lib.VideoCount == 0 {
+			report.Orphans = append(report.Orphans, Orphan{ORVideoLibrary, lib.ID, lib.Name, OKUnusedVideoLibrary, "it has no videos", o.DeleteUnused, time.Time{}, nil})
+		}
+	}
+
+	unreachable := make([]string, len(check))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < o.Concurrency && w < len(check); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				unreachable[i] = checkOrigin(ctx, o.HTTPClient, check[i].OriginURL)
+			}
+		}()
+	}
+	for i := range check {
+		if ctx.Err() != nil {
+			break
+		}
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	for i, pz := range check {
+		if unreachable[i] != "" {
+			report.Orphans = append(report.Orphans, Orphan{ORPullZone, pz.ID, pz.Name, OKUnreachableOrigin, unreachable[i], o.DeleteUnreachable, time.Time{}, nil})
+		}
+	}
+
+	sort.SliceStable(report.Orphans, func(i, j int) bool {
+		return orphanDeleteOrder(report.Orphans[i].Resource) < orphanDeleteOrder(report.Orphans[j].Resource)
+	})
+	return report, nil
+}
+
+// orphanDeleteOrder sorts PullZones before the StorageZones they might
+// still reference.
+func orphanDeleteOrder(r OrphanResource) int {
+	switch r {
+	case ORPullZone:
+		return 0
+	case ORStorageZone:
+		return 1
+	}
+	return 2
+}
+
+// checkOrigin returns why an origin is considered unreachable, or "".
+func checkOrigin(ctx context.Context, hc *http.Client, origin string) string {
+	req, err := http.NewRequest("HEAD", origin, nil)
+	if err != nil {
+		return fmt.Sprintf("invalid origin: %v", err)
+	}
+	resp, err := hc.Do(req.WithContext(ctx))
+	if err != nil {
+		if ctx.Err() != nil {
+			return ""
+		}
+		return fmt.Sprintf("origin request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Sprintf("origin responded with %v", resp.Status)
+	}
+	return ""
+}
+
+// DeleteOrphans deletes the deletable orphans of a report for which confirm
+// returns true. confirm is required, pass a function returning true to
+// delete all of them. Failed deletes are recorded in the report and don't
+// stop the run; an error is only returned if confirm is nil or the context
+// was cancelled.
+func (c *Client) DeleteOrphans(ctx context.Context, report *OrphanReport, confirm func(Orphan) bool) error {
+	if confirm == nil {
+		return errors.New("no confirm function provided")
+	}
+	for i := range report.Orphans {
+		o := &report.Orphans[i]
+		if !o.Deletable || !o.Deleted.IsZero() {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !confirm(*o) {
+			continue
+		}
+		if o.Err != nil {
+			// retry of a failed delete
+			o.Err = nil
+			report.Failed--
+		}
+
+		switch o.Resource {
+		case ORPullZone:
+			o.Err = c.DeletePullZone(o.ID)
+		case ORStorageZone:
+			o.Err = c.DeleteStorageZone(o.ID)
+		case ORVideoLibrary:
+			o.Err = c.DeleteVideoLibrary(o.ID)
+		default:
+			o.Err = fmt.Errorf("unknown resource %q", string(o.Resource))
+		}
+		if o.Err != nil {
+			report.Failed++
+			continue
+		}
+		o.Deleted = time.Now()
+		report.Deleted++
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+// Copyright (c) 2021 Jan Koppe
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package bunny
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestTeardownStorageZone(t *testing.T) {
+	api := newFakeAPI()
+	api.storageZones[1] = &StorageZone{ID: 1, Name: "site"}
+	api.pullZones[2] = &PullZone{ID: 2, Name: "site", StorageZoneID: 1}
+	api.pullZones[3] = &PullZone{ID: 3, Name: "site-eu", StorageZoneID: 1}
+	api.storageZones[4] = &StorageZone{ID: 4, Name: "other"}
+	api.pullZones[5] = &PullZone{ID: 5, Name: "other", StorageZoneID: 4}
+	c, done := newFakeAPIClient(t, api)
+	defer done()
+
+	if _, err := c.TeardownStorageZone(1, nil); !errors.Is(err, ErrStorageZoneInUse) {
+		t.Errorf("expected ErrStorageZoneInUse, got %v", err)
+	}
+
+	r, err := c.TeardownStorageZone(1, &TeardownOptions{DeletePullZones: true, DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.DeletedPullZones) != 2 || len(api.pullZones) != 3 || len(api.storageZones) != 2 {
+		t.Errorf("unexpected dry run %+v", r)
+	}
+
+	if _, err := c.TeardownStorageZone(1, &TeardownOptions{DeletePullZones: true}); err != nil {
+		t.Fatal(err)
+	}
+	if api.storageZones[1] != nil || api.pullZones[2] != nil || api.pullZones[3] != nil {
+		t.Errorf("zones were not deleted")
+	}
+
+	r, err = c.TeardownStorageZone(4, &TeardownOptions{DetachOrigin: "https://origin.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pz := api.pullZones[5]; len(r.DetachedPullZones) != 1 || pz.StorageZoneID != 0 || pz.OriginURL != "https://origin.example.com" {
+		t.Errorf("pull zone was not detached: %+v", pz)
+	}
+	if api.storageZones[4] != nil {
+		t.Errorf("storage zone was not deleted")
+	}
+}
+
+func TestFindOrphans(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer origin.Close()
+
+	api := newFakeAPI()
+	api.storageZones[1] = &StorageZone{ID: 1, Name: "site"}
+	api.pullZones[2] = &PullZone{ID: 2, Name: "site", StorageZoneID: 1}
+	api.storageZones[3] = &StorageZone{ID: 3, Name: "forgotten"}
+	api.storageZones[4] = &StorageZone{ID: 4, Name: "go-bunnynet-testsync"}
+	api.pullZones[5] = &PullZone{ID: 5, Name: "go-bunnynet-testsync", StorageZoneID: 4}
+	api.pullZones[6] = &PullZone{ID: 6, Name: "api", OriginURL: origin.URL + "/ok"}
+	api.pullZones[7] = &PullZone{ID: 7, Name: "legacy", OriginURL: origin.URL + "/broken"}
+	api.libraries[8] = &VideoLibrary{ID: 8, Name: "talks", VideoCount: 12}
+	api.libraries[9] = &VideoLibrary{ID: 9, Name: "empty"}
+	api.storageZones[10] = &StorageZone{ID: 10, Name: "go-bunnynet-testshared"}
+	api.pullZones[11] = &PullZone{ID: 11, Name: "shop", StorageZoneID: 10}
+	c, done := newFakeAPIClient(t, api)
+	defer done()
+	ctx := context.Background()
+
+	report, err := c.FindOrphans(ctx, &OrphanOptions{CheckOrigins: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[int64]OrphanKind{}
+	for _, o := range report.Orphans {
+		got[o.ID] = o.Kind
+	}
+	want := map[int64]OrphanKind{3: OKUnusedStorageZone, 4: OKTemporary, 5: OKTemporary, 7: OKUnreachableOrigin, 9: OKUnusedVideoLibrary, 10: OKTemporary}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got orphans %v, want %v", report.Orphans, want)
+	}
+	if report.Orphans[0].Resource != ORPullZone || report.Orphans[len(report.Orphans)-1].Resource != ORVideoLibrary {
+		t.Errorf("pull zones need to be deleted first: %v", report.Orphans)
+	}
+
+	err = c.DeleteOrphans(ctx, report, func(o Orphan) bool { return o.Kind == OKTemporary })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Deleted != 2 || report.Failed != 0 || api.storageZones[4] != nil || api.pullZones[5] != nil {
+		t.Errorf("unexpected report %v", report)
+	}
+	if api.storageZones[3] == nil || api.libraries[9] == nil {
+		t.Errorf("unconfirmed orphans were deleted")
+	}
+	if api.storageZones[10] == nil {
+		t.Errorf("temporary storage zone served by a live pull zone was deleted")
+	}
+
+	all := func(Orphan) bool { return true }
+	if err := c.DeleteOrphans(ctx, report, nil); err == nil {
+		t.Error("deleting without a confirm function should fail")
+	}
+	if err := c.DeleteOrphans(ctx, report, all); err != nil {
+		t.Fatal(err)
+	}
+	if report.Deleted != 2 || api.pullZones[7] == nil || api.storageZones[3] == nil || api.libraries[9] == nil {
+		t.Errorf("unused resources and unreachable origins should only be reported by default: %v", report)
+	}
+
+	report, err = c.FindOrphans(ctx, &OrphanOptions{DeleteUnused: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteOrphans(ctx, report, all); err != nil {
+		t.Fatal(err)
+	}
+	if report.Deleted != 2 || api.storageZones[3] != nil || api.libraries[9] != nil || api.pullZones[7] == nil {
+		t.Errorf("unused resources were not deleted with DeleteUnused: %v", report)
+	}
+
+	report, err = c.FindOrphans(ctx, &OrphanOptions{CheckOrigins: true, DeleteUnreachable: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteOrphans(ctx, report, all); err != nil {
+		t.Fatal(err)
+	}
+	if report.Deleted != 1 || api.pullZones[7] != nil {
+		t.Errorf("unreachable origin was not deleted with DeleteUnreachable: %v", report)
+	}
+}